@@ -0,0 +1,443 @@
+package p2p
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var adminLogger = packageLogger.WithField("subpack", "admin")
+
+// AdminInfo is the response of the admin api's /info endpoint
+type AdminInfo struct {
+	NodeName   string    `json:"node_name"`
+	NodeID     uint32    `json:"node_id"`
+	Network    string    `json:"network"`
+	BindIP     string    `json:"bind_ip"`
+	ListenPort string    `json:"listen_port"`
+	Started    time.Time `json:"started"`
+	Info       Info      `json:"info"`
+	Incoming   int       `json:"incoming"`
+	Outgoing   int       `json:"outgoing"`
+}
+
+// AdminBan is a single active ban. The address is either an ip or ip:port
+type AdminBan struct {
+	Address string    `json:"address"`
+	Until   time.Time `json:"until"`
+}
+
+// AdminDialAttempt is the last time an endpoint was dialed
+type AdminDialAttempt struct {
+	Endpoint Endpoint  `json:"endpoint"`
+	Time     time.Time `json:"time"`
+	CanDial  bool      `json:"can_dial"`
+}
+
+// AdminDialer is the state of the dialer
+type AdminDialer struct {
+	Interval time.Duration      `json:"interval_ns"`
+	Timeout  time.Duration      `json:"timeout_ns"`
+	Attempts []AdminDialAttempt `json:"attempts"`
+}
+
+// AdminSeed is the content of the seed cache
+type AdminSeed struct {
+	URL       string        `json:"url"`
+	CacheTime time.Time     `json:"cache_time"`
+	CacheTTL  time.Duration `json:"cache_ttl_ns"`
+	Endpoints []Endpoint    `json:"endpoints"`
+}
+
+// AdminCAT is the state of the CAT rounds
+type AdminCAT struct {
+	Rounds    int           `json:"rounds"`
+	LastRound time.Time     `json:"last_round"`
+	NextRound time.Time     `json:"next_round"`
+	RoundTime time.Duration `json:"round_time_ns"`
+	Peers     int           `json:"peers"`
+	Target    uint          `json:"target"`
+	Max       uint          `json:"max"`
+	Drop      uint          `json:"drop"`
+	Incoming  uint          `json:"incoming"`
+	MinReseed uint          `json:"min_reseed"`
+}
+
+// AdminChannel is the fill level of a channel
+type AdminChannel struct {
+	Name     string `json:"name"`
+	Length   int    `json:"length"`
+	Capacity int    `json:"capacity"`
+}
+
+// AdminRequest is the body of the admin api's actions. Which fields are
+// used depends on the action:
+//
+//	/disconnect: Hash
+//	/ban: Hash or Address (ip or ip:port), optional Duration ("1h30m"), defaults to ManualBan
+//	/unban: Address (ip or ip:port)
+//	/dial: Endpoint (ip:port)
+//	/special: Special (comma separated list of endpoints)
+type AdminRequest struct {
+	Hash     string `json:"hash,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Endpoint string `json:"endpoint,omitempty"`
+	Duration string `json:"duration,omitempty"`
+	Special  string `json:"special,omitempty"`
+}
+
+// AdminDialResult is the response of the /dial action
+type AdminDialResult struct {
+	Success      bool       `json:"success"`
+	Alternatives []Endpoint `json:"alternatives,omitempty"`
+}
+
+// AdminResult is the response of actions that have no other data to return.
+// Error is set if the request could not be completed
+type AdminResult struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// adminServer serves the admin api of a network on tcp and/or a unix socket
+type adminServer struct {
+	net     *Network
+	mux     *http.ServeMux
+	started time.Time
+
+	mtx     sync.Mutex
+	servers []*http.Server
+
+	logger *log.Entry
+}
+
+func newAdminServer(n *Network) *adminServer {
+	a := new(adminServer)
+	a.net = n
+	a.logger = adminLogger.WithField("node", n.conf.NodeName)
+
+	a.mux = http.NewServeMux()
+	a.mux.HandleFunc("/info", a.get(a.info))
+	a.mux.HandleFunc("/peers", a.get(a.peers))
+	a.mux.HandleFunc("/peers/", a.get(a.peer))
+	a.mux.HandleFunc("/bans", a.get(a.bans))
+	a.mux.HandleFunc("/special", a.special)
+	a.mux.HandleFunc("/dialer", a.get(a.dialer))
+	a.mux.HandleFunc("/seed", a.get(a.seed))
+	a.mux.HandleFunc("/cat", a.get(a.cat))
+	a.mux.HandleFunc("/channels", a.get(a.channels))
+	a.mux.HandleFunc("/config", a.get(a.config))
+
+	a.mux.HandleFunc("/disconnect", a.post(a.disconnect))
+	a.mux.HandleFunc("/ban", a.post(a.ban))
+	a.mux.HandleFunc("/unban", a.post(a.unban))
+	a.mux.HandleFunc("/dial", a.post(a.dial))
+	return a
+}
+
+// Start opens the configured listeners. The tcp listener is refused without
+// an AdminToken since it may be reachable from other hosts
+func (a *adminServer) Start() error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.started = a.net.clock.Now()
+
+	if a.net.conf.AdminAddress != "" && a.net.conf.AdminToken == "" {
+		return fmt.Errorf("refusing to serve the admin api on %s without an AdminToken", a.net.conf.AdminAddress)
+	}
+
+	if a.net.conf.AdminAddress != "" {
+		l, err := net.Listen("tcp", a.net.conf.AdminAddress)
+		if err != nil {
+			return fmt.Errorf("unable to listen on %s: %v", a.net.conf.AdminAddress, err)
+		}
+		a.serve(l)
+	}
+
+	if a.net.conf.AdminSocket != "" {
+		// remove a socket left over from an unclean shutdown
+		if fi, err := os.Stat(a.net.conf.AdminSocket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(a.net.conf.AdminSocket)
+		}
+		l, err := net.Listen("unix", a.net.conf.AdminSocket)
+		if err != nil {
+			return fmt.Errorf("unable to listen on socket %s: %v", a.net.conf.AdminSocket, err)
+		}
+		a.serve(l)
+	}
+	return nil
+}
+
+func (a *adminServer) serve(l net.Listener) {
+	a.logger.Infof("Serving admin api on %s", l.Addr())
+	srv := &http.Server{Handler: a}
+	a.servers = append(a.servers, srv)
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			a.logger.WithError(err).Error("admin server stopped")
+		}
+	}()
+}
+
+// Stop closes all listeners
+func (a *adminServer) Stop() {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	for _, srv := range a.servers {
+		srv.Close()
+	}
+	a.servers = nil
+}
+
+// ServeHTTP checks the bearer token before passing the request on
+func (a *adminServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if token := a.net.conf.AdminToken; token != "" {
+		auth := req.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(auth[7:]), []byte(token)) != 1 {
+			rw.Header().Set("WWW-Authenticate", "Bearer")
+			a.fail(rw, http.StatusUnauthorized, "invalid token")
+			return
+		}
+	}
+	a.mux.ServeHTTP(rw, req)
+}
+
+func (a *adminServer) get(f func(req *http.Request) (interface{}, int, error)) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			a.fail(rw, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		v, code, err := f(req)
+		a.respond(rw, v, code, err)
+	}
+}
+
+func (a *adminServer) post(f func(ar AdminRequest) (interface{}, int, error)) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			a.fail(rw, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(rw, req.Body, 1<<16))
+		if err != nil {
+			a.fail(rw, http.StatusBadRequest, err.Error())
+			return
+		}
+		var ar AdminRequest
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, &ar); err != nil {
+				a.fail(rw, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+				return
+			}
+		}
+		a.logger.Debugf("admin action %s %+v", req.URL.Path, ar)
+		v, code, err := f(ar)
+		a.respond(rw, v, code, err)
+	}
+}
+
+func (a *adminServer) respond(rw http.ResponseWriter, v interface{}, code int, err error) {
+	if err != nil {
+		a.fail(rw, code, err.Error())
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		a.logger.WithError(err).Debug("unable to write response")
+	}
+}
+
+func (a *adminServer) fail(rw http.ResponseWriter, code int, msg string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(AdminResult{Error: msg})
+}
+
+func (a *adminServer) info(req *http.Request) (interface{}, int, error) {
+	c := a.net.controller
+	return AdminInfo{
+		NodeName:   a.net.conf.NodeName,
+		NodeID:     a.net.conf.NodeID,
+		Network:    a.net.conf.Network.String(),
+		BindIP:     a.net.conf.BindIP,
+		ListenPort: a.net.conf.ListenPort,
+		Started:    a.started,
+		Info:       a.net.GetInfo(),
+		Incoming:   c.peers.Incoming(),
+		Outgoing:   c.peers.Outgoing(),
+	}, http.StatusOK, nil
+}
+
+func (a *adminServer) peers(req *http.Request) (interface{}, int, error) {
+	peers := a.net.controller.peers.Slice()
+	metrics := make([]PeerMetrics, 0, len(peers))
+	for _, p := range peers {
+		metrics = append(metrics, p.GetMetrics())
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Hash < metrics[j].Hash })
+	return metrics, http.StatusOK, nil
+}
+
+func (a *adminServer) peer(req *http.Request) (interface{}, int, error) {
+	hash := strings.TrimPrefix(req.URL.Path, "/peers/")
+	p := a.net.controller.peers.Get(hash)
+	if p == nil {
+		return nil, http.StatusNotFound, fmt.Errorf("peer %s not found", hash)
+	}
+	return p.GetMetrics(), http.StatusOK, nil
+}
+
+func (a *adminServer) bans(req *http.Request) (interface{}, int, error) {
+	bans := make([]AdminBan, 0)
+	for addr, t := range a.net.controller.activeBans() {
+		bans = append(bans, AdminBan{Address: addr, Until: t})
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Address < bans[j].Address })
+	return bans, http.StatusOK, nil
+}
+
+func (a *adminServer) dialer(req *http.Request) (interface{}, int, error) {
+	d := a.net.controller.dialer
+	res := AdminDialer{Interval: d.interval, Timeout: d.timeout, Attempts: make([]AdminDialAttempt, 0)}
+	for ep, t := range d.Attempts() {
+		res.Attempts = append(res.Attempts, AdminDialAttempt{Endpoint: ep, Time: t, CanDial: d.CanDial(ep)})
+	}
+	sort.Slice(res.Attempts, func(i, j int) bool { return res.Attempts[i].Time.After(res.Attempts[j].Time) })
+	return res, http.StatusOK, nil
+}
+
+func (a *adminServer) seed(req *http.Request) (interface{}, int, error) {
+	s := a.net.controller.seed
+	eps, t := s.cached()
+	return AdminSeed{URL: s.url, CacheTime: t, CacheTTL: s.cacheTTL, Endpoints: eps}, http.StatusOK, nil
+}
+
+func (a *adminServer) cat(req *http.Request) (interface{}, int, error) {
+	c := a.net.controller
+	conf := a.net.conf
+	c.roundMtx.RLock()
+	last := c.lastRound
+	c.roundMtx.RUnlock()
+	return AdminCAT{
+		Rounds:    a.net.Rounds(),
+		LastRound: last,
+		NextRound: last.Add(conf.RoundTime),
+		RoundTime: conf.RoundTime,
+		Peers:     c.peers.Total(),
+		Target:    conf.Target,
+		Max:       conf.Max,
+		Drop:      conf.Drop,
+		Incoming:  conf.Incoming,
+		MinReseed: conf.MinReseed,
+	}, http.StatusOK, nil
+}
+
+func (a *adminServer) channels(req *http.Request) (interface{}, int, error) {
+	c := a.net.controller
+	chans := []AdminChannel{
+		{Name: "ToNetwork", Length: len(a.net.ToNetwork), Capacity: cap(a.net.ToNetwork)},
+		{Name: "FromNetwork", Length: len(a.net.FromNetwork), Capacity: cap(a.net.FromNetwork)},
+		{Name: "peerData", Length: len(c.peerData), Capacity: cap(c.peerData)},
+		{Name: "peerStatus", Length: len(c.peerStatus), Capacity: cap(c.peerStatus)},
+	}
+	peers := c.peers.Slice()
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Hash < peers[j].Hash })
+	for _, p := range peers {
+		chans = append(chans, AdminChannel{Name: "send " + p.Hash, Length: len(p.send), Capacity: cap(p.send)})
+	}
+	return chans, http.StatusOK, nil
+}
+
+func (a *adminServer) config(req *http.Request) (interface{}, int, error) {
+	return redactConfig(*a.net.conf), http.StatusOK, nil
+}
+
+// redactConfig hides the admin token of a configuration
+func redactConfig(conf Configuration) Configuration {
+	if conf.AdminToken != "" {
+		conf.AdminToken = "********"
+	}
+	return conf
+}
+
+func (a *adminServer) special(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		a.respond(rw, append(make([]Endpoint, 0), a.net.controller.getSpecial()...), http.StatusOK, nil)
+	case http.MethodPost:
+		a.post(func(ar AdminRequest) (interface{}, int, error) {
+			a.net.controller.setSpecial(ar.Special)
+			return append(make([]Endpoint, 0), a.net.controller.getSpecial()...), http.StatusOK, nil
+		})(rw, req)
+	default:
+		a.fail(rw, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (a *adminServer) disconnect(ar AdminRequest) (interface{}, int, error) {
+	if a.net.controller.peers.Get(ar.Hash) == nil {
+		return nil, http.StatusNotFound, fmt.Errorf("peer %s not found", ar.Hash)
+	}
+	a.net.controller.disconnect(ar.Hash)
+	return AdminResult{OK: true}, http.StatusOK, nil
+}
+
+func (a *adminServer) ban(ar AdminRequest) (interface{}, int, error) {
+	duration := a.net.conf.ManualBan
+	if ar.Duration != "" {
+		d, err := time.ParseDuration(ar.Duration)
+		if err != nil || d <= 0 {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid duration %q", ar.Duration)
+		}
+		duration = d
+	}
+
+	c := a.net.controller
+	switch {
+	case ar.Hash != "":
+		if c.peers.Get(ar.Hash) == nil {
+			return nil, http.StatusNotFound, fmt.Errorf("peer %s not found", ar.Hash)
+		}
+		c.ban(ar.Hash, duration)
+	case net.ParseIP(ar.Address) != nil:
+		c.banIP(ar.Address, duration)
+	default:
+		ep, err := ParseEndpoint(ar.Address)
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid address %q", ar.Address)
+		}
+		c.banEndpoint(ep, duration)
+	}
+	return AdminResult{OK: true}, http.StatusOK, nil
+}
+
+func (a *adminServer) unban(ar AdminRequest) (interface{}, int, error) {
+	if !a.net.controller.unban(ar.Address) {
+		return nil, http.StatusNotFound, fmt.Errorf("no ban for %q", ar.Address)
+	}
+	return AdminResult{OK: true}, http.StatusOK, nil
+}
+
+func (a *adminServer) dial(ar AdminRequest) (interface{}, int, error) {
+	ep, err := ParseEndpoint(ar.Endpoint)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid endpoint %q", ar.Endpoint)
+	}
+	if a.net.controller.isBannedEndpoint(ep) {
+		return nil, http.StatusForbidden, fmt.Errorf("endpoint %s is banned", ep)
+	}
+	ok, alts := a.net.controller.Dial(ep)
+	return AdminDialResult{Success: ok, Alternatives: alts}, http.StatusOK, nil
+}
@@ -0,0 +1,209 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// adminCall sends a request to the admin api and decodes the response into v
+func adminCall(t *testing.T, client *http.Client, method, url, token string, body interface{}, v interface{}) int {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req, err := http.NewRequest(method, url, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("unable to decode response of %s %s: %v", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAdmin_Auth(t *testing.T) {
+	conf := testMemoryConfig(NewMemoryTransport(), "10.0.0.1", "")
+	conf.AdminToken = "secret"
+	n, err := NewNetwork(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(n.AdminHandler())
+	defer srv.Close()
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "secrets", http.StatusUnauthorized},
+		{"correct token", "secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := adminCall(t, srv.Client(), http.MethodGet, srv.URL+"/info", tt.token, nil, nil); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	var config Configuration
+	adminCall(t, srv.Client(), http.MethodGet, srv.URL+"/config", "secret", nil, &config)
+	if config.AdminToken == "secret" {
+		t.Errorf("token was not redacted from the configuration")
+	}
+}
+
+func TestAdmin_StartRequiresToken(t *testing.T) {
+	conf := testMemoryConfig(NewMemoryTransport(), "10.0.0.1", "")
+	conf.AdminAddress = "127.0.0.1:0"
+	n, err := NewNetwork(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.admin.Start(); err == nil {
+		n.admin.Stop()
+		t.Fatal("admin api was served on tcp without a token")
+	}
+
+	n.conf.AdminToken = "secret"
+	if err := n.admin.Start(); err != nil {
+		t.Fatal(err)
+	}
+	n.admin.Stop()
+}
+
+func TestAdmin_Actions(t *testing.T) {
+	mt := NewMemoryTransport()
+	a, err := NewNetwork(testMemoryConfig(mt, "10.0.0.1", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewNetwork(testMemoryConfig(mt, "10.0.0.2", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Run()
+	b.Run()
+	defer a.Stop()
+	defer b.Stop()
+
+	srv := httptest.NewServer(a.AdminHandler())
+	defer srv.Close()
+	client := srv.Client()
+
+	var dial AdminDialResult
+	if code := adminCall(t, client, http.MethodPost, srv.URL+"/dial", "", AdminRequest{Endpoint: "10.0.0.2:8108"}, &dial); code != http.StatusOK || !dial.Success {
+		t.Fatalf("dial failed: %d %+v", code, dial)
+	}
+
+	var peers []PeerMetrics
+	adminCall(t, client, http.MethodGet, srv.URL+"/peers", "", nil, &peers)
+	if len(peers) != 1 || peers[0].PeerAddress != "10.0.0.2" {
+		t.Fatalf("unexpected peers %+v", peers)
+	}
+
+	var peer PeerMetrics
+	if code := adminCall(t, client, http.MethodGet, srv.URL+"/peers/"+peers[0].Hash, "", nil, &peer); code != http.StatusOK || peer.Hash != peers[0].Hash {
+		t.Errorf("unable to get single peer: %d %+v", code, peer)
+	}
+	if code := adminCall(t, client, http.MethodGet, srv.URL+"/peers/unknown", "", nil, nil); code != http.StatusNotFound {
+		t.Errorf("unknown peer returned status %d", code)
+	}
+
+	var res AdminResult
+	if code := adminCall(t, client, http.MethodPost, srv.URL+"/ban", "", AdminRequest{Hash: peers[0].Hash, Duration: "1h"}, &res); code != http.StatusOK || !res.OK {
+		t.Fatalf("ban failed: %d %+v", code, res)
+	}
+	if !waitFor(time.Second*5, func() bool { return a.Total() == 0 }) {
+		t.Errorf("banned peer still connected")
+	}
+
+	var bans []AdminBan
+	adminCall(t, client, http.MethodGet, srv.URL+"/bans", "", nil, &bans)
+	if len(bans) != 2 || bans[0].Address != "10.0.0.2" || bans[1].Address != "10.0.0.2:8108" {
+		t.Errorf("unexpected bans %+v", bans)
+	}
+
+	if code := adminCall(t, client, http.MethodPost, srv.URL+"/dial", "", AdminRequest{Endpoint: "10.0.0.2:8108"}, nil); code != http.StatusForbidden {
+		t.Errorf("dialing a banned endpoint returned status %d", code)
+	}
+
+	if code := adminCall(t, client, http.MethodPost, srv.URL+"/unban", "", AdminRequest{Address: "10.0.0.2"}, &res); code != http.StatusOK {
+		t.Errorf("unban failed: %d %+v", code, res)
+	}
+	bans = nil
+	adminCall(t, client, http.MethodGet, srv.URL+"/bans", "", nil, &bans)
+	if len(bans) != 0 {
+		t.Errorf("bans remaining after unban: %+v", bans)
+	}
+
+	var special []Endpoint
+	adminCall(t, client, http.MethodPost, srv.URL+"/special", "", AdminRequest{Special: "10.0.0.5:8108,10.0.0.6:8108"}, &special)
+	if len(special) != 2 || !a.controller.isSpecialIP("10.0.0.6") {
+		t.Errorf("unexpected special peers %+v", special)
+	}
+	adminCall(t, client, http.MethodPost, srv.URL+"/special", "", AdminRequest{Special: "10.0.0.5:8108"}, &special)
+	if len(special) != 1 || a.controller.isSpecialIP("10.0.0.6") {
+		t.Errorf("special peers were not replaced: %+v", special)
+	}
+
+	if code := adminCall(t, client, http.MethodGet, srv.URL+"/ban", "", nil, nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET on an action returned status %d", code)
+	}
+	if code := adminCall(t, client, http.MethodPost, srv.URL+"/ban", "", AdminRequest{Address: "nonsense"}, nil); code != http.StatusBadRequest {
+		t.Errorf("banning an invalid address returned status %d", code)
+	}
+}
+
+func TestAdmin_UnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p2padmin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := testMemoryConfig(NewMemoryTransport(), "10.0.0.1", "")
+	conf.AdminSocket = filepath.Join(dir, "admin.sock")
+	n, err := NewNetwork(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Run()
+	defer n.Stop()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return net.Dial("unix", conf.AdminSocket)
+		},
+	}}
+
+	var info AdminInfo
+	if code := adminCall(t, client, http.MethodGet, "http://unix/info", "", nil, &info); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if info.NodeName != conf.NodeName {
+		t.Errorf("unexpected node name %s", info.NodeName)
+	}
+}
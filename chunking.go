@@ -0,0 +1,193 @@
+package p2p
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// chunkHeaderLength is the size of the header in front of every chunk's data:
+// the 8 byte message id, the 4 byte index of the chunk, the 4 byte number
+// of chunks in the message, and the 2 byte type of the message
+const chunkHeaderLength = 18
+
+// partOverhead is the memory counted for every buffered part in addition to
+// its data, so many tiny parts can't get around the reassembly memory limit
+const partOverhead = 64
+
+// chunkable checks if parcels of a type carry application payloads that can be
+// split into chunks
+func chunkable(t ParcelType) bool {
+	switch t {
+	case TypeMessage, TypeRequest, TypeResponse, TypeTopicMessage:
+		return true
+	default:
+		return t >= TypeExtensionMin
+	}
+}
+
+// splitMessage splits a payload into TypeMessagePart parcels of up to size bytes of data
+func splitMessage(id uint64, typ ParcelType, payload []byte, size int) []*Parcel {
+	total := (len(payload) + size - 1) / size
+	parts := make([]*Parcel, 0, total)
+	for i := 0; i < total; i++ {
+		end := (i + 1) * size
+		if end > len(payload) {
+			end = len(payload)
+		}
+		data := payload[i*size : end]
+		chunk := make([]byte, chunkHeaderLength+len(data))
+		binary.BigEndian.PutUint64(chunk, id)
+		binary.BigEndian.PutUint32(chunk[8:], uint32(i))
+		binary.BigEndian.PutUint32(chunk[12:], uint32(total))
+		binary.BigEndian.PutUint16(chunk[16:], uint16(typ))
+		copy(chunk[chunkHeaderLength:], data)
+		parts = append(parts, newParcel(TypeMessagePart, chunk))
+	}
+	return parts
+}
+
+// parseChunk reads the header of a chunk created by splitMessage
+func parseChunk(payload []byte) (id uint64, index int, total int, typ ParcelType, data []byte, err error) {
+	if len(payload) <= chunkHeaderLength {
+		return 0, 0, 0, 0, nil, fmt.Errorf("chunk too short")
+	}
+	id = binary.BigEndian.Uint64(payload)
+	index = int(binary.BigEndian.Uint32(payload[8:]))
+	total = int(binary.BigEndian.Uint32(payload[12:]))
+	typ = ParcelType(binary.BigEndian.Uint16(payload[16:]))
+	return id, index, total, typ, payload[chunkHeaderLength:], nil
+}
+
+// chunkQueue holds the chunks of messages that are waiting to be sent.
+// Messages take turns so that one large message does not delay the others
+type chunkQueue struct {
+	messages [][]*Parcel
+}
+
+func (q *chunkQueue) add(chunks []*Parcel) {
+	q.messages = append(q.messages, chunks)
+}
+
+func (q *chunkQueue) empty() bool {
+	return len(q.messages) == 0
+}
+
+// next removes the next chunk from the queue and moves its message to the back
+func (q *chunkQueue) next() *Parcel {
+	if q.empty() {
+		return nil
+	}
+	msg := q.messages[0]
+	chunk := msg[0]
+	q.messages = q.messages[1:]
+	if len(msg) > 1 {
+		q.messages = append(q.messages, msg[1:])
+	}
+	return chunk
+}
+
+// partialMessage is a message of which not all parts have arrived yet
+type partialMessage struct {
+	parts   map[int][]byte
+	total   int
+	size    int // bytes of data
+	cost    int // bytes counted against the memory limit
+	started time.Time
+}
+
+// reassembler collects the parts of messages until they are complete.
+// Incomplete messages are discarded after the timeout and the memory used by
+// incomplete messages is limited.
+type reassembler struct {
+	clock   Clock
+	timeout time.Duration
+	limit   int
+	used    int
+	partial map[string]*partialMessage
+
+	// dropped is called for every discarded message with the reason
+	dropped func(reason string)
+}
+
+func newReassembler(clock Clock, timeout time.Duration, limit int, dropped func(reason string)) *reassembler {
+	r := new(reassembler)
+	r.clock = clock
+	r.timeout = timeout
+	r.limit = limit
+	r.partial = make(map[string]*partialMessage)
+	r.dropped = dropped
+	return r
+}
+
+// add stores one part of a message and returns the full payload once all parts
+// have arrived. Parts may arrive in any order. A nil payload and nil error means
+// the message is still incomplete
+func (r *reassembler) add(key string, index, total int, data []byte) ([]byte, error) {
+	r.expire()
+
+	if total < 1 || index < 0 || index >= total {
+		return nil, fmt.Errorf("part %d of %d is out of range", index, total)
+	}
+
+	pm, ok := r.partial[key]
+	if !ok {
+		pm = &partialMessage{parts: make(map[int][]byte), total: total, started: r.clock.Now()}
+		r.partial[key] = pm
+	}
+	if pm.total != total {
+		r.discard(key, "invalid")
+		return nil, fmt.Errorf("part %d has a different number of parts (%d) than the message (%d)", index, total, pm.total)
+	}
+	if _, ok := pm.parts[index]; ok {
+		return nil, nil // duplicate
+	}
+
+	cost := len(data) + partOverhead
+	if r.used+cost > r.limit {
+		r.discard(key, "memory")
+		return nil, fmt.Errorf("reassembly memory limit of %d bytes exceeded", r.limit)
+	}
+
+	pm.parts[index] = data
+	pm.size += len(data)
+	pm.cost += cost
+	r.used += cost
+
+	if len(pm.parts) < pm.total {
+		return nil, nil
+	}
+
+	payload := make([]byte, 0, pm.size)
+	for i := 0; i < pm.total; i++ {
+		payload = append(payload, pm.parts[i]...)
+	}
+	r.used -= pm.cost
+	delete(r.partial, key)
+	return payload, nil
+}
+
+// expire discards all messages that have not completed within the timeout
+func (r *reassembler) expire() {
+	for key, pm := range r.partial {
+		if r.clock.Since(pm.started) > r.timeout {
+			r.discard(key, "timeout")
+		}
+	}
+}
+
+func (r *reassembler) discard(key, reason string) {
+	if pm, ok := r.partial[key]; ok {
+		r.used -= pm.cost
+		delete(r.partial, key)
+		if r.dropped != nil {
+			r.dropped(reason)
+		}
+	}
+}
+
+// pending returns the number of incomplete messages and the bytes counted
+// against the memory limit
+func (r *reassembler) pending() (int, int) {
+	return len(r.partial), r.used
+}
@@ -0,0 +1,273 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func Test_splitMessage(t *testing.T) {
+	payload := make([]byte, 2500)
+	rand.New(rand.NewSource(1)).Read(payload)
+
+	chunks := splitMessage(7, TypeResponse, payload, 1000)
+	if len(chunks) != 3 {
+		t.Fatalf("split into %d chunks, want 3", len(chunks))
+	}
+
+	var joined []byte
+	for i, c := range chunks {
+		if c.Type != TypeMessagePart {
+			t.Errorf("chunk %d has type %s", i, c.Type)
+		}
+		id, index, total, typ, data, err := parseChunk(c.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != 7 || index != i || total != 3 || typ != TypeResponse {
+			t.Errorf("chunk %d header = %d, %d, %d, %s", i, id, index, total, typ)
+		}
+		joined = append(joined, data...)
+	}
+	if !bytes.Equal(joined, payload) {
+		t.Errorf("chunks do not add up to the payload")
+	}
+
+	if _, _, _, _, _, err := parseChunk(make([]byte, chunkHeaderLength)); err == nil {
+		t.Errorf("parseChunk() accepted a chunk without data")
+	}
+}
+
+func Test_chunkQueue(t *testing.T) {
+	var q chunkQueue
+	q.add([]*Parcel{newParcel(TypeMessagePart, []byte("a1")), newParcel(TypeMessagePart, []byte("a2")), newParcel(TypeMessagePart, []byte("a3"))})
+	q.add([]*Parcel{newParcel(TypeMessagePart, []byte("b1"))})
+	q.add([]*Parcel{newParcel(TypeMessagePart, []byte("c1")), newParcel(TypeMessagePart, []byte("c2"))})
+
+	var order []string
+	for !q.empty() {
+		order = append(order, string(q.next().Payload))
+	}
+	want := []string{"a1", "b1", "c1", "a2", "c2", "a3"}
+	if len(order) != len(want) {
+		t.Fatalf("got %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("got %v, want %v", order, want)
+		}
+	}
+}
+
+func Test_reassembler(t *testing.T) {
+	clock := NewVirtualClock(time.Unix(1000, 0))
+	drops := make(map[string]int)
+	r := newReassembler(clock, time.Minute, 2*(60+partOverhead)-1, func(reason string) { drops[reason]++ })
+
+	// out of order with a duplicate
+	steps := []struct {
+		index int
+		data  string
+		want  string
+	}{
+		{2, "cc", ""},
+		{0, "aa", ""},
+		{2, "cc", ""},
+		{1, "bb", "aabbcc"},
+	}
+	for _, s := range steps {
+		payload, err := r.add("x", s.index, 3, []byte(s.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(payload) != s.want {
+			t.Errorf("add(%d) = %q, want %q", s.index, payload, s.want)
+		}
+	}
+	if n, used := r.pending(); n != 0 || used != 0 {
+		t.Errorf("pending after completion = %d, %d", n, used)
+	}
+
+	// invalid parts
+	if _, err := r.add("y", 3, 3, []byte("d")); err == nil {
+		t.Errorf("accepted index out of range")
+	}
+	r.add("y", 0, 3, []byte("d"))
+	if _, err := r.add("y", 1, 4, []byte("d")); err == nil {
+		t.Errorf("accepted mismatched total")
+	}
+
+	// memory limit
+	r.add("m", 0, 2, make([]byte, 60))
+	if _, err := r.add("n", 0, 2, make([]byte, 60)); err == nil {
+		t.Errorf("exceeded memory limit without error")
+	}
+	if n, used := r.pending(); n != 1 || used != 60+partOverhead {
+		t.Errorf("pending after memory drop = %d, %d, want 1, %d", n, used, 60+partOverhead)
+	}
+
+	// tiny parts are limited by their overhead
+	tiny := newReassembler(clock, time.Minute, 3*(1+partOverhead), func(reason string) { drops[reason]++ })
+	for i := 0; i < 3; i++ {
+		if _, err := tiny.add("t", i, 1000, []byte{1}); err != nil {
+			t.Fatalf("tiny part %d rejected: %v", i, err)
+		}
+	}
+	if _, err := tiny.add("t", 3, 1000, []byte{1}); err == nil {
+		t.Errorf("exceeded memory limit with tiny parts")
+	}
+
+	// timeout
+	clock.Advance(time.Minute * 2)
+	r.add("z", 0, 2, []byte("z"))
+	if n, used := r.pending(); n != 1 || used != 1+partOverhead {
+		t.Errorf("pending after timeout = %d, %d, want 1, %d", n, used, 1+partOverhead)
+	}
+
+	want := map[string]int{"invalid": 1, "memory": 2, "timeout": 1}
+	for reason, count := range want {
+		if drops[reason] != count {
+			t.Errorf("%s drops = %d, want %d", reason, drops[reason], count)
+		}
+	}
+}
+
+func TestNetwork_Chunking(t *testing.T) {
+	for _, version := range []uint16{9, 10} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			mt := NewMemoryTransport()
+
+			confA := testMemoryConfig(mt, "10.0.0.1", "")
+			confA.ChunkSize = 1000
+			confA.EnableCompression = false
+			confB := testMemoryConfig(mt, "10.0.0.2", "10.0.0.1:8108")
+			confB.ProtocolVersion = version
+			confB.ChunkSize = 1000
+			confB.EnableCompression = false
+
+			a, err := NewNetwork(confA)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := NewNetwork(confB)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			a.Run()
+			b.Run()
+			defer a.Stop()
+			defer b.Stop()
+
+			if !waitFor(time.Second*5, func() bool { return a.Total() == 1 && b.Total() == 1 }) {
+				t.Fatalf("nodes did not connect: a = %d, b = %d", a.Total(), b.Total())
+			}
+
+			large := make([]byte, 10500)
+			rand.New(rand.NewSource(1)).Read(large)
+			b.ToNetwork.Send(NewParcel(Broadcast, large))
+			b.ToNetwork.Send(NewParcel(Broadcast, []byte("small")))
+
+			received := make(map[string]bool)
+			for len(received) < 2 {
+				select {
+				case p := <-a.FromNetwork:
+					if p.Type != TypeMessage {
+						t.Errorf("received parcel of type %s", p.Type)
+					}
+					received[string(p.Payload)] = true
+				case <-time.After(time.Second * 5):
+					t.Fatalf("only %d of 2 parcels arrived", len(received))
+				}
+			}
+			if !received[string(large)] || !received["small"] {
+				t.Errorf("received the wrong payloads")
+			}
+
+			if version < 10 {
+				return
+			}
+
+			// requests and responses are chunked with their type intact
+			a.HandleRequests(func(peer string, payload []byte) []byte {
+				return append(payload, payload...)
+			})
+			var hash string
+			for h := range b.GetPeerMetrics() {
+				hash = h
+			}
+			reply, err := b.Request(context.Background(), hash, large)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(reply, append(large, large...)) {
+				t.Errorf("received the wrong reply of %d bytes", len(reply))
+			}
+		})
+	}
+}
+
+func TestPeer_shouldChunk(t *testing.T) {
+	conf := DefaultP2PConfiguration()
+	conf.ChunkSize = 10
+	n := &Network{conf: &conf}
+	large := make([]byte, 11)
+
+	tests := []struct {
+		name   string
+		prot   Protocol
+		parcel *Parcel
+		want   bool
+	}{
+		{"small message", new(ProtocolV10), newParcel(TypeMessage, large[:10]), false},
+		{"large message", new(ProtocolV10), newParcel(TypeMessage, large), true},
+		{"large response", new(ProtocolV10), newParcel(TypeResponse, large), true},
+		{"large topic message", new(ProtocolV10), newParcel(TypeTopicMessage, large), true},
+		{"large custom type", new(ProtocolV10), newParcel(TypeExtensionMin, large), true},
+		{"large peer response", new(ProtocolV10), newParcel(TypePeerResponse, large), false},
+		{"v9 message", new(ProtocolV9), newParcel(TypeMessage, large), true},
+		{"v9 response", new(ProtocolV9), newParcel(TypeResponse, large), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Peer{net: n, prot: tt.prot, chunking: true}
+			if got := p.shouldChunk(tt.parcel); got != tt.want {
+				t.Errorf("shouldChunk() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPeer_deadlines(t *testing.T) {
+	conf := DefaultP2PConfiguration()
+	n := &Network{conf: &conf}
+
+	tests := []struct {
+		name    string
+		version uint16
+		caps    Capability
+		read    time.Duration
+		write   time.Duration
+	}{
+		{"v9", 9, 0, conf.ReadDeadline, conf.WriteDeadline},
+		{"v10 without chunking", 10, CapDeflate, conf.ReadDeadline, conf.WriteDeadline},
+		{"v10 with chunking", 10, CapChunking, conf.ChunkReadDeadline, conf.ChunkWriteDeadline},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Peer{net: n}
+			hs := new(Handshake)
+			hs.Header.Version = tt.version
+			hs.Capabilities = tt.caps
+			if err := p.bootstrapProtocol(hs, nil, nil, nil); err != nil {
+				t.Fatal(err)
+			}
+			if p.readDeadline != tt.read || p.writeDeadline != tt.write {
+				t.Errorf("deadlines = %s, %s, want %s, %s", p.readDeadline, p.writeDeadline, tt.read, tt.write)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package p2p
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for all timers and timestamps of the package.
+// The default is the SystemClock. A VirtualClock can be used to control the
+// passage of time in tests and simulations.
+//
+// Deadlines of connections are not affected by the Clock and always use real time.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+}
+
+var _ Clock = (*SystemClock)(nil)
+var _ Clock = (*VirtualClock)(nil)
+
+// SystemClock is a wrapper for the functions of the time package
+type SystemClock struct{}
+
+// Now returns the current local time
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// Since returns the time elapsed since t
+func (SystemClock) Since(t time.Time) time.Duration { return time.Since(t) }
+
+// Sleep pauses for at least the duration d
+func (SystemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// After waits for the duration to elapse and then sends the current time on the returned channel
+func (SystemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// VirtualClock is a Clock that only moves forward when advanced manually.
+// Timers created via After and Sleep fire once the clock has been advanced past
+// their expiration.
+type VirtualClock struct {
+	mtx    sync.Mutex
+	now    time.Time
+	timers []virtualTimer // sorted by expiration
+}
+
+type virtualTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// NewVirtualClock creates a new virtual clock starting at the given time
+func NewVirtualClock(start time.Time) *VirtualClock {
+	vc := new(VirtualClock)
+	vc.now = start
+	return vc
+}
+
+// Now returns the current virtual time
+func (vc *VirtualClock) Now() time.Time {
+	vc.mtx.Lock()
+	defer vc.mtx.Unlock()
+	return vc.now
+}
+
+// Since returns the virtual time elapsed since t
+func (vc *VirtualClock) Since(t time.Time) time.Duration {
+	return vc.Now().Sub(t)
+}
+
+// Sleep blocks until the clock has been advanced by at least d
+func (vc *VirtualClock) Sleep(d time.Duration) {
+	<-vc.After(d)
+}
+
+// After returns a channel that receives the virtual time once the clock has
+// been advanced by at least d
+func (vc *VirtualClock) After(d time.Duration) <-chan time.Time {
+	vc.mtx.Lock()
+	defer vc.mtx.Unlock()
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- vc.now
+		return c
+	}
+	at := vc.now.Add(d)
+	i := sort.Search(len(vc.timers), func(i int) bool { return vc.timers[i].at.After(at) })
+	vc.timers = append(vc.timers, virtualTimer{})
+	copy(vc.timers[i+1:], vc.timers[i:])
+	vc.timers[i] = virtualTimer{at: at, c: c}
+	return c
+}
+
+// Advance moves the clock forward by d, firing all timers that expire
+func (vc *VirtualClock) Advance(d time.Duration) {
+	vc.mtx.Lock()
+	defer vc.mtx.Unlock()
+	vc.now = vc.now.Add(d)
+	i := 0
+	for ; i < len(vc.timers) && !vc.timers[i].at.After(vc.now); i++ {
+		vc.timers[i].c <- vc.now
+	}
+	vc.timers = vc.timers[i:]
+}
+
+// Timers returns the number of timers waiting to fire
+func (vc *VirtualClock) Timers() int {
+	vc.mtx.Lock()
+	defer vc.mtx.Unlock()
+	return len(vc.timers)
+}
@@ -0,0 +1,72 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVirtualClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	vc := NewVirtualClock(start)
+
+	if !vc.Now().Equal(start) {
+		t.Errorf("clock did not start at %s, got %s", start, vc.Now())
+	}
+
+	late := vc.After(time.Minute)
+	early := vc.After(time.Second)
+	now := vc.After(0)
+
+	select {
+	case <-now:
+	default:
+		t.Errorf("timer with zero duration did not fire immediately")
+	}
+
+	vc.Advance(time.Millisecond * 999)
+	select {
+	case <-early:
+		t.Errorf("timer fired too early")
+	default:
+	}
+
+	vc.Advance(time.Millisecond)
+	select {
+	case fired := <-early:
+		if !fired.Equal(start.Add(time.Second)) {
+			t.Errorf("timer fired with the wrong time %s", fired)
+		}
+	default:
+		t.Errorf("timer did not fire")
+	}
+
+	if vc.Timers() != 1 {
+		t.Errorf("expected 1 remaining timer, got %d", vc.Timers())
+	}
+
+	vc.Advance(time.Hour)
+	select {
+	case <-late:
+	default:
+		t.Errorf("late timer did not fire")
+	}
+
+	if vc.Since(start) != time.Hour+time.Second {
+		t.Errorf("unexpected time since start: %s", vc.Since(start))
+	}
+
+	done := make(chan bool)
+	go func() {
+		vc.Sleep(time.Second)
+		close(done)
+	}()
+	for vc.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	vc.Advance(time.Second)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("sleep did not return after advancing the clock")
+	}
+}
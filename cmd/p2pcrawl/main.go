@@ -0,0 +1,143 @@
+// Command p2pcrawl crawls a factom p2p network and outputs the graph of all
+// reachable nodes in JSON and Graphviz DOT format.
+//
+// Usage:
+//
+//	p2pcrawl -network mainnet -seed https://url/of/seed.txt -dot network.dot
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	p2p "github.com/whosoup/factom-p2p"
+)
+
+func main() {
+	network := flag.String("network", "mainnet", "network to crawl: mainnet, testnet, localnet, a custom network name, or a hex id (0x...)")
+	seedURL := flag.String("seed", "", "url of a seed file to start the crawl from")
+	peers := flag.String("peers", "", "comma separated list of endpoints (ip:port) to start the crawl from")
+	bind := flag.String("bind", "", "local ip address to dial from")
+	port := flag.String("port", "8108", "listen port advertised in the handshake")
+	name := flag.String("name", "p2pcrawl", "node name used to generate the node id")
+	requests := flag.Int("requests", 5, "number of peer requests sent to each node")
+	concurrency := flag.Int("concurrency", 8, "number of nodes crawled simultaneously")
+	limit := flag.Int("limit", 0, "maximum number of nodes to visit, 0 for unlimited")
+	timeout := flag.Duration("timeout", time.Second*5, "timeout for dialing, the handshake, and peer requests")
+	jsonFile := flag.String("json", "", "file to write the json graph to, - for stdout")
+	dotFile := flag.String("dot", "", "file to write the graphviz dot graph to, - for stdout")
+	verbose := flag.Bool("v", false, "verbose logging")
+	flag.Parse()
+
+	if *verbose {
+		log.SetLevel(log.DebugLevel)
+	} else {
+		log.SetLevel(log.WarnLevel)
+	}
+
+	netID, err := p2p.ParseNetworkID(*network)
+	if err != nil {
+		fatal(err)
+	}
+
+	start, err := parseEndpoints(*peers)
+	if err != nil {
+		fatal(err)
+	}
+	if *seedURL != "" {
+		err := p2p.WebScanner(*seedURL, func(line string) {
+			if ep, err := p2p.ParseEndpoint(strings.TrimSpace(line)); err == nil {
+				start = append(start, ep)
+			}
+		})
+		if err != nil {
+			fatal(fmt.Errorf("unable to read seed file: %v", err))
+		}
+	}
+	if len(start) == 0 {
+		fatal(fmt.Errorf("no endpoints to start from, use -seed or -peers"))
+	}
+
+	conf := p2p.DefaultP2PConfiguration()
+	conf.Network = netID
+	conf.NodeName = *name
+	conf.BindIP = *bind
+	conf.ListenPort = *port
+	conf.DialTimeout = *timeout
+	conf.HandshakeTimeout = *timeout
+
+	crawler, err := p2p.NewCrawler(conf)
+	if err != nil {
+		fatal(err)
+	}
+	crawler.Requests = *requests
+	crawler.Concurrency = *concurrency
+	crawler.Limit = *limit
+	crawler.ResponseTimeout = *timeout
+
+	res := crawler.Crawl(start)
+
+	reachable := 0
+	for _, n := range res.Nodes {
+		if n.Reachable {
+			reachable++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Crawled %d nodes (%d reachable) with %d edges in %s\n", len(res.Nodes), reachable, len(res.Edges), res.Duration.Round(time.Millisecond))
+
+	if *jsonFile == "" && *dotFile == "" {
+		*jsonFile = "-"
+	}
+
+	if *jsonFile != "" {
+		data, err := json.MarshalIndent(res, "", "\t")
+		if err != nil {
+			fatal(err)
+		}
+		if err := output(*jsonFile, data); err != nil {
+			fatal(err)
+		}
+	}
+
+	if *dotFile != "" {
+		if err := output(*dotFile, []byte(res.DOT())); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// parseEndpoints parses a comma separated list of endpoints
+func parseEndpoints(s string) ([]p2p.Endpoint, error) {
+	var eps []p2p.Endpoint
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		ep, err := p2p.ParseEndpoint(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint %s: %v", item, err)
+		}
+		eps = append(eps, ep)
+	}
+	return eps, nil
+}
+
+func output(file string, data []byte) error {
+	if file == "-" {
+		_, err := os.Stdout.Write(append(data, '\n'))
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "p2pcrawl: %v\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	p2p "github.com/whosoup/factom-p2p"
+)
+
+func Test_parseEndpoints(t *testing.T) {
+	got, err := parseEndpoints("127.0.0.1:8108, 10.0.0.1:8110,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []p2p.Endpoint{{IP: "127.0.0.1", Port: "8108"}, {IP: "10.0.0.1", Port: "8110"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseEndpoints() = %v, want %v", got, want)
+	}
+
+	if _, err := parseEndpoints("127.0.0.1"); err == nil {
+		t.Errorf("parseEndpoints() accepted endpoint without port")
+	}
+}
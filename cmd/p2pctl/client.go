@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	p2p "github.com/whosoup/factom-p2p"
+)
+
+// client talks to the admin api of a node
+type client struct {
+	http  *http.Client
+	base  string
+	token string
+}
+
+// newClient creates a client for the admin api at the tcp address addr or,
+// if socket is set, the unix socket
+func newClient(addr, socket, token string, timeout time.Duration) *client {
+	c := new(client)
+	c.token = token
+	c.http = &http.Client{Timeout: timeout}
+	if socket != "" {
+		c.base = "http://unix"
+		c.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+	} else if strings.HasPrefix(addr, "http://") || strings.HasPrefix(addr, "https://") {
+		c.base = strings.TrimSuffix(addr, "/")
+	} else {
+		c.base = "http://" + addr
+	}
+	return c
+}
+
+func (c *client) get(path string, v interface{}) error {
+	return c.do(http.MethodGet, path, nil, v)
+}
+
+func (c *client) post(path string, req p2p.AdminRequest, v interface{}) error {
+	return c.do(http.MethodPost, path, &req, v)
+}
+
+func (c *client) do(method, path string, body interface{}, v interface{}) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.base+path, &buf)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var res p2p.AdminResult
+		if json.Unmarshal(data, &res) == nil && res.Error != "" {
+			return fmt.Errorf("%s", res.Error)
+		}
+		return fmt.Errorf("unexpected response %s", resp.Status)
+	}
+
+	if v == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("unable to decode response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,369 @@
+// Command p2pctl administers a running node through its admin api.
+//
+// Usage:
+//
+//	p2pctl [flags] <command> [arguments]
+//
+// Commands:
+//
+//	peers                             list connected peers
+//	peer <hash>                       show the metrics of a single peer
+//	disconnect <hash>                 disconnect a peer
+//	ban <hash|ip|ip:port> [duration]  ban a peer or address
+//	unban <ip|ip:port>                lift a ban
+//	bans                              list active bans
+//	dial <ip:port>                    connect to an endpoint
+//	special                           list special peers
+//	special set <ip:port,...>         replace the special peers
+//	special add <ip:port>...          add special peers
+//	special remove <ip:port>...       remove special peers
+//	stats                             show node, CAT, and channel statistics
+//	config                            show the node's configuration
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	p2p "github.com/whosoup/factom-p2p"
+)
+
+func main() {
+	fs := flag.NewFlagSet("p2pctl", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8071", "address of the node's admin api")
+	socket := fs.String("socket", "", "path of the node's admin unix socket, overrides -addr")
+	token := fs.String("token", os.Getenv("P2PCTL_TOKEN"), "bearer token of the admin api, defaults to $P2PCTL_TOKEN")
+	timeout := fs.Duration("timeout", time.Second*30, "timeout of requests")
+	jsonOut := fs.Bool("json", false, "print the response as json instead of a table")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: p2pctl [flags] <command> [arguments]\n\n")
+		fmt.Fprintf(fs.Output(), "Commands: peers, peer, disconnect, ban, unban, bans, dial, special, stats, config\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[1:])
+
+	ctl := &ctl{client: newClient(*addr, *socket, *token, *timeout), out: os.Stdout, json: *jsonOut}
+	if err := ctl.run(fs.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "p2pctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// ctl executes commands and prints their results
+type ctl struct {
+	client *client
+	out    io.Writer
+	json   bool
+}
+
+func (c *ctl) run(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command given")
+	}
+	cmd, args := args[0], args[1:]
+
+	need := func(min, max int) error {
+		if len(args) < min || len(args) > max {
+			return fmt.Errorf("wrong number of arguments for %s", cmd)
+		}
+		return nil
+	}
+
+	switch cmd {
+	case "peers":
+		if err := need(0, 0); err != nil {
+			return err
+		}
+		return c.peers()
+	case "peer":
+		if err := need(1, 1); err != nil {
+			return err
+		}
+		return c.peer(args[0])
+	case "disconnect":
+		if err := need(1, 1); err != nil {
+			return err
+		}
+		return c.action("/disconnect", p2p.AdminRequest{Hash: args[0]})
+	case "ban":
+		if err := need(1, 2); err != nil {
+			return err
+		}
+		req := p2p.AdminRequest{}
+		if isAddress(args[0]) {
+			req.Address = args[0]
+		} else {
+			req.Hash = args[0]
+		}
+		if len(args) > 1 {
+			req.Duration = args[1]
+		}
+		return c.action("/ban", req)
+	case "unban":
+		if err := need(1, 1); err != nil {
+			return err
+		}
+		return c.action("/unban", p2p.AdminRequest{Address: args[0]})
+	case "bans":
+		if err := need(0, 0); err != nil {
+			return err
+		}
+		return c.bans()
+	case "dial":
+		if err := need(1, 1); err != nil {
+			return err
+		}
+		return c.dial(args[0])
+	case "special":
+		return c.special(args)
+	case "stats":
+		if err := need(0, 0); err != nil {
+			return err
+		}
+		return c.stats()
+	case "config":
+		if err := need(0, 0); err != nil {
+			return err
+		}
+		return c.config()
+	}
+	return fmt.Errorf("unknown command %q", cmd)
+}
+
+// isAddress checks if s is an ip or ip:port rather than a peer hash
+func isAddress(s string) bool {
+	if net.ParseIP(s) != nil {
+		return true
+	}
+	_, err := p2p.ParseEndpoint(s)
+	return err == nil
+}
+
+// print writes v as json or calls table to write it in a human readable form
+func (c *ctl) print(v interface{}, table func(w io.Writer)) error {
+	if c.json {
+		data, err := json.MarshalIndent(v, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(c.out, "%s\n", data)
+		return err
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+func (c *ctl) action(path string, req p2p.AdminRequest) error {
+	var res p2p.AdminResult
+	if err := c.client.post(path, req, &res); err != nil {
+		return err
+	}
+	return c.print(res, func(w io.Writer) { fmt.Fprintln(w, "ok") })
+}
+
+func (c *ctl) peers() error {
+	var peers []p2p.PeerMetrics
+	if err := c.client.get("/peers", &peers); err != nil {
+		return err
+	}
+	return c.print(peers, func(w io.Writer) {
+		fmt.Fprintln(w, "HASH\tDIR\tVERSION\tTYPE\tCONNECTED\tRTT\tMPS DOWN/UP\tBPS DOWN/UP\tCAPACITY\tDROPPED")
+		for _, p := range peers {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%.2f/%.2f\t%s/%s\t%.0f%%\t%d\n", p.Hash, direction(p.Incoming), p.ConnectionState, p.PeerType,
+				since(p.MomentConnected), p.RTT.Round(time.Microsecond*100), p.MPSDown, p.MPSUp, formatBytes(p.BPSDown), formatBytes(p.BPSUp), p.Capacity*100, p.Dropped)
+		}
+	})
+}
+
+func (c *ctl) peer(hash string) error {
+	var p p2p.PeerMetrics
+	if err := c.client.get("/peers/"+url.PathEscape(hash), &p); err != nil {
+		return err
+	}
+	return c.print(p, func(w io.Writer) { fields(w, p) })
+}
+
+func (c *ctl) bans() error {
+	var bans []p2p.AdminBan
+	if err := c.client.get("/bans", &bans); err != nil {
+		return err
+	}
+	return c.print(bans, func(w io.Writer) {
+		fmt.Fprintln(w, "ADDRESS\tUNTIL\tREMAINING")
+		for _, b := range bans {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", b.Address, b.Until.Format(time.RFC3339), time.Until(b.Until).Round(time.Second))
+		}
+	})
+}
+
+func (c *ctl) dial(endpoint string) error {
+	var res p2p.AdminDialResult
+	if err := c.client.post("/dial", p2p.AdminRequest{Endpoint: endpoint}, &res); err != nil {
+		return err
+	}
+	return c.print(res, func(w io.Writer) {
+		if res.Success {
+			fmt.Fprintf(w, "connected to %s\n", endpoint)
+			return
+		}
+		fmt.Fprintf(w, "unable to connect to %s\n", endpoint)
+		for _, alt := range res.Alternatives {
+			fmt.Fprintf(w, "\talternative: %s\n", alt)
+		}
+	})
+}
+
+func (c *ctl) special(args []string) error {
+	var special []p2p.Endpoint
+	if len(args) == 0 {
+		if err := c.client.get("/special", &special); err != nil {
+			return err
+		}
+		return c.printEndpoints(special)
+	}
+
+	sub, args := args[0], args[1:]
+	var raw string
+	switch sub {
+	case "set":
+		raw = strings.Join(args, ",")
+	case "add", "remove":
+		if len(args) == 0 {
+			return fmt.Errorf("no endpoints given")
+		}
+		if err := c.client.get("/special", &special); err != nil {
+			return err
+		}
+		list := make(map[string]bool)
+		var order []string
+		for _, ep := range special {
+			list[ep.String()] = true
+			order = append(order, ep.String())
+		}
+		for _, a := range args {
+			ep, err := p2p.ParseEndpoint(a)
+			if err != nil {
+				return fmt.Errorf("invalid endpoint %s: %v", a, err)
+			}
+			if sub == "add" && !list[ep.String()] {
+				order = append(order, ep.String())
+			}
+			list[ep.String()] = sub == "add"
+		}
+		var keep []string
+		for _, s := range order {
+			if list[s] {
+				keep = append(keep, s)
+			}
+		}
+		raw = strings.Join(keep, ",")
+	default:
+		return fmt.Errorf("unknown special command %q", sub)
+	}
+
+	special = nil
+	if err := c.client.post("/special", p2p.AdminRequest{Special: raw}, &special); err != nil {
+		return err
+	}
+	return c.printEndpoints(special)
+}
+
+func (c *ctl) printEndpoints(eps []p2p.Endpoint) error {
+	return c.print(eps, func(w io.Writer) {
+		for _, ep := range eps {
+			fmt.Fprintln(w, ep)
+		}
+	})
+}
+
+// stats combines the info, cat, and channels endpoints
+type stats struct {
+	Info     p2p.AdminInfo      `json:"info"`
+	CAT      p2p.AdminCAT       `json:"cat"`
+	Channels []p2p.AdminChannel `json:"channels"`
+}
+
+func (c *ctl) stats() error {
+	var s stats
+	if err := c.client.get("/info", &s.Info); err != nil {
+		return err
+	}
+	if err := c.client.get("/cat", &s.CAT); err != nil {
+		return err
+	}
+	if err := c.client.get("/channels", &s.Channels); err != nil {
+		return err
+	}
+	return c.print(s, func(w io.Writer) {
+		i := s.Info
+		fmt.Fprintf(w, "Node\t%s (%08x)\n", i.NodeName, i.NodeID)
+		fmt.Fprintf(w, "Network\t%s\n", i.Network)
+		fmt.Fprintf(w, "Listening\t%s:%s\n", i.BindIP, i.ListenPort)
+		fmt.Fprintf(w, "Uptime\t%s\n", since(i.Started))
+		fmt.Fprintf(w, "Peers\t%d (%d incoming, %d outgoing)\n", i.Info.Peers, i.Incoming, i.Outgoing)
+		fmt.Fprintf(w, "Messages\t%.2f/s down, %.2f/s up\n", i.Info.Receiving, i.Info.Sending)
+		fmt.Fprintf(w, "Traffic\t%s/s down, %s/s up\n", formatBytes(i.Info.Download), formatBytes(i.Info.Upload))
+		fmt.Fprintf(w, "CAT rounds\t%d (next in %s)\n", s.CAT.Rounds, time.Until(s.CAT.NextRound).Round(time.Second))
+		fmt.Fprintf(w, "CAT limits\ttarget %d, max %d, drop %d, incoming %d\n", s.CAT.Target, s.CAT.Max, s.CAT.Drop, s.CAT.Incoming)
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "CHANNEL\tFILL")
+		for _, ch := range s.Channels {
+			fmt.Fprintf(w, "%s\t%d/%d\n", ch.Name, ch.Length, ch.Capacity)
+		}
+	})
+}
+
+func (c *ctl) config() error {
+	var conf p2p.Configuration
+	if err := c.client.get("/config", &conf); err != nil {
+		return err
+	}
+	return c.print(conf, func(w io.Writer) { fields(w, conf) })
+}
+
+// fields writes all fields of a struct as "name value" rows
+func fields(w io.Writer, v interface{}) {
+	rv := reflect.ValueOf(v)
+	for i := 0; i < rv.NumField(); i++ {
+		f := rv.Type().Field(i)
+		if f.PkgPath != "" || f.Tag.Get("json") == "-" {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%v\n", f.Name, rv.Field(i).Interface())
+	}
+}
+
+func direction(incoming bool) string {
+	if incoming {
+		return "in"
+	}
+	return "out"
+}
+
+func since(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return time.Since(t).Round(time.Second).String()
+}
+
+// formatBytes formats a number of bytes with a binary unit
+func formatBytes(b float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	i := 0
+	for b >= 1024 && i < len(units)-1 {
+		b /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%s", b, units[i])
+}
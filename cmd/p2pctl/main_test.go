@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	p2p "github.com/whosoup/factom-p2p"
+)
+
+func testCtl(t *testing.T) (*ctl, *bytes.Buffer, func()) {
+	conf := p2p.DefaultP2PConfiguration()
+	conf.Network = p2p.LocalNet
+	conf.Transport = p2p.NewMemoryTransport()
+	conf.BindIP = "10.0.0.1"
+	conf.EnablePrometheus = false
+	conf.AdminToken = "secret"
+	n, err := p2p.NewNetwork(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(n.AdminHandler())
+
+	out := new(bytes.Buffer)
+	c := &ctl{client: newClient(srv.URL, "", "secret", time.Second*5), out: out}
+	return c, out, srv.Close
+}
+
+func Test_ctl_special(t *testing.T) {
+	c, out, done := testCtl(t)
+	defer done()
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"special", "set", "10.0.0.2:8108,10.0.0.3:8108"}, "10.0.0.2:8108\n10.0.0.3:8108\n"},
+		{[]string{"special", "add", "10.0.0.4:8108", "10.0.0.2:8108"}, "10.0.0.2:8108\n10.0.0.3:8108\n10.0.0.4:8108\n"},
+		{[]string{"special", "remove", "10.0.0.3:8108"}, "10.0.0.2:8108\n10.0.0.4:8108\n"},
+		{[]string{"special"}, "10.0.0.2:8108\n10.0.0.4:8108\n"},
+		{[]string{"special", "set"}, ""},
+	}
+	for _, tt := range tests {
+		out.Reset()
+		if err := c.run(tt.args); err != nil {
+			t.Errorf("%v: %v", tt.args, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%v = %q, want %q", tt.args, out.String(), tt.want)
+		}
+	}
+}
+
+func Test_ctl_bans(t *testing.T) {
+	c, out, done := testCtl(t)
+	defer done()
+
+	if err := c.run([]string{"ban", "10.0.0.5", "1h"}); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+	c.json = true
+	if err := c.run([]string{"bans"}); err != nil {
+		t.Fatal(err)
+	}
+	var bans []p2p.AdminBan
+	if err := json.Unmarshal(out.Bytes(), &bans); err != nil {
+		t.Fatalf("invalid json output: %v", err)
+	}
+	if len(bans) != 1 || bans[0].Address != "10.0.0.5" {
+		t.Errorf("unexpected bans %+v", bans)
+	}
+
+	if err := c.run([]string{"unban", "10.0.0.5"}); err != nil {
+		t.Error(err)
+	}
+	if err := c.run([]string{"unban", "10.0.0.5"}); err == nil || !strings.Contains(err.Error(), "no ban") {
+		t.Errorf("unbanning twice returned %v", err)
+	}
+}
+
+func Test_ctl_errors(t *testing.T) {
+	c, _, done := testCtl(t)
+	defer done()
+
+	for _, args := range [][]string{{}, {"unknown"}, {"peer"}, {"ban", "1", "2", "3"}, {"peer", "10.0.0.9:8108 00000000"}} {
+		if err := c.run(args); err == nil {
+			t.Errorf("%v did not return an error", args)
+		}
+	}
+
+	c.client.token = "wrong"
+	if err := c.run([]string{"peers"}); err == nil {
+		t.Errorf("request with wrong token succeeded")
+	}
+}
+
+func Test_isAddress(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"10.0.0.1", true},
+		{"10.0.0.1:8108", true},
+		{"::1", true},
+		{"10.0.0.1:8108 1a2b3c4d", false},
+	}
+	for _, tt := range tests {
+		if got := isAddress(tt.s); got != tt.want {
+			t.Errorf("isAddress(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// loadConfigFile applies the settings of a config file to the flagset.
+// The file contains one "name = value" pair per line, using the same names as
+// the command line flags. Lines starting with # are ignored.
+// Flags that were explicitly set on the command line take precedence.
+func loadConfigFile(fs *flag.FlagSet, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return applyConfig(fs, f)
+}
+
+func applyConfig(fs *flag.FlagSet, r io.Reader) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		split := strings.SplitN(text, "=", 2)
+		if len(split) != 2 {
+			return fmt.Errorf("line %d: expected \"name = value\"", line)
+		}
+		name := strings.TrimSpace(split[0])
+		value := strings.Trim(strings.TrimSpace(split[1]), `"`)
+
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("line %d: unknown setting %s", line, name)
+		}
+		if name == "config" || explicit[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	return scanner.Err()
+}
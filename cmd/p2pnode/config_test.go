@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_applyConfig(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	port := fs.String("port", "8108", "")
+	target := fs.Uint("target", 32, "")
+	round := fs.Duration("roundtime", time.Minute, "")
+	special := fs.String("special", "", "")
+
+	if err := fs.Parse([]string{"-port", "9000"}); err != nil {
+		t.Fatal(err)
+	}
+
+	file := `
+# comment
+port = 8110
+target = 16
+roundtime = 5m
+special = "1.2.3.4:8108,5.6.7.8:8108"
+`
+	if err := applyConfig(fs, strings.NewReader(file)); err != nil {
+		t.Fatal(err)
+	}
+
+	if *port != "9000" {
+		t.Errorf("command line flag was overwritten by config file: %s", *port)
+	}
+	if *target != 16 {
+		t.Errorf("target = %d, want 16", *target)
+	}
+	if *round != time.Minute*5 {
+		t.Errorf("roundtime = %s, want 5m", *round)
+	}
+	if *special != "1.2.3.4:8108,5.6.7.8:8108" {
+		t.Errorf("special = %s", *special)
+	}
+
+	for _, bad := range []string{"unknown = 1", "target", "target = abc"} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Uint("target", 32, "")
+		if err := applyConfig(fs, strings.NewReader(bad)); err == nil {
+			t.Errorf("config %q did not cause an error", bad)
+		}
+	}
+}
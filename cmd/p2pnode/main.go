@@ -0,0 +1,170 @@
+// Command p2pnode runs a standalone p2p node without an application.
+// It takes part in peering and relays application broadcasts without
+// interpreting them, which makes it suitable as an always-on bootstrap
+// point that improves the connectivity of the network.
+//
+// Every setting can be passed as a flag or put in a config file:
+//
+//	p2pnode -config node.conf -port 8110
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	p2p "github.com/whosoup/factom-p2p"
+)
+
+func main() {
+	def := p2p.DefaultP2PConfiguration()
+
+	fs := flag.NewFlagSet("p2pnode", flag.ExitOnError)
+	configFile := fs.String("config", "", "path to a config file with one \"name = value\" setting per line")
+	network := fs.String("network", "mainnet", "network to join: mainnet, testnet, localnet, a custom network name, or a hex id (0x...)")
+	name := fs.String("name", "p2pnode", "name of the node")
+	nodeID := fs.Uint("nodeid", 0, "node id, 0 to derive it from the name")
+	bind := fs.String("bind", def.BindIP, "ip address to bind to, blank for all")
+	port := fs.String("port", def.ListenPort, "port to listen on")
+	seed := fs.String("seed", def.SeedURL, "url of the seed file")
+	special := fs.String("special", def.Special, "comma separated list of special peers")
+	persist := fs.String("persist", def.PersistFile, "file to persist peers and bans in")
+	persistAge := fs.Duration("persistage", def.PersistAge, "maximum age of the persist file to bootstrap from")
+	target := fs.Uint("target", def.Target, "target number of connections")
+	max := fs.Uint("max", def.Max, "maximum number of connections")
+	drop := fs.Uint("drop", def.Drop, "number of connections to drop down to in a CAT round")
+	incoming := fs.Uint("incoming", def.Incoming, "maximum number of incoming connections")
+	minReseed := fs.Uint("minreseed", def.MinReseed, "number of connections below which the seed file is used")
+	fanout := fs.Uint("fanout", def.Fanout, "number of peers a broadcast is sent to")
+	selector := fs.String("selector", "uniform", "peer selection for broadcasts: uniform, latency, congestion, subnet")
+	roundTime := fs.Duration("roundtime", def.RoundTime, "duration of a CAT round")
+	peerShare := fs.Uint("peershare", def.PeerShareAmount, "number of peers to share")
+	protocol := fs.Uint("protocol", uint(def.ProtocolVersion), "preferred protocol version")
+	upload := fs.Uint64("upload", def.UploadLimit, "total upload limit in bytes per second, 0 for unlimited")
+	download := fs.Uint64("download", def.DownloadLimit, "total download limit in bytes per second, 0 for unlimited")
+	peerUpload := fs.Uint64("peerupload", def.PeerUploadLimit, "upload limit per peer in bytes per second, 0 for unlimited")
+	peerDownload := fs.Uint64("peerdownload", def.PeerDownloadLimit, "download limit per peer in bytes per second, 0 for unlimited")
+	specialUnlimited := fs.Bool("specialunlimited", def.SpecialUnlimited, "exempt special peers from bandwidth limits")
+	compression := fs.Bool("compression", def.EnableCompression, "offer DEFLATE compression to v10 peers")
+	compressionThreshold := fs.Uint("compressionthreshold", def.CompressionThreshold, "payload size in bytes below which payloads are not compressed")
+	chunkSize := fs.Uint("chunksize", def.ChunkSize, "size in bytes above which messages are split into chunks, 0 to disable")
+	relayTTL := fs.Duration("relayttl", time.Minute*10, "time to remember relayed messages, 0 to disable relaying")
+	httpAddr := fs.String("http", "localhost:8070", "address to serve the metrics endpoint on, blank to disable")
+	admin := fs.String("admin", "", "address to serve the admin api on, eg localhost:8071. Requires -admintoken")
+	adminSocket := fs.String("adminsocket", "", "path of a unix socket to serve the admin api on")
+	adminToken := fs.String("admintoken", "", "bearer token required by the admin api on -admin")
+	prom := fs.Bool("prometheus", true, "enable prometheus metrics")
+	logLevel := fs.String("loglevel", "info", "log level: debug, info, warn, error")
+
+	fs.Parse(os.Args[1:])
+	if *configFile != "" {
+		if err := loadConfigFile(fs, *configFile); err != nil {
+			fatal(fmt.Errorf("unable to load config file: %v", err))
+		}
+	}
+
+	if *admin != "" && *adminToken == "" {
+		fatal(fmt.Errorf("the admin api on -admin requires -admintoken"))
+	}
+
+	level, err := log.ParseLevel(*logLevel)
+	if err != nil {
+		fatal(err)
+	}
+	log.SetLevel(level)
+
+	netID, err := p2p.ParseNetworkID(*network)
+	if err != nil {
+		fatal(err)
+	}
+
+	peerSelector, err := p2p.ParsePeerSelector(*selector)
+	if err != nil {
+		fatal(err)
+	}
+
+	conf := def
+	conf.Network = netID
+	conf.NodeName = *name
+	conf.UserAgent = "p2pnode"
+	conf.Capabilities &^= p2p.CapFullNode // the node only relays messages
+	conf.NodeID = uint32(*nodeID)
+	conf.BindIP = *bind
+	conf.ListenPort = *port
+	conf.SeedURL = *seed
+	conf.Special = *special
+	conf.PersistFile = *persist
+	conf.PersistAge = *persistAge
+	conf.Target = *target
+	conf.Max = *max
+	conf.Drop = *drop
+	conf.Incoming = *incoming
+	conf.MinReseed = *minReseed
+	conf.Fanout = *fanout
+	conf.PeerSelector = peerSelector
+	conf.RoundTime = *roundTime
+	conf.PeerShareAmount = *peerShare
+	conf.ProtocolVersion = uint16(*protocol)
+	conf.UploadLimit = *upload
+	conf.DownloadLimit = *download
+	conf.PeerUploadLimit = *peerUpload
+	conf.PeerDownloadLimit = *peerDownload
+	conf.SpecialUnlimited = *specialUnlimited
+	conf.EnableCompression = *compression
+	conf.CompressionThreshold = *compressionThreshold
+	conf.ChunkSize = *chunkSize
+	conf.EnablePrometheus = *prom
+	conf.AdminAddress = *admin
+	conf.AdminSocket = *adminSocket
+	conf.AdminToken = *adminToken
+
+	n, err := p2p.NewNetwork(conf)
+	if err != nil {
+		fatal(err)
+	}
+	n.Run()
+
+	stop := make(chan bool)
+	if *relayTTL > 0 {
+		go newRelay(n, *relayTTL).run(stop)
+	} else {
+		go drain(n, stop)
+	}
+
+	var server *http.Server
+	if *httpAddr != "" && *prom {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		server = &http.Server{Addr: *httpAddr, Handler: mux}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.WithError(err).Error("http server stopped")
+			}
+		}()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	s := <-sig
+	log.Infof("Received %s, shutting down", s)
+
+	close(stop)
+	if server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		server.Shutdown(ctx)
+		cancel()
+	}
+	n.Stop()
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "p2pnode: %v\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	p2p "github.com/whosoup/factom-p2p"
+)
+
+// relay forwards every application message it receives to the network
+// as a broadcast, without interpreting the payload. Payloads that have been
+// seen within the ttl are not forwarded again
+type relay struct {
+	net *p2p.Network
+	ttl time.Duration
+
+	mtx  sync.Mutex
+	seen map[[sha256.Size]byte]time.Time
+
+	relayed   uint64
+	duplicate uint64
+}
+
+func newRelay(n *p2p.Network, ttl time.Duration) *relay {
+	r := new(relay)
+	r.net = n
+	r.ttl = ttl
+	r.seen = make(map[[sha256.Size]byte]time.Time)
+	return r
+}
+
+// fresh returns true if the payload has not been seen within the ttl
+// and marks it as seen
+func (r *relay) fresh(payload []byte, now time.Time) bool {
+	hash := sha256.Sum256(payload)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if t, ok := r.seen[hash]; ok && now.Sub(t) < r.ttl {
+		r.duplicate++
+		return false
+	}
+	r.seen[hash] = now
+	r.relayed++
+	return true
+}
+
+// cleanup removes all expired entries
+func (r *relay) cleanup(now time.Time) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for h, t := range r.seen {
+		if now.Sub(t) >= r.ttl {
+			delete(r.seen, h)
+		}
+	}
+}
+
+// run relays messages until the stop channel is closed
+func (r *relay) run(stop chan bool) {
+	ticker := time.NewTicker(r.ttl)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			r.cleanup(now)
+		case parcel := <-r.net.FromNetwork.Reader():
+			if r.fresh(parcel.Payload, time.Now()) {
+				r.net.ToNetwork.Send(p2p.NewParcel(p2p.Broadcast, parcel.Payload))
+			}
+		}
+	}
+}
+
+// drain discards all application messages until the stop channel is closed
+func drain(n *p2p.Network, stop chan bool) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-n.FromNetwork.Reader():
+		}
+	}
+}
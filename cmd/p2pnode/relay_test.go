@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_relay_fresh(t *testing.T) {
+	r := newRelay(nil, time.Minute)
+	now := time.Now()
+
+	if !r.fresh([]byte("foo"), now) {
+		t.Error("first message was not fresh")
+	}
+	if r.fresh([]byte("foo"), now.Add(time.Second)) {
+		t.Error("duplicate message was fresh")
+	}
+	if !r.fresh([]byte("bar"), now.Add(time.Second)) {
+		t.Error("different message was not fresh")
+	}
+	if !r.fresh([]byte("foo"), now.Add(time.Minute)) {
+		t.Error("message was not fresh after ttl")
+	}
+
+	if r.relayed != 3 || r.duplicate != 1 {
+		t.Errorf("unexpected counts: relayed = %d, duplicate = %d", r.relayed, r.duplicate)
+	}
+
+	r.cleanup(now.Add(time.Minute * 2))
+	if len(r.seen) != 0 {
+		t.Errorf("cleanup left %d entries", len(r.seen))
+	}
+}
@@ -0,0 +1,50 @@
+package p2p
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// maxDecompressedSize is the largest payload a compressed message may expand to
+const maxDecompressedSize = 128 << 20
+
+// flate writers allocate several hundred KiB, so they are reused
+var deflaters = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	},
+}
+
+// deflate compresses the payload
+func deflate(payload []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := deflaters.Get().(*flate.Writer)
+	defer deflaters.Put(w)
+	w.Reset(buf)
+	if _, err := w.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// inflate decompresses the payload, failing if it exceeds the limit
+func inflate(data []byte, limit int64) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	payload, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(payload)) > limit {
+		return nil, fmt.Errorf("decompressed payload exceeds %d bytes", limit)
+	}
+	return payload, nil
+}
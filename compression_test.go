@@ -0,0 +1,103 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math/rand"
+	"testing"
+)
+
+func Test_deflate(t *testing.T) {
+	payload := bytes.Repeat([]byte("factom entry "), 1000)
+	compressed, err := deflate(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed) >= len(payload) {
+		t.Errorf("compressed size %d is not smaller than %d", len(compressed), len(payload))
+	}
+
+	got, err := inflate(compressed, int64(len(payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("inflate() did not return the original payload")
+	}
+
+	if _, err := inflate(compressed, int64(len(payload)-1)); err == nil {
+		t.Errorf("inflate() exceeded the limit without an error")
+	}
+	if _, err := inflate([]byte("not deflate"), 100); err == nil {
+		t.Errorf("inflate() accepted invalid data")
+	}
+}
+
+func TestProtocolV10_compression(t *testing.T) {
+	conf := DefaultP2PConfiguration()
+	conf.CompressionThreshold = 100
+	n := &Network{conf: &conf}
+
+	small := []byte("small payload")
+	large := bytes.Repeat([]byte("0123456789"), 100)
+	random := make([]byte, 200)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	tests := []struct {
+		name           string
+		compress       bool
+		payload        []byte
+		wantCompressed bool
+	}{
+		{"disabled", false, large, false},
+		{"below threshold", true, small, false},
+		{"compressed", true, large, true},
+		{"incompressible", true, random, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			p := &Peer{net: n}
+			v10 := new(ProtocolV10)
+			v10.init(p, gob.NewDecoder(buf), gob.NewEncoder(buf), tt.compress)
+
+			if err := v10.Send(newParcel(TypeMessage, tt.payload)); err != nil {
+				t.Fatal(err)
+			}
+
+			var msg V10Msg
+			if err := gob.NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Compressed != tt.wantCompressed {
+				t.Errorf("compressed = %v, want %v", msg.Compressed, tt.wantCompressed)
+			}
+
+			parcel, err := v10.Receive()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(parcel.Payload, tt.payload) {
+				t.Errorf("received payload does not match")
+			}
+
+			if p.uncompressedSent != uint64(len(tt.payload)) || p.uncompressedReceived != uint64(len(tt.payload)) {
+				t.Errorf("uncompressed bytes = %d/%d, want %d", p.uncompressedSent, p.uncompressedReceived, len(tt.payload))
+			}
+			if p.compressedSent != uint64(len(msg.Payload)) || p.compressedReceived != uint64(len(msg.Payload)) {
+				t.Errorf("compressed bytes = %d/%d, want %d", p.compressedSent, p.compressedReceived, len(msg.Payload))
+			}
+		})
+	}
+
+	// a peer that did not negotiate compression rejects compressed payloads
+	buf := new(bytes.Buffer)
+	compressed, _ := deflate(large)
+	gob.NewEncoder(buf).Encode(V10Msg{Type: TypeMessage, Crc32: 0, Payload: compressed, Compressed: true})
+	v10 := new(ProtocolV10)
+	v10.init(&Peer{net: n}, gob.NewDecoder(buf), gob.NewEncoder(buf), false)
+	if _, err := v10.Receive(); err == nil {
+		t.Errorf("received compressed payload without negotiation")
+	}
+}
@@ -2,6 +2,8 @@ package p2p
 
 import (
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
@@ -23,6 +25,17 @@ type Configuration struct {
 	// NodeName is the internal name of the node
 	NodeName string
 
+	// UserAgent is the name of the software sent to peers in the handshake
+	UserAgent string
+	// SoftwareVersion is the version of the software sent to peers in the handshake
+	SoftwareVersion string
+	// Capabilities are advertised to peers in addition to the protocol features
+	// of this package, eg CapFullNode or application bits from CapApplication up
+	Capabilities Capability
+	// RequiredCapabilities are the capabilities a peer has to advertise in order
+	// to connect. 0 accepts all peers
+	RequiredCapabilities Capability
+
 	// === Peer Management Settings ===
 	// PeerRequestInterval dictates how often neighbors should be asked for an
 	// updated peer list
@@ -62,25 +75,51 @@ type Configuration struct {
 	// Fanout controls how many random peers are selected for propagating messages
 	// Higher values increase fault tolerance but also increase network congestion
 	Fanout uint
+	// PeerSelector picks the peers for broadcasts and RandomPeer parcels.
+	// Defaults to UniformSelector
+	PeerSelector PeerSelector `json:"-"`
 
 	// SeedURL is the URL of the remote seed file
 	SeedURL string // URL to a source of peer info
 
 	// === Connection Settings ===
 
+	// Transport is used to dial and accept connections. Defaults to TCP
+	Transport Transport `json:"-"`
+
+	// ConnectionGater is consulted before accepting, before dialing, and after
+	// the handshake of every connection. nil allows all connections
+	ConnectionGater ConnectionGater `json:"-"`
+
 	// BindIP is the ip address to bind to for listening and connecting
 	//
 	// leave blank to bind to all
 	BindIP string
 	// ListenPort is the port to listen to incoming tcp connections on
 	ListenPort string
+	// ExternalIP is the public ip address other nodes can reach this node at.
+	// Leave blank to discover it from the addresses peers observe
+	ExternalIP string
+	// ExternalConfirmations is the number of outgoing peers with different ips
+	// that have to observe the same address before it is used as the external ip
+	ExternalConfirmations uint
+	// DialBackInterval is the time to wait between dialing back the endpoints
+	// of incoming peers to verify they are reachable before sharing them
+	DialBackInterval time.Duration
+	// DialBackExpiry is how long the result of a dial back is kept before the
+	// endpoint is dialed back again
+	DialBackExpiry time.Duration
 	// ListenLimit is the lockout period of accepting connections from a single
 	// ip after having a successful connection from that ip
 	ListenLimit time.Duration
 
-	// PingInterval dictates the maximum amount of time a connection can be
-	// silent (no writes) before sending a Ping
+	// PingInterval dictates how often a Ping is sent to measure the round
+	// trip time and keep the connection alive. Peers that sent or received a
+	// parcel within the interval are not pinged
 	PingInterval time.Duration
+	// PingMissLimit is the number of consecutive pings without a Pong after
+	// which a peer is disconnected. 0 to disable
+	PingMissLimit uint
 
 	// RedialInterval dictates how long to wait between connection attempts
 	RedialInterval time.Duration
@@ -93,6 +132,12 @@ type Configuration struct {
 	HandshakeTimeout time.Duration
 	DialTimeout      time.Duration
 
+	// RequestTimeout is the maximum time Network.Request waits for a response
+	RequestTimeout time.Duration
+	// MaxPeerRequests is the number of requests from a single peer that are
+	// handled at the same time. Further requests are answered as busy
+	MaxPeerRequests uint
+
 	// ReadDeadline is the maximum acceptable time to read a single parcel
 	// if a connection takes longer, it is disconnected
 	ReadDeadline time.Duration
@@ -109,7 +154,67 @@ type Configuration struct {
 	// Should be large enough to accomodate bursts of traffic.
 	ChannelCapacity uint
 
-	EnablePrometheus bool // Enable prometheus logging. Disable if you run multiple instances
+	// EnableCompression offers DEFLATE compression to V10 peers. It is used
+	// if both sides of a connection enable it
+	EnableCompression bool
+	// CompressionThreshold is the payload size in bytes below which payloads
+	// are sent uncompressed
+	CompressionThreshold uint
+
+	// ChunkSize is the payload size in bytes above which application payloads
+	// are split into chunks of that size for V10 peers that support it. V9 peers
+	// only receive parts of TypeMessage parcels.
+	// Chunks of different messages are interleaved with other parcels. 0 to disable
+	ChunkSize uint
+	// ChunkTimeout is how long to wait for all chunks or V9 parts of a message
+	ChunkTimeout time.Duration
+	// ChunkMemory is the maximum number of bytes of incomplete messages
+	// buffered for each peer
+	ChunkMemory uint
+	// ChunkReadDeadline and ChunkWriteDeadline replace ReadDeadline and
+	// WriteDeadline for V10 peers that support chunking, since large messages
+	// are not sent in a single parcel. V9 peers use ReadDeadline and WriteDeadline
+	ChunkReadDeadline  time.Duration
+	ChunkWriteDeadline time.Duration
+
+	// === Bandwidth Settings ===
+	// All limits are in bytes per second, 0 for unlimited
+
+	// UploadLimit and DownloadLimit are shared by all peers
+	UploadLimit   uint64
+	DownloadLimit uint64
+	// PeerUploadLimit and PeerDownloadLimit apply to each peer individually
+	PeerUploadLimit   uint64
+	PeerDownloadLimit uint64
+	// SpecialUnlimited exempts special peers from all bandwidth limits
+	SpecialUnlimited bool
+
+	// Clock is the source of time for timers and timestamps. Defaults to the system clock
+	Clock Clock `json:"-"`
+	// RandomSeed seeds the random number generator used for peer selection.
+	// 0 seeds it with the current time. Nodes in the same network should not share a seed
+	RandomSeed int64
+
+	EnablePrometheus bool // Enable prometheus logging
+	// PrometheusRegisterer is the registry the metrics are registered with.
+	// Defaults to the global prometheus registry
+	PrometheusRegisterer prometheus.Registerer `json:"-"`
+	// PrometheusLabels are constant labels added to every metric, eg. the node name
+	// or network id. Multiple instances sharing a registerer need distinct labels,
+	// otherwise they share the same metrics
+	PrometheusLabels prometheus.Labels
+
+	// === Admin Settings ===
+
+	// AdminAddress is the tcp address (host:port) to serve the admin api on.
+	// Requires AdminToken. Leave blank to disable
+	AdminAddress string
+	// AdminSocket is the path of a unix socket to serve the admin api on.
+	// Leave blank to disable
+	AdminSocket string
+	// AdminToken is the bearer token required for all admin api requests.
+	// Leave blank to allow unauthenticated access on AdminSocket and AdminHandler
+	AdminToken string
 }
 
 // DefaultP2PConfiguration returns a network configuration with base values
@@ -118,6 +223,10 @@ func DefaultP2PConfiguration() (c Configuration) {
 	c.Network = MainNet
 	c.NodeID = 0
 	c.NodeName = "FNode0"
+	c.UserAgent = "factom-p2p"
+	c.SoftwareVersion = ""
+	c.Capabilities = CapFullNode
+	c.RequiredCapabilities = 0
 	c.ListenPort = "8108"
 
 	c.PeerRequestInterval = time.Second
@@ -131,6 +240,7 @@ func DefaultP2PConfiguration() (c Configuration) {
 
 	c.Incoming = 36
 	c.Fanout = 8
+	c.PeerSelector = UniformSelector{}
 	c.PeerShareAmount = 3 // CAT share
 	c.RoundTime = time.Minute * 15
 	c.Target = 32
@@ -138,23 +248,55 @@ func DefaultP2PConfiguration() (c Configuration) {
 	c.Drop = 30
 	c.MinReseed = 10
 
+	c.Transport = TCPTransport{}
 	c.BindIP = "" // bind to all
 	c.ListenPort = "8108"
+	c.ExternalIP = ""
+	c.ExternalConfirmations = 3
+	c.DialBackInterval = time.Second
+	c.DialBackExpiry = time.Hour
 	c.ListenLimit = time.Second
 	c.PingInterval = time.Second * 15
+	c.PingMissLimit = 4
 	c.RedialInterval = time.Minute * 2
 
 	c.ReadDeadline = time.Minute * 5     // high enough to accomodate large packets
 	c.WriteDeadline = time.Minute * 5    // but fail eventually
 	c.HandshakeTimeout = time.Second * 5 // can be quite low
 	c.DialTimeout = time.Second * 5      // can be quite low
+	c.RequestTimeout = time.Second * 30
+	c.MaxPeerRequests = 8
 
 	c.ProtocolVersion = 10
 	c.ProtocolVersionMinimum = 9
 
 	c.ChannelCapacity = 1000
 
+	c.EnableCompression = true
+	c.CompressionThreshold = 1024
+
+	c.ChunkSize = 64 * 1024
+	c.ChunkTimeout = time.Minute
+	c.ChunkMemory = 64 * 1024 * 1024
+	c.ChunkReadDeadline = time.Minute // longer than the ping interval
+	c.ChunkWriteDeadline = time.Second * 30
+
+	c.UploadLimit = 0
+	c.DownloadLimit = 0
+	c.PeerUploadLimit = 0
+	c.PeerDownloadLimit = 0
+	c.SpecialUnlimited = true
+
+	c.Clock = SystemClock{}
+	c.RandomSeed = 0
+
 	c.EnablePrometheus = true
+	c.PrometheusRegisterer = prometheus.DefaultRegisterer
+	c.PrometheusLabels = nil
+
+	c.AdminAddress = ""
+	c.AdminSocket = ""
+	c.AdminToken = ""
 	return
 }
 
@@ -163,4 +305,40 @@ func (c *Configuration) Sanitize() {
 	if c.Incoming > c.Max {
 		c.Incoming = c.Max
 	}
+	if c.Transport == nil {
+		c.Transport = TCPTransport{}
+	}
+	if c.PeerSelector == nil {
+		c.PeerSelector = UniformSelector{}
+	}
+	if c.Clock == nil {
+		c.Clock = SystemClock{}
+	}
+	if c.ExternalConfirmations == 0 {
+		c.ExternalConfirmations = 1
+	}
+	if c.DialBackInterval <= 0 {
+		c.DialBackInterval = time.Second
+	}
+	if c.DialBackExpiry <= 0 {
+		c.DialBackExpiry = time.Hour
+	}
+	if c.MaxPeerRequests == 0 {
+		c.MaxPeerRequests = 1
+	}
+	if c.ChunkTimeout <= 0 {
+		c.ChunkTimeout = time.Minute
+	}
+	if c.ChunkMemory == 0 {
+		c.ChunkMemory = 64 * 1024 * 1024
+	}
+	if c.ChunkReadDeadline <= 0 {
+		c.ChunkReadDeadline = c.ReadDeadline
+	}
+	if c.ChunkWriteDeadline <= 0 {
+		c.ChunkWriteDeadline = c.WriteDeadline
+	}
+	if c.PrometheusRegisterer == nil {
+		c.PrometheusRegisterer = prometheus.DefaultRegisterer
+	}
 }
@@ -17,10 +17,12 @@ type controller struct {
 
 	peerStatus chan peerStatus
 	peerData   chan peerParcel
+	customData chan peerParcel
 
-	peers    *PeerStore
-	dialer   *Dialer
-	listener *LimitedListener
+	peers       *PeerStore
+	dialer      *Dialer
+	listener    *LimitedListener
+	listenerMtx sync.Mutex
 
 	specialMtx   sync.RWMutex
 	specialCount int
@@ -31,9 +33,20 @@ type controller struct {
 	specialEndpoints []Endpoint
 	bootstrap        []Endpoint
 
-	shareListener map[uint32]func(*Parcel)
+	shareListener map[string]func(*Parcel) // peer hash => callback
 	shareMtx      sync.RWMutex
 
+	requestID      uint64 // atomic
+	requests       map[uint64]*pendingRequest
+	requestMtx     sync.Mutex
+	requestHandler RequestHandler
+
+	subscriptions   map[string]ParcelChannel // topic => application channel
+	subscriptionMtx sync.RWMutex
+
+	external  *externalAddress
+	dialBacks *dialBacks
+
 	lastPeerDial time.Time
 	lastPersist  time.Time
 
@@ -42,9 +55,10 @@ type controller struct {
 	connecting int
 
 	lastRound    time.Time
+	roundMtx     sync.RWMutex
 	seed         *seed
 	replenishing bool
-	rounds       int // TODO make prometheus
+	rounds       uint64 // atomic
 
 	logger *log.Entry
 }
@@ -63,21 +77,26 @@ func newController(network *Network) (*controller, error) {
 		"network": conf.Network})
 	c.logger.Debugf("Initializing Controller")
 
-	c.dialer, err = NewDialer(conf.BindIP, conf.RedialInterval, conf.DialTimeout)
+	c.dialer, err = NewDialer(conf.Transport, network.clock, conf.BindIP, conf.RedialInterval, conf.DialTimeout)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize dialer: %v", err)
 	}
-	c.lastPersist = time.Now()
+	c.lastPersist = network.clock.Now()
 
 	c.peerStatus = make(chan peerStatus, 10) // TODO reconsider this value
 	c.peerData = make(chan peerParcel, conf.ChannelCapacity)
+	c.customData = make(chan peerParcel, conf.ChannelCapacity)
 
 	c.special = make(map[string]bool)
-	c.shareListener = make(map[uint32]func(*Parcel))
+	c.shareListener = make(map[string]func(*Parcel))
+	c.requests = make(map[uint64]*pendingRequest)
+	c.subscriptions = make(map[string]ParcelChannel)
+	c.external = newExternalAddress(int(conf.ExternalConfirmations))
+	c.dialBacks = newDialBacks()
 
 	// CAT
-	c.lastRound = time.Now()
-	c.seed = newSeed(conf.SeedURL, conf.PeerReseedInterval)
+	c.lastRound = network.clock.Now()
+	c.seed = newSeed(conf.SeedURL, conf.PeerReseedInterval, network.clock)
 
 	c.peers = NewPeerStore()
 	c.setSpecial(conf.Special)
@@ -105,7 +124,7 @@ func (c *controller) ban(hash string, duration time.Duration) {
 	if peer != nil {
 		c.banMtx.Lock()
 
-		end := time.Now().Add(duration)
+		end := c.net.clock.Now().Add(duration)
 
 		// there's a stronger ban in place already
 		if existing, ok := c.bans[peer.Endpoint.IP]; ok && end.Before(existing) {
@@ -118,39 +137,106 @@ func (c *controller) ban(hash string, duration time.Duration) {
 
 		for _, p := range c.peers.Slice() {
 			if p.Endpoint.IP == peer.Endpoint.IP {
-				peer.Stop()
+				p.stopRetry(ReasonBanned, end.Sub(c.net.clock.Now()))
 			}
 		}
 		c.banMtx.Unlock()
+		c.net.emit(Event{Type: EventBanned, Address: peer.Endpoint.IP, Until: end})
+	}
+}
+
+// banIP bans an ip address for a duration and disconnects all peers from it
+func (c *controller) banIP(ip string, duration time.Duration) {
+	until := c.net.clock.Now().Add(duration)
+	c.banMtx.Lock()
+	c.bans[ip] = until
+	c.banMtx.Unlock()
+	c.net.emit(Event{Type: EventBanned, Address: ip, Until: until})
+
+	for _, p := range c.peers.Slice() {
+		if p.Endpoint.IP == ip {
+			p.stopRetry(ReasonBanned, duration)
+		}
+	}
+}
+
+// unban lifts the ban of an ip or endpoint. Unbanning an ip also lifts the
+// bans of all endpoints with that ip
+func (c *controller) unban(addr string) bool {
+	c.banMtx.Lock()
+	found := false
+	for a := range c.bans {
+		if a == addr {
+			found = true
+			delete(c.bans, a)
+		} else if ep, err := ParseEndpoint(a); err == nil && ep.IP == addr {
+			found = true
+			delete(c.bans, a)
+		}
+	}
+	c.banMtx.Unlock()
+
+	if found {
+		c.net.emit(Event{Type: EventUnbanned, Address: addr})
+	}
+	return found
+}
+
+// activeBans returns a copy of all bans that have not yet expired
+func (c *controller) activeBans() map[string]time.Time {
+	c.banMtx.RLock()
+	defer c.banMtx.RUnlock()
+	now := c.net.clock.Now()
+	bans := make(map[string]time.Time)
+	for a, t := range c.bans {
+		if now.Before(t) {
+			bans[a] = t
+		}
 	}
+	return bans
 }
 
 // ban a specific endpoint for a duration.
 // to nullify a ban, use a duration of zero.
 func (c *controller) banEndpoint(ep Endpoint, duration time.Duration) {
+	until := c.net.clock.Now().Add(duration)
 	c.banMtx.Lock()
-	c.bans[ep.String()] = time.Now().Add(duration)
+	c.bans[ep.String()] = until
 	c.banMtx.Unlock()
 
 	if duration > 0 {
+		c.net.emit(Event{Type: EventBanned, Address: ep.String(), Until: until})
 		for _, p := range c.peers.Slice() {
 			if p.Endpoint == ep {
-				p.Stop()
+				p.stopRetry(ReasonBanned, duration)
 			}
 		}
+	} else {
+		c.net.emit(Event{Type: EventUnbanned, Address: ep.String()})
 	}
 }
 
 func (c *controller) isBannedEndpoint(ep Endpoint) bool {
 	c.banMtx.RLock()
 	defer c.banMtx.RUnlock()
-	return time.Now().Before(c.bans[ep.IP]) || time.Now().Before(c.bans[ep.String()])
+	now := c.net.clock.Now()
+	return now.Before(c.bans[ep.IP]) || now.Before(c.bans[ep.String()])
+}
+
+// banRemaining returns the time until the ban of an ip ends
+func (c *controller) banRemaining(ip string) time.Duration {
+	c.banMtx.RLock()
+	defer c.banMtx.RUnlock()
+	if remaining := c.bans[ip].Sub(c.net.clock.Now()); remaining > 0 {
+		return remaining
+	}
+	return 0
 }
 
 func (c *controller) isBannedIP(ip string) bool {
 	c.banMtx.RLock()
 	defer c.banMtx.RUnlock()
-	return time.Now().Before(c.bans[ip])
+	return c.net.clock.Now().Before(c.bans[ip])
 }
 
 func (c *controller) isSpecial(ep Endpoint) bool {
@@ -168,18 +254,20 @@ func (c *controller) isSpecialIP(ip string) bool {
 func (c *controller) disconnect(hash string) {
 	peer := c.peers.Get(hash)
 	if peer != nil {
-		peer.Stop()
+		peer.stopWith(ReasonManual)
 	}
 }
 
+// setSpecial replaces the list of special endpoints
 func (c *controller) setSpecial(raw string) {
-	if len(raw) == 0 {
-		c.specialEndpoints = nil
-		return
+	var eps []Endpoint
+	if len(raw) > 0 {
+		eps = c.parseSpecial(raw)
 	}
-	c.specialEndpoints = c.parseSpecial(raw)
 	c.specialMtx.Lock()
-	for _, ep := range c.specialEndpoints {
+	c.specialEndpoints = eps
+	c.special = make(map[string]bool)
+	for _, ep := range eps {
 		c.logger.Debugf("Registering special endpoint %s", ep)
 		c.special[ep.String()] = true
 		c.special[ep.IP] = true
@@ -188,6 +276,13 @@ func (c *controller) setSpecial(raw string) {
 	c.specialMtx.Unlock()
 }
 
+// getSpecial returns a copy of the special endpoints
+func (c *controller) getSpecial() []Endpoint {
+	c.specialMtx.RLock()
+	defer c.specialMtx.RUnlock()
+	return append([]Endpoint(nil), c.specialEndpoints...)
+}
+
 func (c *controller) parseSpecial(raw string) []Endpoint {
 	var eps []Endpoint
 	split := strings.Split(raw, ",")
@@ -209,8 +304,47 @@ func (c *controller) Start() {
 
 	go c.run()          // cycle every 1s
 	go c.manageData()   // blocking on data
+	go c.manageCustom() // blocking on custom parcels
 	go c.manageOnline() // blocking on peer status changes
 	go c.listen()       // blocking on tcp connections
 	go c.catReplenish() // cycle every 1s
 	go c.route()        // route data
+	go c.dialBack()     // cycle every DialBackInterval
+}
+
+// Stop closes the listener and disconnects all peers. The loops started
+// in Start terminate once the network's globalCloser is closed
+func (c *controller) Stop() {
+	c.logger.Info("Stopping the Controller")
+
+	c.listenerMtx.Lock()
+	if c.listener != nil {
+		c.listener.Close()
+	}
+	c.listenerMtx.Unlock()
+
+	for _, p := range c.peers.Slice() {
+		p.Stop()
+	}
+}
+
+// stopped returns true if the network has been stopped
+func (c *controller) stopped() bool {
+	select {
+	case <-c.net.globalCloser:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait blocks for the given duration. Returns false if the network was stopped
+// in the meantime
+func (c *controller) wait(d time.Duration) bool {
+	select {
+	case <-c.net.clock.After(d):
+		return true
+	case <-c.net.globalCloser:
+		return false
+	}
 }
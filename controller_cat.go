@@ -2,18 +2,26 @@ package p2p
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // runs a single CAT round that persists peers and drops random connections.
 // this function is triggered once a second by the controller.run function
 func (c *controller) runCatRound() {
-	if time.Since(c.lastRound) < c.net.conf.RoundTime {
+	c.roundMtx.Lock()
+	if c.net.clock.Since(c.lastRound) < c.net.conf.RoundTime {
+		c.roundMtx.Unlock()
 		return
 	}
-	c.lastRound = time.Now()
+	c.lastRound = c.net.clock.Now()
+	c.roundMtx.Unlock()
 	c.logger.Debug("Cat Round")
-	c.rounds++
+	atomic.AddUint64(&c.rounds, 1)
+	if c.net.prom != nil {
+		c.net.prom.CATRounds.Inc()
+	}
 
 	c.persistPeerFile()
 
@@ -21,21 +29,25 @@ func (c *controller) runCatRound() {
 
 	toDrop := len(peers) - int(c.net.conf.Drop) // current - target amount
 
+	dropped := 0
 	if toDrop > 0 {
 		perm := c.net.rng.Perm(len(peers))
 
-		dropped := 0
 		for _, i := range perm {
 			if c.isSpecial(peers[i].Endpoint) {
 				continue
 			}
-			peers[i].Stop()
+			peers[i].stopWith(ReasonCATDrop)
 			dropped++
 			if dropped >= toDrop {
 				break
 			}
 		}
+		if c.net.prom != nil {
+			c.net.prom.CATDrops.Add(float64(dropped))
+		}
 	}
+	c.net.emit(Event{Type: EventCATRound, Dropped: dropped})
 }
 
 // processPeers processes a peer share response
@@ -57,13 +69,15 @@ func (c *controller) processPeerShare(peer *Peer, parcel *Parcel) []Endpoint {
 		ep, err := NewEndpoint(p.IP, p.Port)
 		if err != nil {
 			c.logger.WithError(err).Infof("Unable to register endpoint %s:%s from peer %s", p.IP, p.Port, peer)
-		} else if !c.isBannedEndpoint(ep) {
+		} else if !c.isBannedEndpoint(ep) && !c.isSelf(ep) {
 			res = append(res, ep)
 		}
 	}
 
 	if c.net.prom != nil {
 		c.net.prom.KnownPeers.Set(float64(c.peers.Total()))
+		c.net.prom.PeerShares.WithLabelValues(promReceived).Inc()
+		c.net.prom.PeerShareEndpoints.WithLabelValues(promReceived).Add(float64(len(list)))
 	}
 
 	return res
@@ -89,11 +103,11 @@ func (c *controller) makePeerShare(ep Endpoint) []Endpoint {
 
 	cmp := ep.String()
 	for _, i = range c.net.rng.Perm(len(tmp)) {
-		if tmp[i].Endpoint.String() == cmp {
+		if tmp[i].Endpoint.String() == cmp || !c.isReachable(tmp[i]) {
 			continue
 		}
 		list = append(list, tmp[i].Endpoint)
-		if uint(len(tmp)) >= c.net.conf.PeerShareAmount {
+		if uint(len(list)) >= c.net.conf.PeerShareAmount {
 			break
 		}
 	}
@@ -114,6 +128,11 @@ func (c *controller) sharePeers(peer *Peer, list []Endpoint) {
 	c.logger.Debugf("Sharing %d peers with %s", len(list), peer)
 	parcel := newParcel(TypePeerResponse, payload)
 	peer.Send(parcel)
+
+	if c.net.prom != nil {
+		c.net.prom.PeerShares.WithLabelValues(promSent).Inc()
+		c.net.prom.PeerShareEndpoints.WithLabelValues(promSent).Add(float64(len(list)))
+	}
 }
 
 // this function is only intended to be run single-threaded inside the replenish loop
@@ -125,16 +144,19 @@ func (c *controller) asyncPeerRequest(peer *Peer) ([]Endpoint, error) {
 
 	var share []Endpoint
 	async := make(chan bool, 1)
+	var once sync.Once // only the first response is used
 	f := func(parcel *Parcel) {
-		share = c.trimShare(c.processPeerShare(peer, parcel), true)
-		async <- true
+		once.Do(func() {
+			share = c.trimShare(c.processPeerShare(peer, parcel), true)
+			async <- true
+		})
 	}
-	c.shareListener[peer.NodeID] = f
+	c.shareListener[peer.Hash] = f
 	c.shareMtx.Unlock()
 
 	defer func() {
 		c.shareMtx.Lock()
-		delete(c.shareListener, peer.NodeID)
+		delete(c.shareListener, peer.Hash)
 		c.shareMtx.Unlock()
 	}()
 
@@ -143,8 +165,10 @@ func (c *controller) asyncPeerRequest(peer *Peer) ([]Endpoint, error) {
 
 	select {
 	case <-async:
-	case <-time.After(time.Second * 5):
+	case <-c.net.clock.After(time.Second * 5):
 		return nil, fmt.Errorf("timeout")
+	case <-c.net.globalCloser:
+		return nil, fmt.Errorf("network stopped")
 	}
 
 	return share, nil
@@ -157,7 +181,7 @@ func (c *controller) catReplenish() {
 	defer c.logger.Debug("Replenish loop ended")
 
 	deny := func(ep Endpoint) bool {
-		return c.peers.Connected(ep) || c.isBannedEndpoint(ep) || !c.dialer.CanDial(ep)
+		return c.peers.Connected(ep) || c.isSelf(ep) || c.isBannedEndpoint(ep) || !c.dialer.CanDial(ep) || c.gateDial(ep) != nil
 	}
 
 	// bootstrap
@@ -171,12 +195,18 @@ func (c *controller) catReplenish() {
 		c.bootstrap = nil
 	}
 
-	lastReseed := time.Now()
+	lastReseed := c.net.clock.Now()
 
 	for {
+		if c.stopped() {
+			return
+		}
+
 		var connect []Endpoint
 		if uint(c.peers.Total()) >= c.net.conf.Target {
-			time.Sleep(time.Second)
+			if !c.wait(time.Second) {
+				return
+			}
 			continue
 		}
 
@@ -187,14 +217,14 @@ func (c *controller) catReplenish() {
 		}
 
 		// try special first
-		for _, sp := range c.specialEndpoints {
+		for _, sp := range c.getSpecial() {
 			if deny(sp) {
 				continue
 			}
 			connect = append(connect, sp)
 		}
 
-		if uint(c.peers.Total()) <= min || time.Since(lastReseed) > c.net.conf.PeerReseedInterval {
+		if uint(c.peers.Total()) <= min || c.net.clock.Since(lastReseed) > c.net.conf.PeerReseedInterval {
 			seeds := c.seed.retrieve()
 			// shuffle to hit different seeds
 			c.net.rng.Shuffle(len(seeds), func(i, j int) {
@@ -206,7 +236,7 @@ func (c *controller) catReplenish() {
 				}
 				connect = append(connect, s)
 			}
-			lastReseed = time.Now()
+			lastReseed = c.net.clock.Now()
 		}
 
 		// if we connect to a peer that's full it gives us some alternatives
@@ -216,12 +246,12 @@ func (c *controller) catReplenish() {
 
 		if c.peers.Total() > 0 {
 			rand := c.randomPeersConditional(1, func(p *Peer) bool {
-				return time.Since(p.lastPeerSend) >= c.net.conf.PeerRequestInterval
+				return c.net.clock.Since(p.lastPeerSend) >= c.net.conf.PeerRequestInterval
 			})
 			if len(rand) > 0 {
 				p := rand[0]
 				// error just means timeout of async request
-				p.lastPeerSend = time.Now()
+				p.lastPeerSend = c.net.clock.Now()
 				if eps, err := c.asyncPeerRequest(p); err == nil {
 					// pick random share from peer
 					if len(eps) > 0 {
@@ -260,7 +290,9 @@ func (c *controller) catReplenish() {
 		connect = nil
 
 		if attempts == 0 { // no peers and we exhausted special and seeds
-			time.Sleep(time.Second)
+			if !c.wait(time.Second) {
+				return
+			}
 		}
 	}
 }
@@ -0,0 +1,35 @@
+package p2p
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_controller_makePeerShare(t *testing.T) {
+	n, err := NewNetwork(testMemoryConfig(NewMemoryTransport(), "10.0.0.1", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := n.controller
+
+	for i := 2; i <= 7; i++ {
+		p := new(Peer)
+		p.Endpoint = Endpoint{IP: fmt.Sprintf("10.0.0.%d", i), Port: "8108"}
+		p.Hash = p.Endpoint.String()
+		c.peers.Add(p)
+	}
+
+	// more peers are connected than are shared, the share is still filled up
+	requester := Endpoint{IP: "10.0.0.2", Port: "8108"}
+	for i := 0; i < 10; i++ {
+		share := c.makePeerShare(requester)
+		if uint(len(share)) != n.conf.PeerShareAmount {
+			t.Fatalf("share has %d endpoints, want %d", len(share), n.conf.PeerShareAmount)
+		}
+		for _, ep := range share {
+			if ep == requester {
+				t.Errorf("share %v contains the requesting endpoint", share)
+			}
+		}
+	}
+}
@@ -18,24 +18,34 @@ func (c *controller) manageOnline() {
 	defer c.logger.Debug("Stop manageOnline()")
 	for {
 		select {
+		case <-c.net.globalCloser:
+			return
 		case pc := <-c.peerStatus:
 			if pc.online {
 				old := c.peers.Get(pc.peer.Hash)
 				if old != nil {
-					old.Stop()
+					// stopping sends a status update, which can't block this loop
+					go old.stopWith(ReasonReplaced)
 					c.logger.Debugf("removing old peer %s", pc.peer.Hash)
 					c.peers.Remove(old)
 				}
 				err := c.peers.Add(pc.peer)
 				if err != nil {
 					c.logger.Errorf("Unable to add peer %s to peer store because an old peer still exists", pc.peer)
+				} else {
+					c.net.emit(Event{Type: EventPeerConnected, Hash: pc.peer.Hash, Endpoint: pc.peer.Endpoint})
+					c.observe(pc.peer)
+					if pc.peer.Capabilities.Has(CapTopics) {
+						pc.peer.Send(c.subscriptionParcel())
+					}
 				}
 			} else {
 				c.peers.Remove(pc.peer)
+				c.peerDisconnected(pc.peer)
 			}
 			if c.net.prom != nil {
 				c.net.prom.Connections.Set(float64(c.peers.Total()))
-				//c.net.prom.Unique.Set(float64(c.peers.Unique()))
+				c.net.prom.Unique.Set(float64(c.peers.Unique()))
 				c.net.prom.Incoming.Set(float64(c.peers.Incoming()))
 				c.net.prom.Outgoing.Set(float64(c.peers.Outgoing()))
 			}
@@ -43,20 +53,62 @@ func (c *controller) manageOnline() {
 	}
 }
 
-// preliminary check to see if we should accept an unknown connection
-func (c *controller) allowIncoming(addr string) error {
+// peerDisconnected reports a removed peer and honors the retry time the
+// remote node gave
+func (c *controller) peerDisconnected(peer *Peer) {
+	e := Event{Type: EventPeerDisconnected, Hash: peer.Hash, Endpoint: peer.Endpoint, Reason: peer.stopReason, Remote: peer.stopRemote}
+	direction := promSent
+	if peer.stopRemote {
+		direction = promReceived
+		e.Retry = peer.retryAfter
+		if peer.retryAfter > 0 {
+			c.dialer.RetryAfter(peer.Endpoint, peer.retryAfter)
+		}
+	}
+	if c.net.prom != nil {
+		c.net.prom.Disconnects.WithLabelValues(string(peer.stopReason), direction).Inc()
+	}
+
+	c.net.emit(e)
+	if c.isSpecial(peer.Endpoint) {
+		e.Type = EventSpecialPeerLost
+		c.net.emit(e)
+	}
+}
+
+// preliminary check to see if we should accept an unknown connection.
+// Refused connections are given a reason and a time to wait before retrying
+func (c *controller) allowIncoming(addr string) (DisconnectReason, time.Duration, error) {
 	if c.isBannedIP(addr) {
-		return fmt.Errorf("Address %s is banned", addr)
+		return ReasonBanned, c.banRemaining(addr), fmt.Errorf("Address %s is banned", addr)
 	}
 
 	if uint(c.peers.Total()) >= c.net.conf.Incoming && !c.isSpecialIP(addr) {
-		return fmt.Errorf("Refusing incoming connection from %s because we are maxed out (%d of %d)", addr, c.peers.Total(), c.net.conf.Incoming)
+		return ReasonFull, c.net.conf.RedialInterval, fmt.Errorf("Refusing incoming connection from %s because we are maxed out (%d of %d)", addr, c.peers.Total(), c.net.conf.Incoming)
 	}
 
 	if c.net.conf.PeerIPLimitIncoming > 0 && uint(c.peers.Count(addr)) >= c.net.conf.PeerIPLimitIncoming {
-		return fmt.Errorf("Rejecting %s due to per ip limit of %d", addr, c.net.conf.PeerIPLimitIncoming)
+		return ReasonIPLimit, c.net.conf.RedialInterval, fmt.Errorf("Rejecting %s due to per ip limit of %d", addr, c.net.conf.PeerIPLimitIncoming)
 	}
 
+	return "", 0, nil
+}
+
+// screen refuses connections from addresses that are banned or over the per ip
+// limit. It only needs the address, so a multiplexer can check it before
+// reading the handshake. The connection gater is left to handleIncoming so it
+// is called once per connection
+func (c *controller) screen(addr net.Addr) error {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return err
+	}
+	if c.isBannedIP(host) {
+		return fmt.Errorf("Address %s is banned", host)
+	}
+	if c.net.conf.PeerIPLimitIncoming > 0 && uint(c.peers.Count(host)) >= c.net.conf.PeerIPLimitIncoming {
+		return fmt.Errorf("Rejecting %s due to per ip limit of %d", host, c.net.conf.PeerIPLimitIncoming)
+	}
 	return nil
 }
 
@@ -74,6 +126,12 @@ func (c *controller) handleIncoming(con net.Conn) {
 		return
 	}
 
+	if err = c.gateAccept(con.RemoteAddr()); err != nil {
+		c.logger.WithError(err).Debugf("Refusing connection")
+		con.Close()
+		return
+	}
+
 	// port is overriden during handshake, use default port as temp port
 	ep, err := NewEndpoint(host, c.net.conf.ListenPort)
 	if err != nil { // should never happen for incoming
@@ -83,10 +141,13 @@ func (c *controller) handleIncoming(con net.Conn) {
 	}
 
 	// if we're full, give them alternatives
-	if err = c.allowIncoming(host); err != nil {
+	if reason, retry, err := c.allowIncoming(host); err != nil {
 		c.logger.WithError(err).Infof("Rejecting connection")
-		share := c.makePeerShare(ep)  // they're not connected to us, so we don't have them in our system
-		c.RejectWithShare(con, share) // closes con
+		share := c.makePeerShare(ep)                 // they're not connected to us, so we don't have them in our system
+		c.RejectWithShare(con, share, reason, retry) // closes con
+		if c.net.prom != nil {
+			c.net.prom.Rejections.WithLabelValues(promSent).Inc()
+		}
 		return
 	}
 
@@ -102,13 +163,13 @@ func (c *controller) handleIncoming(con net.Conn) {
 
 	if c.isBannedEndpoint(peer.Endpoint) {
 		c.logger.Debugf("Peer %s is banned, disconnecting", peer.Hash)
-		peer.Stop()
+		peer.stopRetry(ReasonBanned, c.banRemaining(peer.Endpoint.IP))
 	}
 }
 
 // RejectWithShare rejects an incoming connection by sending them a handshake that provides
-// them with alternative peers to connect to
-func (c *controller) RejectWithShare(con net.Conn, share []Endpoint) error {
+// them with alternative peers to connect to, the reason, and the time to wait before retrying
+func (c *controller) RejectWithShare(con net.Conn, share []Endpoint, reason DisconnectReason, retry time.Duration) error {
 	defer con.Close() // we're rejecting, so always close
 
 	payload, err := json.Marshal(share)
@@ -118,6 +179,8 @@ func (c *controller) RejectWithShare(con net.Conn, share []Endpoint) error {
 
 	handshake := newHandshake(c.net.conf, payload)
 	handshake.Header.Type = TypeRejectAlternative
+	handshake.Reason = reason
+	handshake.RetryAfter = retry
 
 	// only push the handshake, don't care what they send us
 	encoder := gob.NewEncoder(con)
@@ -146,51 +209,94 @@ func (c *controller) Dial(ep Endpoint) (bool, []Endpoint) {
 		c.logger.Debugf("Dialing to %s", ep)
 	}
 
+	result := func(r string) {
+		if c.net.prom != nil {
+			c.net.prom.Dials.WithLabelValues(r).Inc()
+		}
+	}
+
+	if c.isSelf(ep) {
+		c.logger.Debugf("Not dialing %s, it is this node", ep)
+		result("self")
+		return false, nil
+	}
+
+	if err := c.gateDial(ep); err != nil {
+		c.logger.WithError(err).Debugf("Not dialing %s", ep)
+		result("gated")
+		c.net.emit(Event{Type: EventDialFailed, Endpoint: ep, Error: err.Error()})
+		return false, nil
+	}
+
 	con, err := c.dialer.Dial(ep)
 	if err != nil {
 		c.logger.WithError(err).Infof("Failed to dial to %s", ep)
+		result("dial_failed")
+		c.net.emit(Event{Type: EventDialFailed, Endpoint: ep, Error: err.Error()})
 		return false, nil
 	}
 
 	peer := newPeer(c.net, c.peerStatus, c.peerData)
 	if share, err := peer.StartWithHandshake(ep, con, false); err != nil {
+		if peer.retryAfter > 0 {
+			c.dialer.RetryAfter(ep, peer.retryAfter)
+		}
 		if err.Error() == "loopback" {
-			c.logger.Debugf("Banning ourselves for 50 years")
-			c.banEndpoint(ep, time.Hour*24*365*50) // ban for 50 years
+			c.logger.Debugf("Endpoint %s is this node, not dialing it again", ep)
+			c.external.addSelf(ep)
+			result("loopback")
 		} else if len(share) > 0 {
 			c.logger.Debugf("Connection declined with alternatives from %s", ep)
+			result("rejected")
 			return false, share
 		} else {
 			c.logger.WithError(err).Debugf("Handshake fail with %s", ep)
+			result("handshake_failed")
 		}
 		peer.Stop()
 		return false, nil
 	}
 
 	c.logger.Debugf("Handshake success for peer %s, version %s", peer.Hash, peer.prot.Version())
+	result("success")
 	return true, nil
 }
 
-// listen listens for incoming TCP connections and passes them off to handshake maneuver
+// listen listens for incoming connections and passes them off to handshake maneuver
 func (c *controller) listen() {
 	tmpLogger := c.logger.WithFields(log.Fields{"address": c.net.conf.BindIP, "port": c.net.conf.ListenPort})
 	tmpLogger.Debug("controller.listen() starting up")
 
 	addr := fmt.Sprintf("%s:%s", c.net.conf.BindIP, c.net.conf.ListenPort)
 
-	l, err := NewLimitedListener(addr, c.net.conf.ListenLimit)
+	// a multiplexer screens connections before it reads their handshake
+	if mt, ok := c.net.conf.Transport.(*muxTransport); ok {
+		mt.screen = c.screen
+	}
+
+	l, err := NewLimitedListener(c.net.conf.Transport, c.net.clock, addr, c.net.conf.ListenLimit)
 	if err != nil {
 		tmpLogger.WithError(err).Error("controller.Start() unable to start limited listener")
 		return
 	}
 
+	c.listenerMtx.Lock()
+	if c.stopped() {
+		c.listenerMtx.Unlock()
+		l.Close()
+		return
+	}
 	c.listener = l
+	c.listenerMtx.Unlock()
 
 	// start permanent loop
 	// terminates on program exit or when listener is closed
 	for {
-		conn, err := c.listener.Accept()
+		conn, err := l.Accept()
 		if err != nil {
+			if c.stopped() {
+				return
+			}
 			if ne, ok := err.(*net.OpError); ok && !ne.Timeout() {
 				if !ne.Temporary() {
 					tmpLogger.WithError(err).Warn("controller.acceptLoop() error accepting")
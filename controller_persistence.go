@@ -45,7 +45,7 @@ func (c *controller) persistData() ([]byte, error) {
 	pers.Bans = make(map[string]time.Time)
 
 	c.banMtx.Lock()
-	now := time.Now()
+	now := c.net.clock.Now()
 	for addr, end := range c.bans {
 		if end.Before(now) {
 			delete(c.bans, addr)
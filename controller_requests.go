@@ -0,0 +1,165 @@
+package p2p
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+)
+
+// RequestHandler answers a request from the peer with the given hash. The returned
+// payload is sent back to the peer as the response
+type RequestHandler func(peer string, payload []byte) []byte
+
+// Request payloads start with an 8 byte correlation id. Responses carry the same
+// id followed by a status byte
+const (
+	requestHeaderLength  = 8
+	responseHeaderLength = 9
+)
+
+const (
+	responseOK byte = iota
+	responseUnhandled
+	responseBusy
+)
+
+type requestReply struct {
+	payload []byte
+	err     error
+}
+
+// pendingRequest is a request waiting for its response
+type pendingRequest struct {
+	peer  string
+	reply chan requestReply
+}
+
+func (c *controller) setRequestHandler(handler RequestHandler) {
+	c.requestMtx.Lock()
+	defer c.requestMtx.Unlock()
+	c.requestHandler = handler
+}
+
+// request sends a request to a peer and waits for the response
+func (c *controller) request(ctx context.Context, hash string, payload []byte) ([]byte, error) {
+	peer := c.peers.Get(hash)
+	if peer == nil {
+		return nil, fmt.Errorf("peer %s is not connected", hash)
+	}
+	if !peer.Capabilities.Has(CapRequests) {
+		return nil, fmt.Errorf("peer %s does not support requests", hash)
+	}
+
+	id := atomic.AddUint64(&c.requestID, 1)
+	pr := &pendingRequest{peer: hash, reply: make(chan requestReply, 1)}
+
+	c.requestMtx.Lock()
+	c.requests[id] = pr
+	c.requestMtx.Unlock()
+
+	defer func() {
+		c.requestMtx.Lock()
+		delete(c.requests, id)
+		c.requestMtx.Unlock()
+	}()
+
+	data := make([]byte, requestHeaderLength+len(payload))
+	binary.BigEndian.PutUint64(data, id)
+	copy(data[requestHeaderLength:], payload)
+	peer.Send(newParcel(TypeRequest, data))
+
+	select {
+	case reply := <-pr.reply:
+		return reply.payload, reply.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.net.clock.After(c.net.conf.RequestTimeout):
+		return nil, fmt.Errorf("request to %s timed out", hash)
+	case <-peer.stop:
+		return nil, fmt.Errorf("peer %s disconnected", hash)
+	case <-c.net.globalCloser:
+		return nil, fmt.Errorf("network stopped")
+	}
+}
+
+// routeRequest starts handling a request in the background. Requests beyond
+// the peer's limit of MaxPeerRequests are answered as busy
+func (c *controller) routeRequest(peer *Peer, parcel *Parcel) {
+	if len(parcel.Payload) < requestHeaderLength {
+		c.logger.Warnf("peer %s sent a request without correlation id", peer)
+		return
+	}
+
+	if atomic.AddInt32(&peer.requests, 1) > int32(c.net.conf.MaxPeerRequests) {
+		atomic.AddInt32(&peer.requests, -1)
+		c.logger.Debugf("peer %s has too many requests in flight", peer)
+		c.respond(peer, parcel, responseBusy, nil)
+		return
+	}
+
+	go func() {
+		status, reply := c.handleRequest(peer, parcel)
+		// free the slot before responding so the peer can send the next one
+		atomic.AddInt32(&peer.requests, -1)
+		c.respond(peer, parcel, status, reply)
+	}()
+}
+
+// handleRequest answers a request from a peer with the application's handler
+func (c *controller) handleRequest(peer *Peer, parcel *Parcel) (byte, []byte) {
+	c.requestMtx.Lock()
+	handler := c.requestHandler
+	c.requestMtx.Unlock()
+
+	status := responseUnhandled
+	var reply []byte
+	if handler != nil {
+		status = responseOK
+		reply = handler(peer.Hash, parcel.Payload[requestHeaderLength:])
+	}
+	return status, reply
+}
+
+// respond sends the response to a request with the given status
+func (c *controller) respond(peer *Peer, parcel *Parcel, status byte, reply []byte) {
+	data := make([]byte, responseHeaderLength+len(reply))
+	copy(data, parcel.Payload[:requestHeaderLength])
+	data[requestHeaderLength] = status
+	copy(data[responseHeaderLength:], reply)
+	peer.Send(newParcel(TypeResponse, data))
+}
+
+// handleResponse passes a response to the waiting request. Responses to unknown
+// or expired requests and responses from the wrong peer are ignored
+func (c *controller) handleResponse(peer *Peer, parcel *Parcel) {
+	if len(parcel.Payload) < responseHeaderLength {
+		c.logger.Warnf("peer %s sent a malformed response", peer)
+		return
+	}
+	id := binary.BigEndian.Uint64(parcel.Payload)
+
+	c.requestMtx.Lock()
+	pr, ok := c.requests[id]
+	c.requestMtx.Unlock()
+	if !ok || pr.peer != peer.Hash {
+		return
+	}
+
+	var reply requestReply
+	switch parcel.Payload[requestHeaderLength] {
+	case responseOK:
+		reply.payload = parcel.Payload[responseHeaderLength:]
+	case responseUnhandled:
+		reply.err = fmt.Errorf("peer %s does not handle requests", peer.Hash)
+	case responseBusy:
+		reply.err = fmt.Errorf("peer %s is busy", peer.Hash)
+	default:
+		reply.err = fmt.Errorf("peer %s sent unknown response status %d", peer.Hash, parcel.Payload[requestHeaderLength])
+	}
+
+	select {
+	case pr.reply <- reply:
+	default: // duplicate response
+	}
+}
@@ -0,0 +1,107 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNetwork_Request(t *testing.T) {
+	mt := NewMemoryTransport()
+
+	a, err := NewNetwork(testMemoryConfig(mt, "10.0.0.1", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewNetwork(testMemoryConfig(mt, "10.0.0.2", "10.0.0.1:8108"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.Run()
+	b.Run()
+	defer a.Stop()
+	defer b.Stop()
+
+	if !waitFor(time.Second*5, func() bool { return a.Total() == 1 && b.Total() == 1 }) {
+		t.Fatalf("nodes did not connect: a = %d, b = %d", a.Total(), b.Total())
+	}
+	var hash string
+	for h := range b.GetPeerMetrics() {
+		hash = h
+	}
+
+	ctx := context.Background()
+	if _, err := b.Request(ctx, hash, []byte("block 1")); err == nil || !strings.Contains(err.Error(), "does not handle") {
+		t.Errorf("request without handler returned %v", err)
+	}
+
+	block := make(chan bool)
+	a.HandleRequests(func(peer string, payload []byte) []byte {
+		if string(payload) == "block" {
+			<-block
+		}
+		return append([]byte("reply to "), payload...)
+	})
+	defer close(block)
+
+	reply, err := b.Request(ctx, hash, []byte("block 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply, []byte("reply to block 1")) {
+		t.Errorf("unexpected reply %q", reply)
+	}
+
+	// concurrent requests are matched to their own responses
+	concurrent := int(a.conf.MaxPeerRequests)
+	errs := make(chan error, concurrent)
+	for i := 0; i < concurrent; i++ {
+		go func(i int) {
+			payload := []byte(strings.Repeat("x", i+1))
+			reply, err := b.Request(ctx, hash, payload)
+			if err == nil && string(reply) != "reply to "+string(payload) {
+				err = errUnexpected(reply)
+			}
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < concurrent; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	cancelled, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer cancel()
+	if _, err := b.Request(cancelled, hash, []byte("block")); err != context.DeadlineExceeded {
+		t.Errorf("cancelled request returned %v", err)
+	}
+
+	// the cancelled request is still blocking a's handler, fill the other slots
+	var wg sync.WaitGroup
+	busy, cancelBusy := context.WithTimeout(ctx, time.Millisecond*50)
+	defer cancelBusy()
+	for i := 1; i < int(a.conf.MaxPeerRequests); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.Request(busy, hash, []byte("block"))
+		}()
+	}
+	wg.Wait()
+	if _, err := b.Request(ctx, hash, []byte("x")); err == nil || !strings.Contains(err.Error(), "busy") {
+		t.Errorf("request beyond the limit returned %v", err)
+	}
+
+	if _, err := b.Request(ctx, "10.0.0.9:8108 00000000", []byte("x")); err == nil {
+		t.Errorf("request to unknown peer succeeded")
+	}
+}
+
+type errUnexpected []byte
+
+func (e errUnexpected) Error() string { return "unexpected reply " + string(e) }
@@ -1,12 +1,12 @@
 package p2p
 
-import "time"
-
 // route Takes messages from the network's ToNetwork channel and routes via the appropriate function
 func (c *controller) route() {
 	for {
-		// blocking read on ToNetwork, and c.stopRoute
+		// blocking read on ToNetwork, and globalCloser
 		select {
+		case <-c.net.globalCloser:
+			return
 		case message := <-c.net.ToNetwork:
 			switch message.Address {
 			case FullBroadcast:
@@ -29,6 +29,8 @@ func (c *controller) manageData() {
 	defer c.logger.Debug("Stop manageData()")
 	for {
 		select {
+		case <-c.net.globalCloser:
+			return
 		case pp := <-c.peerData:
 			parcel := pp.parcel
 			peer := pp.peer
@@ -42,31 +44,62 @@ func (c *controller) manageData() {
 			switch parcel.Type {
 			case TypePing:
 				go func() {
-					parcel := newParcel(TypePong, []byte("Pong"))
+					// echo the ping so the sender can measure the round trip time
+					parcel := newParcel(TypePong, parcel.Payload)
 					peer.Send(parcel)
 				}()
+			case TypePong:
+				peer.pong(parcel.Payload)
 			case TypeMessage:
-				//c.net.FromNetwork.Send(parcel)
-				fallthrough
-			case TypeMessagePart:
-				parcel.Type = TypeMessage
 				c.net.FromNetwork.Send(parcel)
+			case TypeMessagePart:
+				// parts are reassembled by the peer, fragments are not delivered
+				c.logger.Debugf("dropping unassembled message part %s from %s", parcel, peer)
+			case TypeTopicMessage:
+				c.deliverTopic(peer, parcel)
+			case TypeSubscriptions:
+				c.processSubscriptions(peer, parcel)
+			case TypeRequest:
+				c.routeRequest(peer, parcel)
+			case TypeResponse:
+				c.handleResponse(peer, parcel)
 			case TypePeerRequest:
-				if time.Since(peer.lastPeerRequest) >= c.net.conf.PeerRequestInterval {
-					peer.lastPeerRequest = time.Now()
-					share := c.makePeerShare(peer.Endpoint)
+				if c.net.clock.Since(peer.lastPeerRequest) >= c.net.conf.PeerRequestInterval {
+					peer.lastPeerRequest = c.net.clock.Now()
+					share := c.advertiseSelf(c.makePeerShare(peer.Endpoint))
 					go c.sharePeers(peer, share)
 				} else {
 					c.logger.Warnf("peer %s sent a peer request too early", peer)
 				}
 			case TypePeerResponse:
 				c.shareMtx.RLock()
-				if f, ok := c.shareListener[peer.NodeID]; ok {
+				if f, ok := c.shareListener[peer.Hash]; ok {
 					f(parcel)
 				}
 				c.shareMtx.RUnlock()
 			default:
-				//not handled
+				select {
+				case c.customData <- pp:
+				default:
+					c.logger.Warnf("dropping parcel %s from %s: custom parcel handlers are too slow", parcel, peer)
+				}
+			}
+		}
+	}
+}
+
+// manageCustom passes parcels of custom types to their handlers, so slow
+// handlers don't hold up the routing of other parcels
+func (c *controller) manageCustom() {
+	c.logger.Debug("Start manageCustom()")
+	defer c.logger.Debug("Stop manageCustom()")
+	for {
+		select {
+		case <-c.net.globalCloser:
+			return
+		case pp := <-c.customData:
+			if ct, ok := c.net.parcelTypes.get(pp.parcel.Type); ok {
+				ct.handler(pp.peer.Hash, pp.parcel.Payload)
 			}
 		}
 	}
@@ -75,13 +108,14 @@ func (c *controller) manageData() {
 // Broadcast delivers a parcel to multiple connections specified by the fanout.
 // A full broadcast sends the parcel to ALL connected peers
 func (c *controller) Broadcast(parcel *Parcel, full bool) {
+	peers := c.capablePeers(parcel)
 	if full {
-		for _, p := range c.peers.Slice() {
+		for _, p := range peers {
 			p.Send(parcel)
 		}
 		return
 	}
-	selection := c.selectBroadcastPeers(c.net.conf.Fanout)
+	selection := c.selectBroadcastPeers(peers, c.net.conf.Fanout)
 	for _, p := range selection {
 		p.Send(parcel)
 	}
@@ -91,34 +125,42 @@ func (c *controller) Broadcast(parcel *Parcel, full bool) {
 // If the hash is empty, a random connected peer will be chosen
 func (c *controller) ToPeer(hash string, parcel *Parcel) {
 	if hash == "" {
-		if random := c.randomPeer(); random != nil {
+		if random := c.randomPeer(parcel); random != nil {
 			random.Send(parcel)
 		} else {
 			c.logger.Warnf("attempted to send parcel %s to a random peer but no peers are connected", parcel)
 		}
 	} else {
 		p := c.peers.Get(hash)
-		if p != nil {
-			p.Send(parcel)
+		if p == nil {
+			return
+		}
+		if !p.Capabilities.Has(parcel.Require) {
+			c.logger.Debugf("dropping parcel %s for %s: peer is missing capabilities %s", parcel, p, parcel.Require&^p.Capabilities)
+			return
 		}
+		if !p.accepts(parcel.Type) {
+			c.logger.Debugf("dropping parcel %s for %s: peer has not registered the type", parcel, p)
+			return
+		}
+		p.Send(parcel)
 	}
 }
 
-func (c *controller) randomPeers(count uint) []*Peer {
+// capablePeers returns the connected peers that have all of the capabilities
+// the parcel requires and that accept its type
+func (c *controller) capablePeers(parcel *Parcel) []*Peer {
 	peers := c.peers.Slice()
-	if len(peers) == 0 {
-		return nil
-	}
-	// not enough to randomize
-	if uint(len(peers)) <= count {
+	if parcel.Require == 0 && parcel.Type < TypeExtensionMin {
 		return peers
 	}
-
-	c.net.rng.Shuffle(len(peers), func(i, j int) {
-		peers[i], peers[j] = peers[j], peers[i]
-	})
-
-	return peers[:count]
+	filtered := make([]*Peer, 0, len(peers))
+	for _, p := range peers {
+		if p.Capabilities.Has(parcel.Require) && p.accepts(parcel.Type) {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
 }
 
 func (c *controller) randomPeersConditional(count uint, condition func(*Peer) bool) []*Peer {
@@ -145,17 +187,20 @@ func (c *controller) randomPeersConditional(count uint, condition func(*Peer) bo
 	return filtered[:count]
 }
 
-func (c *controller) randomPeer() *Peer {
-	peers := c.randomPeers(1)
-	if len(peers) == 1 {
-		return peers[0]
+// randomPeer picks a single peer that can receive the parcel via the peer selector
+func (c *controller) randomPeer(parcel *Parcel) *Peer {
+	peers := c.capablePeers(parcel)
+	if len(peers) == 0 {
+		return nil
+	}
+	if sel := c.net.conf.PeerSelector.Select(c.net.rng, peers, 1); len(sel) > 0 {
+		return sel[0]
 	}
 	return nil
 }
 
-func (c *controller) selectBroadcastPeers(count uint) []*Peer {
-	peers := c.peers.Slice()
-
+// selectBroadcastPeers picks all special peers and count regular peers
+func (c *controller) selectBroadcastPeers(peers []*Peer, count uint) []*Peer {
 	// not enough to randomize
 	if uint(len(peers)) <= count {
 		return peers
@@ -176,9 +221,5 @@ func (c *controller) selectBroadcastPeers(count uint) []*Peer {
 		return append(special, regular...)
 	}
 
-	c.net.rng.Shuffle(len(regular), func(i, j int) {
-		regular[i], regular[j] = regular[j], regular[i]
-	})
-
-	return append(special, regular[:count]...)
+	return append(special, c.net.conf.PeerSelector.Select(c.net.rng, regular, count)...)
 }
@@ -8,26 +8,33 @@ import "time"
 // not based on reactions. runs once a second
 func (c *controller) run() {
 	c.logger.Debug("Start run()")
-	defer c.logger.Error("Stop run()")
+	defer c.logger.Debug("Stop run()")
 
 	for {
 		c.runCatRound()
 		c.runMetrics()
 		c.runPing()
 
-		select {
-		case <-time.After(time.Second):
+		if !c.wait(time.Second) {
+			return
 		}
 	}
 }
 
 // the factom network ping behavior is so send a ping message after
-// a specific duration has passed
+// a specific duration has passed. peers that sent or received a parcel
+// within the interval don't need to be kept alive and are not pinged
 func (c *controller) runPing() {
 	for _, p := range c.peers.Slice() {
-		if time.Since(p.lastSend) > c.net.conf.PingInterval {
-			ping := newParcel(TypePing, []byte("Ping"))
-			p.Send(ping)
+		p.pingMtx.Lock()
+		due := c.net.clock.Since(p.lastPing) > c.net.conf.PingInterval
+		p.pingMtx.Unlock()
+		if !due || !p.idle(c.net.conf.PingInterval) {
+			continue
+		}
+		if !p.ping() {
+			c.logger.Debugf("Peer %s missed %d pongs, disconnecting", p, c.net.conf.PingMissLimit)
+			p.stopWith(ReasonPingTimeout)
 		}
 	}
 }
@@ -0,0 +1,135 @@
+package p2p
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// maxTopicLength is the longest topic name, limited by the one byte length prefix
+const maxTopicLength = 255
+
+// maxPeerTopics is the maximum number of subscriptions accepted from a peer
+const maxPeerTopics = 1024
+
+// subscribe creates the channel for a topic and tells peers about it.
+// Subscribing to the same topic twice returns the same channel
+func (c *controller) subscribe(topic string) (ParcelChannel, error) {
+	if topic == "" || len(topic) > maxTopicLength {
+		return nil, fmt.Errorf("topic must be between 1 and %d bytes long", maxTopicLength)
+	}
+
+	c.subscriptionMtx.Lock()
+	pc, ok := c.subscriptions[topic]
+	if !ok {
+		pc = newParcelChannel(c.net.conf.ChannelCapacity)
+		c.subscriptions[topic] = pc
+	}
+	c.subscriptionMtx.Unlock()
+
+	if !ok {
+		c.shareSubscriptions()
+	}
+	return pc, nil
+}
+
+// unsubscribe removes a topic. The topic's channel is not closed
+func (c *controller) unsubscribe(topic string) {
+	c.subscriptionMtx.Lock()
+	_, ok := c.subscriptions[topic]
+	delete(c.subscriptions, topic)
+	c.subscriptionMtx.Unlock()
+
+	if ok {
+		c.shareSubscriptions()
+	}
+}
+
+// topics returns the sorted list of subscribed topics
+func (c *controller) topics() []string {
+	c.subscriptionMtx.RLock()
+	defer c.subscriptionMtx.RUnlock()
+	topics := make([]string, 0, len(c.subscriptions))
+	for t := range c.subscriptions {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+func (c *controller) subscriptionParcel() *Parcel {
+	payload, _ := json.Marshal(c.topics())
+	return newParcel(TypeSubscriptions, payload)
+}
+
+// shareSubscriptions sends the list of subscribed topics to all peers that support topics
+func (c *controller) shareSubscriptions() {
+	parcel := c.subscriptionParcel()
+	for _, p := range c.peers.Slice() {
+		if p.Capabilities.Has(CapTopics) {
+			p.Send(parcel)
+		}
+	}
+}
+
+// processSubscriptions replaces the topics a peer is interested in
+func (c *controller) processSubscriptions(peer *Peer, parcel *Parcel) {
+	var topics []string
+	if err := json.Unmarshal(parcel.Payload, &topics); err != nil {
+		c.logger.WithError(err).Warnf("peer %s sent invalid subscriptions", peer)
+		return
+	}
+	if len(topics) > maxPeerTopics {
+		c.logger.Warnf("peer %s subscribed to %d topics, only using the first %d", peer, len(topics), maxPeerTopics)
+		topics = topics[:maxPeerTopics]
+	}
+	peer.setTopics(topics)
+}
+
+// publish sends a message to the special peers and up to Fanout regular peers
+// that are subscribed to the topic
+func (c *controller) publish(topic string, payload []byte) error {
+	if topic == "" || len(topic) > maxTopicLength {
+		return fmt.Errorf("topic must be between 1 and %d bytes long", maxTopicLength)
+	}
+
+	var interested []*Peer
+	for _, p := range c.peers.Slice() {
+		if p.Subscribed(topic) {
+			interested = append(interested, p)
+		}
+	}
+
+	data := make([]byte, 1+len(topic)+len(payload))
+	data[0] = byte(len(topic))
+	copy(data[1:], topic)
+	copy(data[1+len(topic):], payload)
+	parcel := newParcel(TypeTopicMessage, data)
+
+	for _, p := range c.selectBroadcastPeers(interested, c.net.conf.Fanout) {
+		p.Send(parcel)
+	}
+	return nil
+}
+
+// deliverTopic passes a topic message to the application's subscription
+func (c *controller) deliverTopic(peer *Peer, parcel *Parcel) {
+	if len(parcel.Payload) < 1 || len(parcel.Payload) < 1+int(parcel.Payload[0]) {
+		c.logger.Warnf("peer %s sent a malformed topic message", peer)
+		return
+	}
+	n := int(parcel.Payload[0])
+	topic := string(parcel.Payload[1 : 1+n])
+
+	c.subscriptionMtx.RLock()
+	pc, ok := c.subscriptions[topic]
+	c.subscriptionMtx.RUnlock()
+	if !ok {
+		return // unsubscribed since the peer last heard from us
+	}
+
+	msg := newParcel(TypeMessage, parcel.Payload[1+n:])
+	msg.Address = parcel.Address
+	msg.Topic = topic
+	pc.Send(msg)
+}
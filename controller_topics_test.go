@@ -0,0 +1,87 @@
+package p2p
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNetwork_Topics(t *testing.T) {
+	mt := NewMemoryTransport()
+
+	a, err := NewNetwork(testMemoryConfig(mt, "10.0.0.1", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewNetwork(testMemoryConfig(mt, "10.0.0.2", "10.0.0.1:8108"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// subscribed before connecting
+	entries, err := a.Subscribe("entries")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.Run()
+	b.Run()
+	defer a.Stop()
+	defer b.Stop()
+
+	if !waitFor(time.Second*5, func() bool { return a.Total() == 1 && b.Total() == 1 }) {
+		t.Fatalf("nodes did not connect: a = %d, b = %d", a.Total(), b.Total())
+	}
+
+	// subscribed after connecting
+	dbstates, err := a.Subscribe("dbstates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := a.Subscribe("dbstates"); again != dbstates {
+		t.Errorf("subscribing twice returned a different channel")
+	}
+
+	peerA := b.controller.peers.Slice()[0]
+	if !waitFor(time.Second*5, func() bool { return peerA.Subscribed("entries") && peerA.Subscribed("dbstates") }) {
+		t.Fatalf("subscriptions did not reach the peer")
+	}
+
+	for _, topic := range []string{"elections", "entries", "dbstates"} {
+		if err := b.Publish(topic, []byte(topic+" payload")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, ch := range []ParcelChannel{entries, dbstates} {
+		select {
+		case p := <-ch:
+			if p.Type != TypeMessage || p.Topic == "" || string(p.Payload) != p.Topic+" payload" {
+				t.Errorf("received unexpected parcel %+v", p)
+			}
+			if !strings.HasPrefix(p.Address, "10.0.0.2:8108") {
+				t.Errorf("parcel from unexpected address %s", p.Address)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("topic message did not arrive")
+		}
+	}
+
+	select {
+	case p := <-a.FromNetwork:
+		t.Errorf("topic message leaked into FromNetwork: %+v", p)
+	default:
+	}
+
+	a.Unsubscribe("entries")
+	if !waitFor(time.Second*5, func() bool { return !peerA.Subscribed("entries") }) {
+		t.Errorf("unsubscribing did not reach the peer")
+	}
+
+	if err := b.Publish("", []byte("x")); err == nil {
+		t.Errorf("published to an empty topic")
+	}
+	if _, err := a.Subscribe(strings.Repeat("x", 256)); err == nil {
+		t.Errorf("subscribed to a topic that is too long")
+	}
+}
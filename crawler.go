@@ -0,0 +1,265 @@
+package p2p
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var crawlerLogger = packageLogger.WithField("subpack", "crawler")
+
+// CrawlNode is a node discovered by the Crawler
+type CrawlNode struct {
+	Endpoint      Endpoint      `json:"endpoint"`
+	Reachable     bool          `json:"reachable"`           // a handshake was completed
+	Rejected      bool          `json:"rejected"`            // the node was full but shared alternatives
+	NodeID        uint32        `json:"node_id"`             // only set if reachable
+	Version       string        `json:"version"`             // the negotiated protocol version
+	UserAgent     string        `json:"agent,omitempty"`     // the software the node runs
+	Software      string        `json:"software,omitempty"`  // the version of the software
+	Capabilities  Capability    `json:"capabilities"`        // the optional features the node supports
+	HandshakeTime time.Duration `json:"handshake_time_ns"`   // time to dial and complete the handshake
+	ResponseTime  time.Duration `json:"response_time_ns"`    // average round trip of peer requests
+	Responses     int           `json:"responses"`           // number of answered peer requests
+	Error         string        `json:"error,omitempty"`     // reason the node was not reachable
+	Neighbors     []Endpoint    `json:"neighbors,omitempty"` // endpoints the node shared with us
+}
+
+// CrawlEdge is a connection between two endpoints as reported by From
+type CrawlEdge struct {
+	From Endpoint `json:"from"`
+	To   Endpoint `json:"to"`
+}
+
+// CrawlResult is the graph of all nodes visited during a crawl
+type CrawlResult struct {
+	Network  NetworkID     `json:"network"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration_ns"`
+	Nodes    []CrawlNode   `json:"nodes"`
+	Edges    []CrawlEdge   `json:"edges"`
+}
+
+// Crawler connects to nodes of a network and repeatedly asks them for peers,
+// following all endpoints it receives to build a graph of the network.
+type Crawler struct {
+	net *Network
+
+	// Requests is the number of peer requests sent to each node
+	Requests int
+	// Concurrency is the number of nodes that are crawled simultaneously
+	Concurrency int
+	// Limit is the maximum number of nodes to visit. 0 for unlimited
+	Limit int
+	// ResponseTimeout is how long to wait for a reply to a peer request
+	ResponseTimeout time.Duration
+
+	logger *log.Entry
+}
+
+// NewCrawler creates a new crawler. The configuration is used for the handshake
+// and should match the network that is being crawled. The crawler does not listen
+// for incoming connections.
+func NewCrawler(conf Configuration) (*Crawler, error) {
+	conf.EnablePrometheus = false
+	conf.PersistFile = ""
+	n, err := NewNetwork(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	c := new(Crawler)
+	c.net = n
+	c.Requests = 5
+	c.Concurrency = 8
+	c.ResponseTimeout = time.Second * 5
+	c.logger = crawlerLogger.WithFields(log.Fields{"node": conf.NodeName, "network": conf.Network})
+	return c, nil
+}
+
+// Crawl visits all nodes reachable from the starting endpoints
+func (c *Crawler) Crawl(start []Endpoint) *CrawlResult {
+	res := new(CrawlResult)
+	res.Network = c.net.conf.Network
+	res.Started = c.net.clock.Now()
+
+	self := Endpoint{IP: c.net.conf.BindIP, Port: c.net.conf.ListenPort}
+	visited := make(map[Endpoint]bool)
+	edges := make(map[CrawlEdge]bool)
+
+	var queue []Endpoint
+	enqueue := func(ep Endpoint) {
+		if visited[ep] || ep == self || (c.Limit > 0 && len(visited) >= c.Limit) {
+			return
+		}
+		visited[ep] = true
+		queue = append(queue, ep)
+	}
+	for _, ep := range start {
+		enqueue(ep)
+	}
+
+	concurrency := c.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	done := make(chan CrawlNode)
+	active := 0
+	for len(queue) > 0 || active > 0 {
+		for active < concurrency && len(queue) > 0 {
+			ep := queue[0]
+			queue = queue[1:]
+			active++
+			go func() { done <- c.visit(ep) }()
+		}
+
+		node := <-done
+		active--
+		res.Nodes = append(res.Nodes, node)
+
+		for _, n := range node.Neighbors {
+			edges[CrawlEdge{From: node.Endpoint, To: n}] = true
+			enqueue(n)
+		}
+	}
+
+	for e := range edges {
+		res.Edges = append(res.Edges, e)
+	}
+	sort.Slice(res.Nodes, func(i, j int) bool {
+		return res.Nodes[i].Endpoint.String() < res.Nodes[j].Endpoint.String()
+	})
+	sort.Slice(res.Edges, func(i, j int) bool {
+		if res.Edges[i].From != res.Edges[j].From {
+			return res.Edges[i].From.String() < res.Edges[j].From.String()
+		}
+		return res.Edges[i].To.String() < res.Edges[j].To.String()
+	})
+
+	res.Duration = c.net.clock.Since(res.Started)
+	return res
+}
+
+// visit connects to a single node and collects its peer shares
+func (c *Crawler) visit(ep Endpoint) CrawlNode {
+	node := CrawlNode{Endpoint: ep}
+	conf := c.net.conf
+	known := make(map[Endpoint]bool)
+
+	start := c.net.clock.Now()
+	con, err := conf.Transport.Dial(conf.BindIP, ep.String(), conf.DialTimeout)
+	if err != nil {
+		node.Error = err.Error()
+		return node
+	}
+
+	// the crawler acts as its own controller
+	status := make(chan peerStatus, 2)
+	data := make(chan peerParcel, conf.ChannelCapacity)
+	peer := newPeer(c.net, status, data)
+	defer peer.Stop()
+
+	if share, err := peer.StartWithHandshake(ep, con, false); err != nil {
+		if len(share) > 0 { // full nodes share their peers in the rejection
+			node.Rejected = true
+			node.Neighbors = share
+		}
+		node.Error = err.Error()
+		return node
+	}
+
+	node.Reachable = true
+	node.NodeID = peer.NodeID
+	node.Version = peer.prot.Version()
+	node.UserAgent = peer.UserAgent
+	node.Software = peer.Version
+	node.Capabilities = peer.Capabilities
+	node.HandshakeTime = c.net.clock.Since(start)
+
+	var total time.Duration
+	for i := 0; i < c.Requests; i++ {
+		if i > 0 { // nodes ignore requests that arrive too early
+			c.net.clock.Sleep(conf.PeerRequestInterval + conf.PeerRequestInterval/10)
+		}
+
+		sent := c.net.clock.Now()
+		peer.Send(newParcel(TypePeerRequest, []byte("Peer Request")))
+		share, err := c.awaitShare(peer, data)
+		if err != nil {
+			c.logger.WithError(err).Debugf("no peer share from %s", ep)
+			continue
+		}
+		total += c.net.clock.Since(sent)
+		node.Responses++
+
+		for _, s := range share {
+			if s.Valid() && s != ep && !known[s] { // nodes advertise themselves
+				known[s] = true
+				node.Neighbors = append(node.Neighbors, s)
+			}
+		}
+	}
+	if node.Responses > 0 {
+		node.ResponseTime = total / time.Duration(node.Responses)
+	}
+
+	return node
+}
+
+// awaitShare reads parcels from the peer until it receives a peer response
+func (c *Crawler) awaitShare(peer *Peer, data chan peerParcel) ([]Endpoint, error) {
+	timeout := c.net.clock.After(c.ResponseTimeout)
+	for {
+		select {
+		case pp := <-data:
+			if pp.parcel.Type == TypePeerResponse {
+				return peer.prot.ParsePeerShare(pp.parcel.Payload)
+			}
+		case <-peer.stop:
+			return nil, fmt.Errorf("disconnected")
+		case <-timeout:
+			return nil, fmt.Errorf("timeout")
+		}
+	}
+}
+
+// DOT returns the graph in the Graphviz DOT format
+func (r *CrawlResult) DOT() string {
+	var sb strings.Builder
+	sb.WriteString("graph p2p {\n")
+	sb.WriteString("\tnode [shape=box];\n")
+	for _, n := range r.Nodes {
+		label := n.Endpoint.String()
+		style := ""
+		if n.Reachable {
+			label += fmt.Sprintf("\\n%08x v%s\\n%s", n.NodeID, n.Version, n.ResponseTime.Round(time.Millisecond))
+		} else if n.Rejected {
+			label += "\\nfull"
+			style = ", style=dashed"
+		} else {
+			label += "\\nunreachable"
+			style = ", color=gray, fontcolor=gray"
+		}
+		sb.WriteString(fmt.Sprintf("\t%q [label=\"%s\"%s];\n", n.Endpoint.String(), label, style))
+	}
+
+	// the graph is undirected, so only print each pair once
+	seen := make(map[[2]string]bool)
+	for _, e := range r.Edges {
+		a, b := e.From.String(), e.To.String()
+		if b < a {
+			a, b = b, a
+		}
+		if seen[[2]string{a, b}] {
+			continue
+		}
+		seen[[2]string{a, b}] = true
+		sb.WriteString(fmt.Sprintf("\t%q -- %q;\n", a, b))
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
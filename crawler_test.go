@@ -0,0 +1,114 @@
+package p2p
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// neighborGater only allows dialing the given ips to keep the topology fixed
+type neighborGater map[string]bool
+
+func (g neighborGater) InterceptAccept(addr net.Addr) error { return nil }
+func (g neighborGater) InterceptSecured(peer *Peer) error   { return nil }
+func (g neighborGater) InterceptDial(ep Endpoint) error {
+	if !g[ep.IP] {
+		return fmt.Errorf("not a neighbor")
+	}
+	return nil
+}
+
+func TestCrawler_Crawl(t *testing.T) {
+	mt := NewMemoryTransport()
+
+	// chain of nodes: each node connects to the previous one
+	var nodes []*Network
+	for i := 1; i <= 5; i++ {
+		special := ""
+		if i > 1 {
+			special = fmt.Sprintf("10.0.0.%d:8108", i-1)
+		}
+		conf := testMemoryConfig(mt, fmt.Sprintf("10.0.0.%d", i), special)
+		conf.PeerRequestInterval = time.Millisecond * 20
+		conf.ConnectionGater = neighborGater{fmt.Sprintf("10.0.0.%d", i-1): true, fmt.Sprintf("10.0.0.%d", i+1): true}
+		n, err := NewNetwork(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.Run()
+		defer n.Stop()
+		nodes = append(nodes, n)
+	}
+
+	if !waitFor(time.Second*5, func() bool {
+		for i, n := range nodes {
+			want := 2
+			if i == 0 || i == len(nodes)-1 {
+				want = 1
+			}
+			if n.Total() != want {
+				return false
+			}
+			for _, p := range n.controller.peers.Slice() {
+				if !n.controller.isReachable(p) {
+					return false
+				}
+			}
+		}
+		return true
+	}) {
+		t.Fatal("nodes did not connect")
+	}
+
+	conf := testMemoryConfig(mt, "10.0.0.100", "")
+	conf.NodeName = "crawler"
+	conf.PeerRequestInterval = time.Millisecond * 20
+	crawler, err := NewCrawler(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crawler.Requests = 3
+	crawler.ResponseTimeout = time.Second
+
+	res := crawler.Crawl([]Endpoint{{IP: "10.0.0.1", Port: "8108"}, {IP: "10.0.0.99", Port: "8108"}})
+
+	if len(res.Nodes) != 6 {
+		t.Fatalf("expected 6 nodes, got %d: %+v", len(res.Nodes), res.Nodes)
+	}
+
+	for _, n := range res.Nodes {
+		if n.Endpoint.IP == "10.0.0.99" {
+			if n.Reachable || n.Error == "" {
+				t.Errorf("unreachable node was reported as reachable: %+v", n)
+			}
+			continue
+		}
+		if !n.Reachable {
+			t.Errorf("node %s was not reachable: %s", n.Endpoint, n.Error)
+		}
+		if n.Version != "10" {
+			t.Errorf("node %s has unexpected version %s", n.Endpoint, n.Version)
+		}
+		if want := StringToUint32("Node-" + n.Endpoint.IP); n.NodeID != want {
+			t.Errorf("node %s has node id %08x, want %08x", n.Endpoint, n.NodeID, want)
+		}
+		if n.Responses == 0 {
+			t.Errorf("node %s did not answer any peer requests", n.Endpoint)
+		}
+	}
+
+	// 4 links in the chain, reported by both sides
+	if len(res.Edges) != 8 {
+		t.Errorf("expected 8 edges, got %d: %+v", len(res.Edges), res.Edges)
+	}
+
+	dot := res.DOT()
+	if !strings.Contains(dot, `"10.0.0.1:8108" -- "10.0.0.2:8108";`) {
+		t.Errorf("dot output is missing edge:\n%s", dot)
+	}
+	if strings.Count(dot, " -- ") != 4 {
+		t.Errorf("dot output should contain 4 undirected edges:\n%s", dot)
+	}
+}
@@ -0,0 +1,150 @@
+package p2p
+
+import (
+	"encoding/gob"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// reachability is the result of dialing back an endpoint
+type reachability struct {
+	verified bool
+	checked  time.Time
+}
+
+// dialBacks keeps track of which endpoints of incoming peers were verified
+// to accept connections
+type dialBacks struct {
+	mtx     sync.RWMutex
+	results map[Endpoint]reachability
+}
+
+func newDialBacks() *dialBacks {
+	db := new(dialBacks)
+	db.results = make(map[Endpoint]reachability)
+	return db
+}
+
+func (db *dialBacks) verified(ep Endpoint) bool {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	return db.results[ep].verified
+}
+
+// due checks if an endpoint has never been checked or the last result expired
+func (db *dialBacks) due(ep Endpoint, now time.Time, expiry time.Duration) bool {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	r, ok := db.results[ep]
+	return !ok || now.Sub(r.checked) >= expiry
+}
+
+func (db *dialBacks) set(ep Endpoint, verified bool, now time.Time) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	db.results[ep] = reachability{verified: verified, checked: now}
+}
+
+// prune removes expired results of endpoints that are not connected anymore
+func (db *dialBacks) prune(connected map[Endpoint]bool, now time.Time, expiry time.Duration) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	for ep, r := range db.results {
+		if !connected[ep] && now.Sub(r.checked) >= expiry {
+			delete(db.results, ep)
+		}
+	}
+}
+
+// isReachable checks if a peer's endpoint can be given to other nodes.
+// Outgoing peers were dialed by us, incoming peers have to be verified
+func (c *controller) isReachable(peer *Peer) bool {
+	return !peer.IsIncoming || c.dialBacks.verified(peer.Endpoint)
+}
+
+// dialBack verifies the endpoints of incoming peers one at a time, waiting
+// DialBackInterval between attempts. Endpoints that are banned or that the
+// dialer may not dial yet are left for a later round
+func (c *controller) dialBack() {
+	c.logger.Debug("Start dialBack()")
+	defer c.logger.Debug("Stop dialBack()")
+
+	for c.wait(c.net.conf.DialBackInterval) {
+		now := c.net.clock.Now()
+		connected := make(map[Endpoint]bool)
+		var next *Peer
+		for _, p := range c.peers.Slice() {
+			connected[p.Endpoint] = true
+			if next == nil && p.IsIncoming && c.dialBacks.due(p.Endpoint, now, c.net.conf.DialBackExpiry) &&
+				c.dialer.CanDial(p.Endpoint) && !c.isBannedEndpoint(p.Endpoint) {
+				next = p
+			}
+		}
+		c.dialBacks.prune(connected, now, c.net.conf.DialBackExpiry)
+
+		if next == nil {
+			continue
+		}
+
+		err := c.verifyEndpoint(next.Endpoint, next.NodeID)
+		result := "verified"
+		if err != nil {
+			c.logger.WithError(err).Debugf("Unable to dial back %s", next)
+			result = "failed"
+		} else {
+			c.logger.Debugf("Dialed back %s", next)
+		}
+		c.dialBacks.set(next.Endpoint, err == nil, c.net.clock.Now())
+		if c.net.prom != nil {
+			c.net.prom.DialBacks.WithLabelValues(result).Inc()
+		}
+	}
+}
+
+// verifyEndpoint connects to an endpoint and checks that the node behind it
+// has the expected node id and listen port. Our handshake is sent first because
+// a multiplexer on the other side reads before it writes. The connection is
+// closed after the remote node's handshake is received.
+//
+// The check is best-effort: it shows that a node answers at the endpoint, not
+// that it is the same node as the peer, since a peer can copy another node's id
+func (c *controller) verifyEndpoint(ep Endpoint, nodeID uint32) error {
+	if c.isSelf(ep) {
+		return fmt.Errorf("endpoint is this node")
+	}
+	if err := c.gateDial(ep); err != nil {
+		return err
+	}
+
+	con, err := c.net.conf.Transport.Dial(c.net.conf.BindIP, ep.String(), c.net.conf.DialTimeout)
+	if err != nil {
+		return err
+	}
+	defer con.Close()
+
+	con.SetDeadline(time.Now().Add(c.net.conf.HandshakeTimeout))
+
+	// let the remote node know this is not a real connection attempt
+	handshake := newHandshake(c.net.conf, []byte("[]"))
+	handshake.Header.Type = TypeRejectAlternative
+	handshake.Reason = ReasonDialBack
+	if err := gob.NewEncoder(con).Encode(handshake); err != nil {
+		return fmt.Errorf("failed to send handshake: %v", err)
+	}
+
+	var reply Handshake
+	if err := gob.NewDecoder(con).Decode(&reply); err != nil {
+		return fmt.Errorf("failed to read handshake: %v", err)
+	}
+	if err := reply.Valid(c.net.conf); err != nil {
+		return err
+	}
+	if uint32(reply.Header.NodeID) != nodeID {
+		return fmt.Errorf("node id mismatch: got %08x, want %08x", uint32(reply.Header.NodeID), nodeID)
+	}
+	if reply.Header.PeerPort != ep.Port {
+		return fmt.Errorf("listen port mismatch: got %s, want %s", reply.Header.PeerPort, ep.Port)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_dialBacks(t *testing.T) {
+	db := newDialBacks()
+	now := time.Unix(1000, 0)
+	expiry := time.Hour
+	a := Endpoint{IP: "10.0.0.1", Port: "8108"}
+	b := Endpoint{IP: "10.0.0.2", Port: "8108"}
+
+	if !db.due(a, now, expiry) || db.verified(a) {
+		t.Errorf("unknown endpoint should be due and unverified")
+	}
+
+	db.set(a, true, now)
+	db.set(b, false, now)
+	if db.due(a, now.Add(time.Minute), expiry) {
+		t.Errorf("endpoint is due before the expiry")
+	}
+	if !db.verified(a) || db.verified(b) {
+		t.Errorf("verified(a) = %v, verified(b) = %v, want true, false", db.verified(a), db.verified(b))
+	}
+	if !db.due(a, now.Add(expiry), expiry) {
+		t.Errorf("endpoint is not due after the expiry")
+	}
+
+	db.prune(map[Endpoint]bool{a: true}, now.Add(expiry), expiry)
+	if _, ok := db.results[a]; !ok {
+		t.Errorf("connected endpoint was pruned")
+	}
+	if _, ok := db.results[b]; ok {
+		t.Errorf("expired endpoint was not pruned")
+	}
+}
+
+func TestNetwork_DialBack(t *testing.T) {
+	mt := NewMemoryTransport()
+
+	a, err := NewNetwork(testMemoryConfig(mt, "10.0.0.1", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Run()
+	defer a.Stop()
+
+	b, err := NewNetwork(testMemoryConfig(mt, "10.0.0.2", "10.0.0.1:8108"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Run()
+	defer b.Stop()
+
+	// c connects to a without listening for connections itself
+	c, err := NewNetwork(testMemoryConfig(mt, "10.0.0.3", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	go func() {
+		for range c.controller.peerStatus {
+		}
+	}()
+	waitFor(time.Second, func() bool {
+		a.controller.listenerMtx.Lock()
+		defer a.controller.listenerMtx.Unlock()
+		return a.controller.listener != nil
+	})
+	if ok, _ := c.controller.Dial(Endpoint{IP: "10.0.0.1", Port: "8108"}); !ok {
+		t.Fatal("unable to connect c to a")
+	}
+
+	reachable := Endpoint{IP: "10.0.0.2", Port: "8108"}
+	unreachable := Endpoint{IP: "10.0.0.3", Port: "8108"}
+
+	if !waitFor(time.Second*5, func() bool {
+		now := a.clock.Now()
+		return a.Total() == 2 &&
+			!a.controller.dialBacks.due(reachable, now, time.Hour) &&
+			!a.controller.dialBacks.due(unreachable, now, time.Hour)
+	}) {
+		t.Fatal("incoming peers were not dialed back")
+	}
+
+	if !a.controller.dialBacks.verified(reachable) {
+		t.Errorf("listening peer %s was not verified", reachable)
+	}
+	if a.controller.dialBacks.verified(unreachable) {
+		t.Errorf("peer %s without listener was verified", unreachable)
+	}
+
+	share := a.controller.makePeerShare(Endpoint{})
+	if len(share) != 1 || share[0] != reachable {
+		t.Errorf("share = %v, want [%s]", share, reachable)
+	}
+
+	// b's connection to a must survive being dialed back
+	if b.Total() != 1 {
+		t.Errorf("b has %d peers after the dial back, want 1", b.Total())
+	}
+}
+
+func TestNetwork_DialBackMultiplexed(t *testing.T) {
+	mt := NewMemoryTransport()
+
+	a, err := NewNetwork(testMemoryConfig(mt, "10.0.0.1", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Run()
+	defer a.Stop()
+
+	// b shares its listening address with another network
+	mux := NewMultiplexer(mt)
+	confB := testMemoryConfig(mt, "10.0.0.2", "10.0.0.1:8108")
+	confB.Transport = mux.Transport(confB.Network)
+	b, err := NewNetwork(confB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confOther := testMemoryConfig(mt, "10.0.0.2", "")
+	confOther.Network = TestNet
+	confOther.Transport = mux.Transport(TestNet)
+	other, err := NewNetwork(confOther)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []*Network{b, other} {
+		n.Run()
+		defer n.Stop()
+	}
+
+	ep := Endpoint{IP: "10.0.0.2", Port: "8108"}
+	if !waitFor(time.Second*5, func() bool {
+		return a.Total() == 1 && !a.controller.dialBacks.due(ep, a.clock.Now(), time.Hour)
+	}) {
+		t.Fatal("multiplexed peer was not dialed back")
+	}
+	if !a.controller.dialBacks.verified(ep) {
+		t.Errorf("multiplexed peer %s was not verified", ep)
+	}
+	if b.Total() != 1 {
+		t.Errorf("b has %d peers after the dial back, want 1", b.Total())
+	}
+}
+
+func TestNetwork_DialBackRetryAfter(t *testing.T) {
+	mt := NewMemoryTransport()
+
+	a, err := NewNetwork(testMemoryConfig(mt, "10.0.0.1", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewNetwork(testMemoryConfig(mt, "10.0.0.2", "10.0.0.1:8108"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b asked a not to dial it for a while
+	ep := Endpoint{IP: "10.0.0.2", Port: "8108"}
+	a.controller.dialer.RetryAfter(ep, time.Hour)
+
+	a.Run()
+	defer a.Stop()
+	b.Run()
+	defer b.Stop()
+
+	if !waitFor(time.Second*5, func() bool { return a.Total() == 1 }) {
+		t.Fatal("b did not connect to a")
+	}
+	time.Sleep(time.Millisecond * 100) // several dial back intervals
+	if !a.controller.dialBacks.due(ep, a.clock.Now(), time.Hour) {
+		t.Errorf("endpoint %s was dialed back before its retry time", ep)
+	}
+}
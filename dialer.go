@@ -9,20 +9,25 @@ import (
 
 // Dialer is a construct to throttle dialing and limit by attempts
 type Dialer struct {
-	dialer      net.Dialer
+	transport   Transport
+	clock       Clock
 	bindTo      string
 	interval    time.Duration
 	timeout     time.Duration
 	attempts    map[Endpoint]time.Time
+	retry       map[Endpoint]time.Time // endpoint => time the remote node asked us to wait until
 	attemptsMtx sync.RWMutex
 }
 
-// NewDialer creates a new Dialer
-func NewDialer(bindTo string, interval, timeout time.Duration) (*Dialer, error) {
+// NewDialer creates a new Dialer that establishes connections via the given transport
+func NewDialer(transport Transport, clock Clock, bindTo string, interval, timeout time.Duration) (*Dialer, error) {
 	d := new(Dialer)
+	d.transport = transport
+	d.clock = clock
 	d.interval = interval
 	d.timeout = timeout
 	d.attempts = make(map[Endpoint]time.Time)
+	d.retry = make(map[Endpoint]time.Time)
 
 	err := d.Bind(bindTo)
 	if err != nil {
@@ -32,16 +37,13 @@ func NewDialer(bindTo string, interval, timeout time.Duration) (*Dialer, error)
 	return d, nil
 }
 
+// Bind sets the local ip address to dial from. Leave blank to let the
+// transport decide
 func (d *Dialer) Bind(to string) error {
-	local, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:0", to))
-	if err != nil {
-		return err
+	if to != "" && net.ParseIP(to) == nil {
+		return fmt.Errorf("unable to parse ip: %s", to)
 	}
 	d.bindTo = to
-	d.dialer = net.Dialer{
-		LocalAddr: local,
-		Timeout:   d.timeout,
-	}
 	return nil
 }
 
@@ -49,26 +51,65 @@ func (d *Dialer) Bind(to string) error {
 func (d *Dialer) CanDial(ep Endpoint) bool {
 	d.attemptsMtx.RLock()
 	defer d.attemptsMtx.RUnlock()
-	if a, ok := d.attempts[ep]; !ok || time.Since(a) >= d.interval {
+	return d.canDial(ep)
+}
+
+func (d *Dialer) canDial(ep Endpoint) bool {
+	if d.clock.Now().Before(d.retry[ep]) {
+		return false
+	}
+	if a, ok := d.attempts[ep]; !ok || d.clock.Since(a) >= d.interval {
 		return true
 	}
 
 	return false
 }
 
+// RetryAfter prevents dialing an endpoint for the given duration, as requested
+// by the remote node when it rejected or disconnected us
+func (d *Dialer) RetryAfter(ep Endpoint, wait time.Duration) {
+	d.attemptsMtx.Lock()
+	defer d.attemptsMtx.Unlock()
+	now := d.clock.Now()
+
+	// endpoints that are never dialed again would stay in the map forever
+	for e, until := range d.retry {
+		if !now.Before(until) {
+			delete(d.retry, e)
+		}
+	}
+
+	until := now.Add(wait)
+	if until.After(d.retry[ep]) {
+		d.retry[ep] = until
+	}
+}
+
 // Dial an ip. Returns the active TCP connection or error if it failed to connect
 func (d *Dialer) Dial(ep Endpoint) (net.Conn, error) {
 	d.attemptsMtx.Lock() // don't unlock with defer so we can dial concurrently
-	if t, ok := d.attempts[ep]; ok && time.Since(t) < d.interval {
+	if !d.canDial(ep) {
 		d.attemptsMtx.Unlock()
 		return nil, fmt.Errorf("dialing too soon")
 	}
-	d.attempts[ep] = time.Now()
+	d.attempts[ep] = d.clock.Now()
+	delete(d.retry, ep) // expired
 	d.attemptsMtx.Unlock()
 
-	con, err := d.dialer.Dial("tcp", ep.String())
+	con, err := d.transport.Dial(d.bindTo, ep.String(), d.timeout)
 	if err != nil {
 		return nil, err
 	}
 	return con, nil
 }
+
+// Attempts returns a copy of the time of the last dial attempt for each endpoint
+func (d *Dialer) Attempts() map[Endpoint]time.Time {
+	d.attemptsMtx.RLock()
+	defer d.attemptsMtx.RUnlock()
+	attempts := make(map[Endpoint]time.Time, len(d.attempts))
+	for ep, t := range d.attempts {
+		attempts[ep] = t
+	}
+	return attempts
+}
@@ -10,7 +10,7 @@ func TestDialer(t *testing.T) {
 
 	interval, timeout := time.Millisecond*150, time.Millisecond*25
 
-	d, err := NewDialer("127.0.0.1", interval, timeout)
+	d, err := NewDialer(TCPTransport{}, SystemClock{}, "127.0.0.1", interval, timeout)
 	if err != nil {
 		t.Error(err)
 	}
@@ -33,3 +33,37 @@ func TestDialer(t *testing.T) {
 		t.Error("can dial during second blocking interval")
 	}
 }
+
+func TestDialer_RetryAfter(t *testing.T) {
+	ep := Endpoint{IP: "10.0.0.1", Port: "8108"}
+	clock := NewVirtualClock(time.Unix(1000, 0))
+
+	d, err := NewDialer(NewMemoryTransport(), clock, "", time.Second, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.RetryAfter(ep, time.Minute)
+	d.RetryAfter(ep, time.Second) // shorter retries don't override longer ones
+	if d.CanDial(ep) {
+		t.Error("can dial before the retry time")
+	}
+	if _, err := d.Dial(ep); err == nil || err.Error() != "dialing too soon" {
+		t.Errorf("dial before the retry time returned %v", err)
+	}
+
+	other := Endpoint{IP: "10.0.0.2", Port: "8108"}
+	d.RetryAfter(other, time.Second)
+
+	clock.Advance(time.Minute)
+	if !d.CanDial(ep) {
+		t.Error("can't dial after the retry time")
+	}
+
+	// expired retries are removed when dialing and when adding another
+	d.Dial(ep)
+	d.RetryAfter(Endpoint{IP: "10.0.0.3", Port: "8108"}, time.Second)
+	if len(d.retry) != 1 {
+		t.Errorf("%d retries left, want 1", len(d.retry))
+	}
+}
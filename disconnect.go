@@ -0,0 +1,86 @@
+package p2p
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// disconnectTimeout is the time to send a TypeDisconnect before closing the connection
+const disconnectTimeout = time.Second
+
+// maxRetryAfter limits how long a remote node can ask us to wait before redialing
+const maxRetryAfter = time.Hour * 24
+
+// disconnectPayload is the json payload of TypeDisconnect parcels
+type disconnectPayload struct {
+	Reason     DisconnectReason `json:"reason"`
+	RetryAfter time.Duration    `json:"retry_after_ns,omitempty"`
+}
+
+// notifies returns true if the remote node should be told about the reason
+func (r DisconnectReason) notifies() bool {
+	return r != ReasonConnection
+}
+
+func newDisconnectParcel(reason DisconnectReason, retry time.Duration) *Parcel {
+	payload, _ := json.Marshal(disconnectPayload{Reason: reason, RetryAfter: retry})
+	return newParcel(TypeDisconnect, payload)
+}
+
+func parseDisconnect(payload []byte) (DisconnectReason, time.Duration, error) {
+	var d disconnectPayload
+	if err := json.Unmarshal(payload, &d); err != nil {
+		return "", 0, err
+	}
+	if d.Reason == "" {
+		return "", 0, fmt.Errorf("no reason given")
+	}
+	return d.Reason, clampRetry(d.RetryAfter), nil
+}
+
+func clampRetry(retry time.Duration) time.Duration {
+	if retry < 0 {
+		return 0
+	}
+	if retry > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return retry
+}
+
+// sendDisconnect tells the remote node why the connection is closed. It does
+// not close the connection
+func (p *Peer) sendDisconnect(reason DisconnectReason, retry time.Duration) {
+	p.limiter.SetWriteDeadline(time.Now().Add(disconnectTimeout))
+	if err := p.prot.Send(newDisconnectParcel(reason, retry)); err != nil {
+		p.logger.WithError(err).Debug("unable to send disconnect")
+	}
+}
+
+// refuse tells a peer that completed the handshake why it is not accepted
+func (p *Peer) refuse(reason DisconnectReason) {
+	if p.Capabilities.Has(CapDisconnect) {
+		p.sendDisconnect(reason, 0)
+	}
+}
+
+// sendFarewell sends the reason of a local disconnect if the peer was stopped
+// with one. Only called by sendLoop after the peer stopped
+func (p *Peer) sendFarewell() {
+	if p.farewell {
+		p.sendDisconnect(p.stopReason, p.retryAfter)
+	}
+}
+
+// processDisconnect stops the peer with the reason the remote node sent
+func (p *Peer) processDisconnect(parcel *Parcel) {
+	reason, retry, err := parseDisconnect(parcel.Payload)
+	if err != nil {
+		p.logger.WithError(err).Debug("received invalid disconnect")
+		p.stopWith(ReasonInvalid)
+		return
+	}
+	p.logger.Debugf("remote disconnected (%s, retry after %s)", reason, retry)
+	p.shutdown(reason, retry, true)
+}
@@ -0,0 +1,112 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_parseDisconnect(t *testing.T) {
+	tests := []struct {
+		name       string
+		parcel     *Parcel
+		wantReason DisconnectReason
+		wantRetry  time.Duration
+		wantErr    bool
+	}{
+		{"banned", newDisconnectParcel(ReasonBanned, time.Hour), ReasonBanned, time.Hour, false},
+		{"no retry", newDisconnectParcel(ReasonCATDrop, 0), ReasonCATDrop, 0, false},
+		{"retry too long", newDisconnectParcel(ReasonBanned, time.Hour*24*365), ReasonBanned, maxRetryAfter, false},
+		{"negative retry", newDisconnectParcel(ReasonFull, -time.Hour), ReasonFull, 0, false},
+		{"no reason", newDisconnectParcel("", time.Hour), "", 0, true},
+		{"not json", newParcel(TypeDisconnect, []byte("bye")), "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, retry, err := parseDisconnect(tt.parcel.Payload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDisconnect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if reason != tt.wantReason || retry != tt.wantRetry {
+				t.Errorf("parseDisconnect() = %s, %s, want %s, %s", reason, retry, tt.wantReason, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestNetwork_Disconnect(t *testing.T) {
+	mt := NewMemoryTransport()
+
+	a, err := NewNetwork(testMemoryConfig(mt, "10.0.0.1", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewNetwork(testMemoryConfig(mt, "10.0.0.2", "10.0.0.1:8108"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventsA, cancelA := a.Events()
+	defer cancelA()
+	eventsB, cancelB := b.Events()
+	defer cancelB()
+
+	a.Run()
+	b.Run()
+	defer a.Stop()
+	defer b.Stop()
+
+	connected := nextEvent(t, eventsA, EventPeerConnected)
+	nextEvent(t, eventsB, EventPeerConnected)
+
+	a.Ban(connected.Hash)
+
+	if e := nextEvent(t, eventsA, EventPeerDisconnected); e.Reason != ReasonBanned || e.Remote {
+		t.Errorf("unexpected local disconnect event %+v", e)
+	}
+	e := nextEvent(t, eventsB, EventPeerDisconnected)
+	if e.Reason != ReasonBanned || !e.Remote || e.Retry != maxRetryAfter {
+		t.Errorf("unexpected remote disconnect event %+v", e)
+	}
+	if b.controller.dialer.CanDial(e.Endpoint) {
+		t.Errorf("remote can redial before the retry time")
+	}
+}
+
+func TestNetwork_RejectReason(t *testing.T) {
+	mt := NewMemoryTransport()
+
+	confA := testMemoryConfig(mt, "10.0.0.1", "")
+	confA.Incoming = 0
+	confA.RedialInterval = time.Minute
+	a, err := NewNetwork(confA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewNetwork(testMemoryConfig(mt, "10.0.0.2", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.Run()
+	defer a.Stop()
+	defer b.Stop()
+
+	if !waitFor(time.Second*5, func() bool {
+		a.controller.listenerMtx.Lock()
+		defer a.controller.listenerMtx.Unlock()
+		return a.controller.listener != nil
+	}) {
+		t.Fatal("node did not start listening")
+	}
+
+	ep := Endpoint{IP: "10.0.0.1", Port: "8108"}
+	if ok, _ := b.controller.Dial(ep); ok {
+		t.Fatal("dial to a full node succeeded")
+	}
+	if b.controller.dialer.CanDial(ep) {
+		t.Errorf("can redial before the retry time")
+	}
+	retry := b.controller.dialer.retry[ep].Sub(b.clock.Now())
+	if retry <= 0 || retry > confA.RedialInterval {
+		t.Errorf("unexpected retry time %s", retry)
+	}
+}
@@ -0,0 +1,163 @@
+package p2p
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType identifies the kind of an Event
+type EventType int
+
+const (
+	// EventPeerConnected is sent when a peer completed the handshake and was added
+	EventPeerConnected EventType = iota
+	// EventPeerDisconnected is sent when a peer was removed, with the reason
+	EventPeerDisconnected
+	// EventBanned is sent when an ip or endpoint was banned
+	EventBanned
+	// EventUnbanned is sent when a ban was lifted
+	EventUnbanned
+	// EventDialFailed is sent when an endpoint could not be dialed
+	EventDialFailed
+	// EventHandshakeFailed is sent when a connection failed during the handshake
+	EventHandshakeFailed
+	// EventCATRound is sent after every CAT round with the number of dropped peers
+	EventCATRound
+	// EventSpecialPeerLost is sent in addition to EventPeerDisconnected if
+	// the peer was special
+	EventSpecialPeerLost
+)
+
+var eventTypeStrings = map[EventType]string{
+	EventPeerConnected:    "PeerConnected",
+	EventPeerDisconnected: "PeerDisconnected",
+	EventBanned:           "Banned",
+	EventUnbanned:         "Unbanned",
+	EventDialFailed:       "DialFailed",
+	EventHandshakeFailed:  "HandshakeFailed",
+	EventCATRound:         "CATRound",
+	EventSpecialPeerLost:  "SpecialPeerLost",
+}
+
+func (t EventType) String() string {
+	if s, ok := eventTypeStrings[t]; ok {
+		return s
+	}
+	return "Unknown"
+}
+
+// DisconnectReason explains why a peer was disconnected
+type DisconnectReason string
+
+// The reasons for disconnecting a peer
+const (
+	ReasonStopped     DisconnectReason = "stopped"      // stopped locally or the network shut down
+	ReasonConnection  DisconnectReason = "connection"   // reading or writing failed
+	ReasonInvalid     DisconnectReason = "invalid"      // the peer sent an invalid parcel
+	ReasonPingTimeout DisconnectReason = "ping_timeout" // the peer did not answer pings
+	ReasonBanned      DisconnectReason = "banned"       // the peer's address was banned
+	ReasonCATDrop     DisconnectReason = "cat_drop"     // dropped in a CAT round
+	ReasonManual      DisconnectReason = "manual"       // disconnected by the application
+	ReasonReplaced    DisconnectReason = "replaced"     // a new connection with the same hash replaced it
+	ReasonFull        DisconnectReason = "full"         // no more incoming connections are accepted
+	ReasonIPLimit     DisconnectReason = "ip_limit"     // too many connections from the same ip
+	ReasonVetoed      DisconnectReason = "vetoed"       // refused by the status validator or connection gater
+	ReasonDialBack    DisconnectReason = "dial_back"    // the connection only verified that the node is reachable
+	ReasonNetwork     DisconnectReason = "network"      // the node is not part of the peer's network
+)
+
+// Event is a change in the network's peers. Only the fields relevant to the
+// Type are set
+type Event struct {
+	Type EventType
+	Time time.Time
+
+	Hash     string           // the peer's hash for peer events
+	Endpoint Endpoint         // the endpoint for peer, dial, and handshake events
+	Address  string           // the banned ip or ip:port for ban events
+	Until    time.Time        // the end of a ban
+	Reason   DisconnectReason // why a peer disconnected
+	Remote   bool             // the remote node disconnected with the Reason
+	Retry    time.Duration    // how long the remote node asked us to wait before redialing
+	Error    string           // why a dial or handshake failed
+	Dropped  int              // number of peers dropped in a CAT round
+}
+
+// eventHub distributes events to all subscribers without blocking
+type eventHub struct {
+	mtx      sync.RWMutex
+	capacity uint
+	subs     []chan Event
+	closed   bool
+	dropped  func()
+}
+
+func newEventHub(capacity uint, dropped func()) *eventHub {
+	return &eventHub{capacity: capacity, dropped: dropped}
+}
+
+// subscribe creates a new subscriber channel. The channel is closed when the
+// network stops or the returned cancel func is called
+func (h *eventHub) subscribe() (<-chan Event, func()) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	ch := make(chan Event, h.capacity)
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	h.subs = append(h.subs, ch)
+	return ch, func() { h.unsubscribe(ch) }
+}
+
+// unsubscribe removes and closes a subscriber channel. Channels that were
+// already removed are ignored
+func (h *eventHub) unsubscribe(ch chan Event) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	for i, sub := range h.subs {
+		if sub == ch {
+			h.subs = append(h.subs[:i], h.subs[i+1:]...)
+			close(ch)
+			return
+		}
+	}
+}
+
+// emit delivers the event to every subscriber with room in its buffer.
+// Subscribers that are full miss the event
+func (h *eventHub) emit(e Event) {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+	if h.closed {
+		return
+	}
+	for _, ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+			if h.dropped != nil {
+				h.dropped()
+			}
+		}
+	}
+}
+
+func (h *eventHub) close() {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for _, ch := range h.subs {
+		close(ch)
+	}
+	h.subs = nil
+}
+
+// emit timestamps an event and sends it to the subscribers
+func (n *Network) emit(e Event) {
+	e.Time = n.clock.Now()
+	n.events.emit(e)
+}
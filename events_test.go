@@ -0,0 +1,117 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_eventHub(t *testing.T) {
+	dropped := 0
+	h := newEventHub(2, func() { dropped++ })
+
+	fast, _ := h.subscribe()
+	slow, _ := h.subscribe()
+	gone, cancel := h.subscribe()
+	cancel()
+	cancel() // no panic when cancelled twice
+	if _, ok := <-gone; ok {
+		t.Errorf("cancelled subscription is still open")
+	}
+
+	for i := 0; i < 3; i++ {
+		h.emit(Event{Type: EventCATRound, Dropped: i})
+		if i < 2 {
+			<-fast
+		}
+	}
+	if dropped != 1 {
+		t.Errorf("dropped = %d, want 1", dropped)
+	}
+	if len(slow) != 2 || len(fast) != 1 {
+		t.Errorf("unexpected buffered events: slow = %d, fast = %d", len(slow), len(fast))
+	}
+
+	h.close()
+	h.emit(Event{Type: EventCATRound}) // no panic after closing
+	for range slow {
+	}
+	late, cancel := h.subscribe()
+	if _, ok := <-late; ok {
+		t.Errorf("subscribing after close returned an open channel")
+	}
+	cancel()
+}
+
+// nextEvent waits for the next event of the given type, skipping others
+func nextEvent(t *testing.T, events <-chan Event, typ EventType) Event {
+	t.Helper()
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case e := <-events:
+			if e.Type == typ {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("no %s event", typ)
+		}
+	}
+}
+
+func TestNetwork_Events(t *testing.T) {
+	mt := NewMemoryTransport()
+
+	a, err := NewNetwork(testMemoryConfig(mt, "10.0.0.1", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewNetwork(testMemoryConfig(mt, "10.0.0.2", "10.0.0.1:8108"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewNetwork(testMemoryConfig(mt, "10.0.0.3", "10.0.0.9:8108"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eventsA, cancelA := a.Events()
+	defer cancelA()
+	eventsB, cancelB := b.Events()
+	defer cancelB()
+	eventsC, cancelC := c.Events()
+	defer cancelC()
+
+	for _, n := range []*Network{a, b, c} {
+		n.Run()
+		defer n.Stop()
+	}
+
+	connected := nextEvent(t, eventsA, EventPeerConnected)
+	if connected.Endpoint.IP != "10.0.0.2" || connected.Hash == "" {
+		t.Errorf("unexpected connect event %+v", connected)
+	}
+
+	if e := nextEvent(t, eventsC, EventDialFailed); e.Endpoint.IP != "10.0.0.9" || e.Error == "" {
+		t.Errorf("unexpected dial event %+v", e)
+	}
+
+	a.Ban(connected.Hash)
+	if e := nextEvent(t, eventsA, EventBanned); e.Address != "10.0.0.2" || !e.Until.After(e.Time) {
+		t.Errorf("unexpected ban event %+v", e)
+	}
+	if e := nextEvent(t, eventsA, EventPeerDisconnected); e.Hash != connected.Hash || e.Reason != ReasonBanned {
+		t.Errorf("unexpected disconnect event %+v", e)
+	}
+	if e := nextEvent(t, eventsB, EventSpecialPeerLost); e.Endpoint.IP != "10.0.0.1" {
+		t.Errorf("unexpected special peer event %+v", e)
+	}
+
+	a.controller.unban("10.0.0.2")
+	if e := nextEvent(t, eventsA, EventUnbanned); e.Address != "10.0.0.2" {
+		t.Errorf("unexpected unban event %+v", e)
+	}
+
+	a.Stop()
+	for range eventsA {
+	}
+}
@@ -0,0 +1,150 @@
+package p2p
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// maxObservers is the number of peers whose observations of our address are kept
+const maxObservers = 256
+
+// observation is the address of this node as seen by a peer
+type observation struct {
+	address string
+	time    time.Time
+}
+
+// externalAddress aggregates the addresses peers observe for this node. An
+// address is confident once enough peers with different ips agree on it
+type externalAddress struct {
+	mtx       sync.RWMutex
+	threshold int
+	observers map[string]observation // peer ip => our address as seen by that peer
+	confident string
+
+	// self contains endpoints that turned out to be this node
+	self map[Endpoint]bool
+}
+
+func newExternalAddress(threshold int) *externalAddress {
+	ea := new(externalAddress)
+	ea.threshold = threshold
+	ea.observers = make(map[string]observation)
+	ea.self = make(map[Endpoint]bool)
+	return ea
+}
+
+// observe records the address a peer observed for us. Returns true if the
+// confident address changed
+func (ea *externalAddress) observe(observer, address string, now time.Time) bool {
+	ip := net.ParseIP(address)
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() {
+		return false
+	}
+
+	ea.mtx.Lock()
+	defer ea.mtx.Unlock()
+
+	if _, ok := ea.observers[observer]; !ok && len(ea.observers) >= maxObservers {
+		var oldest string
+		for o, obs := range ea.observers {
+			if oldest == "" || obs.time.Before(ea.observers[oldest].time) {
+				oldest = o
+			}
+		}
+		delete(ea.observers, oldest)
+	}
+	ea.observers[observer] = observation{address: ip.String(), time: now}
+
+	votes := make(map[string]int)
+	best, bestVotes := "", 0
+	for _, obs := range ea.observers {
+		votes[obs.address]++
+		if n := votes[obs.address]; n > bestVotes || (n == bestVotes && obs.address < best) {
+			best, bestVotes = obs.address, n
+		}
+	}
+	if bestVotes < ea.threshold {
+		best = ""
+	}
+
+	changed := best != ea.confident
+	ea.confident = best
+	return changed
+}
+
+// address returns the confident external address, or an empty string if
+// there isn't one yet
+func (ea *externalAddress) address() string {
+	ea.mtx.RLock()
+	defer ea.mtx.RUnlock()
+	return ea.confident
+}
+
+// addSelf remembers an endpoint that leads back to this node
+func (ea *externalAddress) addSelf(ep Endpoint) {
+	ea.mtx.Lock()
+	defer ea.mtx.Unlock()
+	ea.self[ep] = true
+}
+
+// isSelf checks if an endpoint leads back to this node
+func (c *controller) isSelf(ep Endpoint) bool {
+	if ep.Port == c.net.conf.ListenPort {
+		if ep.IP == c.net.conf.BindIP || ep.IP == c.externalIP() {
+			return true
+		}
+	}
+	c.external.mtx.RLock()
+	defer c.external.mtx.RUnlock()
+	return c.external.self[ep]
+}
+
+// externalIP returns the configured external ip or the one discovered from peers
+func (c *controller) externalIP() string {
+	if c.net.conf.ExternalIP != "" {
+		return c.net.conf.ExternalIP
+	}
+	return c.external.address()
+}
+
+// selfEndpoint returns the endpoint other nodes can reach this node at, if known
+func (c *controller) selfEndpoint() (Endpoint, bool) {
+	ip := c.externalIP()
+	if ip == "" {
+		return Endpoint{}, false
+	}
+	ep, err := NewEndpoint(ip, c.net.conf.ListenPort)
+	return ep, err == nil
+}
+
+// observe records the address a newly connected peer observed for us. Only
+// peers we dialed count, otherwise anyone able to connect to us could pick
+// our external address
+func (c *controller) observe(peer *Peer) {
+	if peer.observed == "" || peer.IsIncoming {
+		return
+	}
+	if c.external.observe(peer.Endpoint.IP, peer.observed, c.net.clock.Now()) {
+		if ip := c.external.address(); ip != "" {
+			c.logger.Infof("discovered external address %s", ip)
+		}
+	}
+}
+
+// advertiseSelf adds our own endpoint to a peer share if we accept incoming
+// connections and know our external address
+func (c *controller) advertiseSelf(share []Endpoint) []Endpoint {
+	if c.net.conf.Incoming == 0 || c.net.conf.PeerShareAmount == 0 {
+		return share
+	}
+	self, ok := c.selfEndpoint()
+	if !ok {
+		return share
+	}
+	if uint(len(share)) >= c.net.conf.PeerShareAmount && len(share) > 0 {
+		share = share[:len(share)-1]
+	}
+	return append(share, self)
+}
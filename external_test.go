@@ -0,0 +1,97 @@
+package p2p
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_externalAddress_observe(t *testing.T) {
+	ea := newExternalAddress(3)
+	now := time.Unix(1000, 0)
+
+	tests := []struct {
+		observer string
+		address  string
+		want     string
+	}{
+		{"10.0.0.1", "1.2.3.4", ""},
+		{"10.0.0.1", "1.2.3.4", ""}, // same observer doesn't count twice
+		{"10.0.0.2", "1.2.3.4", ""},
+		{"10.0.0.3", "127.0.0.1", ""}, // loopback is ignored
+		{"10.0.0.3", "not an ip", ""},
+		{"10.0.0.3", "1.2.3.4", "1.2.3.4"},
+		{"10.0.0.4", "5.6.7.8", "1.2.3.4"},
+		{"10.0.0.1", "5.6.7.8", ""}, // 2 to 2
+		{"10.0.0.2", "5.6.7.8", "5.6.7.8"},
+	}
+	for i, tt := range tests {
+		ea.observe(tt.observer, tt.address, now)
+		if got := ea.address(); got != tt.want {
+			t.Errorf("%d: address() = %q, want %q", i, got, tt.want)
+		}
+	}
+
+	// oldest observers are replaced once the limit is reached
+	for i := 0; i < maxObservers; i++ {
+		ea.observe(fmt.Sprintf("10.1.%d.%d", i/256, i%256), "9.9.9.9", now.Add(time.Second))
+	}
+	if len(ea.observers) != maxObservers {
+		t.Errorf("%d observers kept, want %d", len(ea.observers), maxObservers)
+	}
+	if got := ea.address(); got != "9.9.9.9" {
+		t.Errorf("address() = %q, want 9.9.9.9", got)
+	}
+}
+
+func TestNetwork_ExternalAddress(t *testing.T) {
+	mt := NewMemoryTransport()
+
+	// a learns its address from the nodes it dials
+	confA := testMemoryConfig(mt, "10.0.0.1", "10.0.0.2:8108,10.0.0.3:8108")
+	confA.ExternalConfirmations = 2
+	a, err := NewNetwork(confA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// incoming peers don't get a vote
+	for i := 0; i < 3; i++ {
+		liar := &Peer{IsIncoming: true, Endpoint: Endpoint{IP: fmt.Sprintf("10.0.1.%d", i), Port: "8108"}, observed: "6.6.6.6"}
+		a.controller.observe(liar)
+	}
+	if got := a.ExternalAddress(); got != "" {
+		t.Errorf("incoming peers set the external address to %q", got)
+	}
+
+	for i := 2; i <= 3; i++ {
+		n, err := NewNetwork(testMemoryConfig(mt, fmt.Sprintf("10.0.0.%d", i), ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.Run()
+		defer n.Stop()
+	}
+	a.Run()
+	defer a.Stop()
+
+	if !waitFor(time.Second*5, func() bool { return a.ExternalAddress() == "10.0.0.1" }) {
+		t.Fatalf("external address not discovered: %q", a.ExternalAddress())
+	}
+
+	self := Endpoint{IP: "10.0.0.1", Port: "8108"}
+	if !a.controller.isSelf(self) {
+		t.Errorf("external endpoint not recognized as self")
+	}
+	if ok, _ := a.controller.Dial(self); ok {
+		t.Errorf("node dialed itself")
+	}
+	if a.controller.isBannedEndpoint(self) {
+		t.Errorf("node banned its own endpoint")
+	}
+
+	share := a.controller.advertiseSelf(a.controller.makePeerShare(Endpoint{}))
+	if len(share) == 0 || share[len(share)-1] != self {
+		t.Errorf("share %v does not advertise %s", share, self)
+	}
+}
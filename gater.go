@@ -0,0 +1,53 @@
+package p2p
+
+import (
+	"fmt"
+	"net"
+)
+
+// ConnectionGater lets the application decide which connections are allowed
+// in addition to the network's own rules. Returning an error refuses the
+// connection at that stage. All methods may be called concurrently
+type ConnectionGater interface {
+	// InterceptAccept is called once for each incoming connection before the
+	// handshake. Behind a multiplexer it is only called by the network that
+	// receives the connection
+	InterceptAccept(addr net.Addr) error
+	// InterceptDial is called before dialing an endpoint. It may be called
+	// more than once per dial
+	InterceptDial(ep Endpoint) error
+	// InterceptSecured is called after a successful handshake in both
+	// directions, before the peer is added to the network. The peer's
+	// handshake fields and IsIncoming are set
+	InterceptSecured(peer *Peer) error
+}
+
+func (c *controller) gateAccept(addr net.Addr) error {
+	if c.net.conf.ConnectionGater == nil {
+		return nil
+	}
+	if err := c.net.conf.ConnectionGater.InterceptAccept(addr); err != nil {
+		return fmt.Errorf("gater refused connection from %s: %v", addr, err)
+	}
+	return nil
+}
+
+func (c *controller) gateDial(ep Endpoint) error {
+	if c.net.conf.ConnectionGater == nil {
+		return nil
+	}
+	if err := c.net.conf.ConnectionGater.InterceptDial(ep); err != nil {
+		return fmt.Errorf("gater refused dial to %s: %v", ep, err)
+	}
+	return nil
+}
+
+func (n *Network) gateSecured(peer *Peer) error {
+	if n.conf.ConnectionGater == nil {
+		return nil
+	}
+	if err := n.conf.ConnectionGater.InterceptSecured(peer); err != nil {
+		return fmt.Errorf("gater refused peer: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package p2p
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testGater struct {
+	mtx      sync.Mutex
+	dialed   []string
+	accepted int
+	incoming map[string]bool // direction of secured peers by ip
+	blocked  string
+}
+
+func (g *testGater) InterceptAccept(addr net.Addr) error {
+	g.mtx.Lock()
+	g.accepted++
+	g.mtx.Unlock()
+	if strings.HasPrefix(addr.String(), g.blocked+":") {
+		return fmt.Errorf("blocked")
+	}
+	return nil
+}
+
+func (g *testGater) InterceptDial(ep Endpoint) error {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.dialed = append(g.dialed, ep.IP)
+	if ep.IP == g.blocked {
+		return fmt.Errorf("blocked")
+	}
+	return nil
+}
+
+func (g *testGater) InterceptSecured(peer *Peer) error {
+	g.mtx.Lock()
+	if g.incoming == nil {
+		g.incoming = make(map[string]bool)
+	}
+	if !peer.connected.IsZero() {
+		g.incoming[peer.Endpoint.IP] = peer.IsIncoming
+	}
+	g.mtx.Unlock()
+	if peer.UserAgent == "blocked" {
+		return fmt.Errorf("blocked user agent")
+	}
+	return nil
+}
+
+func TestConnectionGater(t *testing.T) {
+	mt := NewMemoryTransport()
+
+	newNode := func(ip, special string, modify func(*Configuration)) *Network {
+		conf := testMemoryConfig(mt, ip, special)
+		modify(&conf)
+		n, err := NewNetwork(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.Run()
+		return n
+	}
+
+	hubGater := &testGater{blocked: "10.0.0.2"}
+	dialGater := &testGater{blocked: "10.0.0.1"}
+
+	hub := newNode("10.0.0.1", "", func(c *Configuration) { c.ConnectionGater = hubGater })
+	defer hub.Stop()
+	refusedAccept := newNode("10.0.0.2", "10.0.0.1:8108", func(*Configuration) {})
+	defer refusedAccept.Stop()
+	refusedDial := newNode("10.0.0.3", "10.0.0.1:8108", func(c *Configuration) { c.ConnectionGater = dialGater })
+	defer refusedDial.Stop()
+	refusedSecured := newNode("10.0.0.4", "10.0.0.1:8108", func(c *Configuration) { c.UserAgent = "blocked" })
+	defer refusedSecured.Stop()
+	allowedGater := &testGater{}
+	allowed := newNode("10.0.0.5", "10.0.0.1:8108", func(c *Configuration) { c.ConnectionGater = allowedGater })
+	defer allowed.Stop()
+
+	if !waitFor(time.Second*5, func() bool { return allowed.Total() == 1 }) {
+		t.Fatalf("allowed node did not connect")
+	}
+	time.Sleep(time.Millisecond * 200)
+
+	if refusedAccept.Total() != 0 {
+		t.Errorf("node connected despite refused accept")
+	}
+	if refusedDial.Total() != 0 {
+		t.Errorf("node connected despite refused dial")
+	}
+	for hash := range hub.GetPeerMetrics() {
+		if hash[:8] != "10.0.0.5" {
+			t.Errorf("hub connected to %s", hash)
+		}
+	}
+
+	dialGater.mtx.Lock()
+	defer dialGater.mtx.Unlock()
+	if len(dialGater.dialed) == 0 {
+		t.Errorf("dial gater was not consulted")
+	}
+
+	// the gater sees the direction of the connection
+	hubGater.mtx.Lock()
+	defer hubGater.mtx.Unlock()
+	if incoming, ok := hubGater.incoming["10.0.0.5"]; !ok || !incoming {
+		t.Errorf("hub gater saw the allowed node as incoming = %v (secured %v)", incoming, ok)
+	}
+	allowedGater.mtx.Lock()
+	defer allowedGater.mtx.Unlock()
+	if incoming, ok := allowedGater.incoming["10.0.0.1"]; !ok || incoming {
+		t.Errorf("allowed node's gater saw the hub as incoming = %v (secured %v)", incoming, ok)
+	}
+}
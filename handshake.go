@@ -3,11 +3,87 @@ package p2p
 import (
 	"fmt"
 	"hash/crc32"
+	"net"
 	"strconv"
+	"strings"
+	"time"
 )
 
-// Handshake is an alias of V9MSG for backward compatibility
-type Handshake V9Msg
+// maxUserAgentLength is the longest accepted user agent and software version
+const maxUserAgentLength = 256
+
+// Handshake is the first message sent over a connection. It has the same layout
+// as V9Msg for backward compatibility. Older nodes ignore the additional fields
+type Handshake struct {
+	Header  V9Header
+	Payload []byte
+
+	// Capabilities are the optional features supported by the sender
+	Capabilities Capability
+	// UserAgent is the name of the software the sender runs
+	UserAgent string
+	// SoftwareVersion is the version of the software the sender runs
+	SoftwareVersion string
+	// Status is supplied by the sender's application, eg its chain height
+	Status []byte
+	// ObservedAddress is the ip address the sender sees the connection coming from
+	ObservedAddress string
+	// ParcelTypes are the custom parcel types the sender has registered
+	ParcelTypes []ParcelType
+	// Reason and RetryAfter explain a TypeRejectAlternative
+	Reason     DisconnectReason
+	RetryAfter time.Duration
+}
+
+// Capability is a bitfield of optional protocol features
+type Capability uint64
+
+const (
+	// CapDeflate is the ability to receive DEFLATE compressed V10 payloads
+	CapDeflate Capability = 1 << iota
+	// CapChunking is the ability to reassemble application messages that were
+	// split into TypeMessagePart chunks on V10 connections
+	CapChunking
+	// CapRequests is the ability to answer TypeRequest parcels. Older nodes
+	// disconnect peers that send unknown parcel types
+	CapRequests
+	// CapTopics is the ability to exchange subscriptions and topic messages
+	CapTopics
+	// CapFullNode is set by nodes that process and store the full chain.
+	// Relays that only forward messages leave it unset
+	CapFullNode
+	// CapDisconnect is the ability to receive TypeDisconnect parcels
+	CapDisconnect
+)
+
+// protocolCapabilities are determined by the package and can't be configured
+const protocolCapabilities = CapDeflate | CapChunking | CapRequests | CapTopics | CapDisconnect
+
+// CapApplication is the lowest capability bit free for application use.
+// All bits below are reserved for the package
+const CapApplication Capability = 1 << 32
+
+var capabilityNames = []string{"deflate", "chunking", "requests", "topics", "fullnode", "disconnect"}
+
+// Has checks if all of the given capabilities are set
+func (c Capability) Has(caps Capability) bool {
+	return c&caps == caps
+}
+
+func (c Capability) String() string {
+	var names []string
+	for i := uint(0); i < 64; i++ {
+		if c&(1<<i) == 0 {
+			continue
+		}
+		if int(i) < len(capabilityNames) {
+			names = append(names, capabilityNames[i])
+		} else {
+			names = append(names, fmt.Sprintf("bit%d", i))
+		}
+	}
+	return strings.Join(names, ",")
+}
 
 // Valid checks if the other node is compatible
 func (h *Handshake) Valid(conf *Configuration) error {
@@ -40,6 +116,24 @@ func (h *Handshake) Valid(conf *Configuration) error {
 	if port < 1 || port > 65535 {
 		return fmt.Errorf("given port out of range: %d", port)
 	}
+
+	if len(h.UserAgent) > maxUserAgentLength || len(h.SoftwareVersion) > maxUserAgentLength {
+		return fmt.Errorf("user agent or software version longer than %d bytes", maxUserAgentLength)
+	}
+
+	if len(h.Status) > maxStatusLength {
+		return fmt.Errorf("status longer than %d bytes", maxStatusLength)
+	}
+
+	if h.ObservedAddress != "" && net.ParseIP(h.ObservedAddress) == nil {
+		return fmt.Errorf("unable to parse observed address %s", h.ObservedAddress)
+	}
+
+	for _, t := range h.ParcelTypes {
+		if t < TypeExtensionMin {
+			return fmt.Errorf("parcel type %d is not a custom type", t)
+		}
+	}
 	return nil
 }
 
@@ -54,6 +148,12 @@ func newHandshake(conf *Configuration, payload []byte) *Handshake {
 		AppHash:  "NetworkMessage",
 		AppType:  "Network",
 	}
+	hs.Capabilities = conf.Capabilities&^protocolCapabilities | CapChunking | CapRequests | CapTopics | CapDisconnect
+	hs.UserAgent = conf.UserAgent
+	hs.SoftwareVersion = conf.SoftwareVersion
+	if conf.EnableCompression {
+		hs.Capabilities |= CapDeflate
+	}
 	hs.SetPayload(payload)
 	return hs
 }
@@ -1,12 +1,17 @@
 package p2p
 
-import "testing"
+import (
+	"bytes"
+	"encoding/gob"
+	"strings"
+	"testing"
+)
 
 func TestHandshake_Valid(t *testing.T) {
 	conf := DefaultP2PConfiguration()
 
 	var handshakes []*Handshake
-	for i := 0; i < 13; i++ {
+	for i := 0; i < 17; i++ {
 		hs := newHandshake(&conf, []byte("nonce"))
 		hs.Header.NodeID++
 		hs.Header.PeerAddress = "127.0.0.1"
@@ -27,6 +32,10 @@ func TestHandshake_Valid(t *testing.T) {
 	handshakes[10].Header.Crc32 = 0xf00
 	handshakes[11].Payload = []byte("Invalid")
 	handshakes[12].Header.PeerAddress = ""
+	handshakes[13].UserAgent = strings.Repeat("x", maxUserAgentLength+1)
+	handshakes[14].Status = make([]byte, maxStatusLength+1)
+	handshakes[15].ParcelTypes = []ParcelType{TypeExtensionMin, TypeExtensionMax}
+	handshakes[16].ParcelTypes = []ParcelType{TypeExtensionMin, TypeMessage}
 
 	type args struct {
 		conf *Configuration
@@ -50,6 +59,10 @@ func TestHandshake_Valid(t *testing.T) {
 		{"wrong payload crc", handshakes[10], args{&conf}, true},
 		{"wrong payload bytes", handshakes[11], args{&conf}, true},
 		{"no peer address", handshakes[12], args{&conf}, false},
+		{"user agent too long", handshakes[13], args{&conf}, true},
+		{"status too long", handshakes[14], args{&conf}, true},
+		{"custom parcel types", handshakes[15], args{&conf}, false},
+		{"built-in parcel type", handshakes[16], args{&conf}, true},
 	}
 
 	for _, tt := range tests {
@@ -60,3 +73,66 @@ func TestHandshake_Valid(t *testing.T) {
 		})
 	}
 }
+
+func TestHandshake_legacyCompatible(t *testing.T) {
+	conf := DefaultP2PConfiguration()
+	conf.SoftwareVersion = "1.0"
+	conf.Capabilities = CapDeflate | CapApplication // protocol bits can't be configured
+	conf.EnableCompression = false
+	hs := newHandshake(&conf, []byte("nonce"))
+	if hs.Capabilities.Has(CapDeflate) || !hs.Capabilities.Has(CapApplication) {
+		t.Errorf("unexpected capabilities %s", hs.Capabilities)
+	}
+	conf.EnableCompression = true
+	hs = newHandshake(&conf, []byte("nonce"))
+	if !hs.Capabilities.Has(CapDeflate) {
+		t.Errorf("handshake does not offer compression")
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(hs); err != nil {
+		t.Fatal(err)
+	}
+	var legacy V9Msg
+	if err := gob.NewDecoder(buf).Decode(&legacy); err != nil {
+		t.Fatalf("legacy node unable to decode handshake: %v", err)
+	}
+	if legacy.Header != hs.Header || string(legacy.Payload) != "nonce" {
+		t.Errorf("legacy decode mismatch: %+v", legacy)
+	}
+
+	// handshakes of legacy nodes have no user agent or capabilities
+	buf.Reset()
+	if err := gob.NewEncoder(buf).Encode(legacy); err != nil {
+		t.Fatal(err)
+	}
+	var reply Handshake
+	if err := gob.NewDecoder(buf).Decode(&reply); err != nil {
+		t.Fatalf("unable to decode legacy handshake: %v", err)
+	}
+	if reply.UserAgent != "" || reply.SoftwareVersion != "" || reply.Capabilities != 0 || reply.Valid(&conf) != nil {
+		t.Errorf("unexpected legacy handshake %+v", reply)
+	}
+
+	conf.EnableCompression = false
+	if newHandshake(&conf, []byte("nonce")).Capabilities.Has(CapDeflate) {
+		t.Errorf("handshake offers compression when disabled")
+	}
+}
+
+func TestCapability_String(t *testing.T) {
+	tests := []struct {
+		c    Capability
+		want string
+	}{
+		{0, ""},
+		{CapDeflate, "deflate"},
+		{CapTopics | CapFullNode, "topics,fullnode"},
+		{CapChunking | CapApplication, "chunking,bit32"},
+	}
+	for _, tt := range tests {
+		if got := tt.c.String(); got != tt.want {
+			t.Errorf("Capability(%d).String() = %q, want %q", uint64(tt.c), got, tt.want)
+		}
+	}
+}
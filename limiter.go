@@ -3,16 +3,19 @@ package p2p
 import (
 	"fmt"
 	"net"
+	"sync"
 	"time"
 )
 
 // LimitedListener will block multiple connection attempts from a single ip
 // within a specific timeframe
 type LimitedListener struct {
-	listener       net.Listener
+	listener       Listener
+	clock          Clock
 	limit          time.Duration
 	lastConnection time.Time
 	history        []limitedConnect
+	historyMtx     sync.Mutex
 }
 
 type limitedConnect struct {
@@ -20,18 +23,19 @@ type limitedConnect struct {
 	time    time.Time
 }
 
-// NewLimitedListener initializes a new listener for the specified address (address:port)
-// throttling incoming connections
-func NewLimitedListener(address string, limit time.Duration) (*LimitedListener, error) {
+// NewLimitedListener initializes a new listener of the transport for the specified
+// address (address:port) throttling incoming connections
+func NewLimitedListener(transport Transport, clock Clock, address string, limit time.Duration) (*LimitedListener, error) {
 	if limit < 0 {
 		return nil, fmt.Errorf("Invalid time limit (negative)")
 	}
-	l, err := net.Listen("tcp", address)
+	l, err := transport.Listen(address)
 	if err != nil {
 		return nil, err
 	}
 	return &LimitedListener{
 		listener:       l,
+		clock:          clock,
 		limit:          limit,
 		lastConnection: time.Time{},
 		history:        nil,
@@ -40,7 +44,7 @@ func NewLimitedListener(address string, limit time.Duration) (*LimitedListener,
 
 // clearHistory truncates the history to only relevant entries
 func (ll *LimitedListener) clearHistory() {
-	tl := time.Now().Add(-ll.limit) // get timelimit of range to check
+	tl := ll.clock.Now().Add(-ll.limit) // get timelimit of range to check
 
 	// no connection made in the last X seconds
 	// the vast majority of connections will proc this
@@ -67,6 +71,8 @@ func (ll *LimitedListener) clearHistory() {
 // isInHistory checks if an address has connected in the last X seconds
 // clears history before checking
 func (ll *LimitedListener) isInHistory(addr string) bool {
+	ll.historyMtx.Lock()
+	defer ll.historyMtx.Unlock()
 	ll.clearHistory()
 
 	for _, h := range ll.history {
@@ -79,8 +85,11 @@ func (ll *LimitedListener) isInHistory(addr string) bool {
 
 // addToHistory adds an address to the system at the current time
 func (ll *LimitedListener) addToHistory(addr string) {
-	ll.history = append(ll.history, limitedConnect{address: addr, time: time.Now()})
-	ll.lastConnection = time.Now()
+	ll.historyMtx.Lock()
+	defer ll.historyMtx.Unlock()
+	now := ll.clock.Now()
+	ll.history = append(ll.history, limitedConnect{address: addr, time: now})
+	ll.lastConnection = now
 }
 
 // Accept accepts a connection if no other connection attempt from that ip has been made
@@ -112,7 +121,7 @@ func (ll *LimitedListener) Addr() net.Addr {
 	return ll.listener.Addr()
 }
 
-// Close closes the associated net.Listener
+// Close closes the associated Listener
 func (ll *LimitedListener) Close() {
 	ll.listener.Close()
 }
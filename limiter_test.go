@@ -28,7 +28,7 @@ func TestNewLimitedListener(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := NewLimitedListener(tt.args.address, tt.args.limit)
+			got, err := NewLimitedListener(TCPTransport{}, SystemClock{}, tt.args.address, tt.args.limit)
 			if got != nil {
 				defer got.Close()
 			}
@@ -54,6 +54,7 @@ func createLLHistory() *LimitedListener {
 	future := limitedConnect{"future", time.Now().Add(time.Hour * 2)}
 	return &LimitedListener{
 		listener:       nil,
+		clock:          SystemClock{},
 		limit:          time.Hour,
 		lastConnection: time.Now(),
 		history:        []limitedConnect{past, presence, future}, // order matters, old < new
@@ -160,7 +161,7 @@ func Test_NewLimitedListener(t *testing.T) {
 	// servers runs on 127.0.0.1:0
 	// connections will be made via 127.0.0.x:0
 
-	ll, err := NewLimitedListener("127.0.0.1:0", time.Millisecond*10)
+	ll, err := NewLimitedListener(TCPTransport{}, SystemClock{}, "127.0.0.1:0", time.Millisecond*10)
 	if err != nil {
 		t.Fatalf("Error starting listener: %v", err)
 	}
@@ -0,0 +1,283 @@
+package p2p
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// memoryFlushTimeout is the maximum time a closed memory connection waits for
+// the remote side to read the remaining buffered data
+const memoryFlushTimeout = time.Second
+
+var _ Transport = (*MemoryTransport)(nil)
+
+// MemoryTransport is an in-process transport built on net.Pipe.
+// All instances that share a MemoryTransport can connect to each other, which allows
+// tests and simulations to run many networks in one process without opening sockets.
+//
+// Each instance should use a distinct BindIP so that addresses can be told apart.
+type MemoryTransport struct {
+	mtx       sync.Mutex
+	listeners map[string]*memoryListener // ip:port -> listener
+	ports     map[string]int             // ip -> last assigned ephemeral port
+}
+
+// NewMemoryTransport creates a new, empty in-memory network
+func NewMemoryTransport() *MemoryTransport {
+	mt := new(MemoryTransport)
+	mt.listeners = make(map[string]*memoryListener)
+	mt.ports = make(map[string]int)
+	return mt
+}
+
+// normalizeHost treats blank and unspecified addresses as "all addresses"
+func normalizeHost(host string) string {
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		return ""
+	}
+	return host
+}
+
+// ephemeral assigns a new port to an ip. expects mtx to be locked
+func (mt *MemoryTransport) ephemeral(ip string) string {
+	port := mt.ports[ip]
+	if port < 40000 || port >= 65535 {
+		port = 40000
+	}
+	port++
+	mt.ports[ip] = port
+	return strconv.Itoa(port)
+}
+
+// Listen registers a listener on the address. A blank ip listens on all addresses.
+func (mt *MemoryTransport) Listen(address string) (Listener, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	host = normalizeHost(host)
+
+	mt.mtx.Lock()
+	defer mt.mtx.Unlock()
+
+	if port == "0" {
+		port = mt.ephemeral(host)
+	}
+
+	addr := net.JoinHostPort(host, port)
+	if _, ok := mt.listeners[addr]; ok {
+		return nil, fmt.Errorf("address %s already in use", addr)
+	}
+
+	l := &memoryListener{
+		transport: mt,
+		addr:      memoryAddr(addr),
+		incoming:  make(chan net.Conn, 64),
+		closed:    make(chan bool),
+	}
+	mt.listeners[addr] = l
+	return l, nil
+}
+
+// Dial connects to a listener of the same transport
+func (mt *MemoryTransport) Dial(local, remote string, timeout time.Duration) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(remote)
+	if err != nil {
+		return nil, err
+	}
+	if local == "" {
+		local = "127.0.0.1"
+	}
+
+	mt.mtx.Lock()
+	l, ok := mt.listeners[net.JoinHostPort(host, port)]
+	if !ok {
+		l, ok = mt.listeners[net.JoinHostPort("", port)]
+	}
+	laddr := memoryAddr(net.JoinHostPort(local, mt.ephemeral(local)))
+	mt.mtx.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("dial memory %s: connection refused", remote)
+	}
+
+	raddr := memoryAddr(remote)
+	a, b := net.Pipe()
+	client := newMemoryConn(a, laddr, raddr)
+	server := newMemoryConn(b, raddr, laddr)
+
+	var expire <-chan time.Time
+	if timeout > 0 {
+		expire = time.After(timeout)
+	}
+
+	select {
+	case l.incoming <- server:
+		return client, nil
+	case <-l.closed:
+		err = fmt.Errorf("dial memory %s: connection refused", remote)
+	case <-expire:
+		err = fmt.Errorf("dial memory %s: i/o timeout", remote)
+	}
+	client.Close()
+	server.Close()
+	return nil, err
+}
+
+func (mt *MemoryTransport) remove(l *memoryListener) {
+	mt.mtx.Lock()
+	defer mt.mtx.Unlock()
+	if mt.listeners[string(l.addr)] == l {
+		delete(mt.listeners, string(l.addr))
+	}
+}
+
+// memoryAddr is a net.Addr of the MemoryTransport in the format "ip:port"
+type memoryAddr string
+
+func (ma memoryAddr) Network() string { return "memory" }
+func (ma memoryAddr) String() string  { return string(ma) }
+
+type memoryListener struct {
+	transport *MemoryTransport
+	addr      memoryAddr
+	incoming  chan net.Conn
+	closed    chan bool
+	closer    sync.Once
+}
+
+func (ml *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case con := <-ml.incoming:
+		return con, nil
+	case <-ml.closed:
+		return nil, &net.OpError{Op: "accept", Net: "memory", Addr: ml.addr, Err: io.ErrClosedPipe}
+	}
+}
+
+func (ml *memoryListener) Close() error {
+	ml.closer.Do(func() {
+		close(ml.closed)
+		ml.transport.remove(ml)
+	})
+	return nil
+}
+
+func (ml *memoryListener) Addr() net.Addr {
+	return ml.addr
+}
+
+// memoryConn wraps one end of a net.Pipe. Writes are buffered and delivered
+// asynchronously so that both ends can write before reading, as is possible with tcp
+type memoryConn struct {
+	pipe   net.Conn
+	local  net.Addr
+	remote net.Addr
+
+	mtx    sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	closed bool
+	err    error
+}
+
+func newMemoryConn(pipe net.Conn, local, remote net.Addr) *memoryConn {
+	mc := new(memoryConn)
+	mc.pipe = pipe
+	mc.local = local
+	mc.remote = remote
+	mc.cond = sync.NewCond(&mc.mtx)
+	go mc.writeLoop()
+	return mc
+}
+
+// writeLoop pushes buffered data into the pipe. After the connection is closed,
+// remaining data is flushed before the pipe is closed
+func (mc *memoryConn) writeLoop() {
+	defer mc.pipe.Close()
+	for {
+		mc.mtx.Lock()
+		for len(mc.buf) == 0 && !mc.closed {
+			mc.cond.Wait()
+		}
+		data := mc.buf
+		mc.buf = nil
+		mc.mtx.Unlock()
+
+		if len(data) == 0 { // closed and flushed
+			return
+		}
+
+		if _, err := mc.pipe.Write(data); err != nil {
+			mc.mtx.Lock()
+			mc.err = io.ErrClosedPipe
+			mc.buf = nil
+			mc.mtx.Unlock()
+			return
+		}
+	}
+}
+
+func (mc *memoryConn) Read(b []byte) (int, error) {
+	n, err := mc.pipe.Read(b)
+	if err != nil {
+		mc.mtx.Lock()
+		if mc.closed {
+			err = io.ErrClosedPipe
+		}
+		mc.mtx.Unlock()
+	}
+	return n, err
+}
+
+func (mc *memoryConn) Write(b []byte) (int, error) {
+	mc.mtx.Lock()
+	defer mc.mtx.Unlock()
+	if mc.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if mc.err != nil {
+		return 0, mc.err
+	}
+	mc.buf = append(mc.buf, b...)
+	mc.cond.Signal()
+	return len(b), nil
+}
+
+func (mc *memoryConn) Close() error {
+	mc.mtx.Lock()
+	defer mc.mtx.Unlock()
+	if mc.closed {
+		return nil
+	}
+	mc.closed = true
+	mc.pipe.SetReadDeadline(time.Now()) // unblock readers
+	mc.pipe.SetWriteDeadline(time.Now().Add(memoryFlushTimeout))
+	mc.cond.Signal()
+	return nil
+}
+
+func (mc *memoryConn) LocalAddr() net.Addr  { return mc.local }
+func (mc *memoryConn) RemoteAddr() net.Addr { return mc.remote }
+
+func (mc *memoryConn) SetDeadline(t time.Time) error {
+	return mc.SetReadDeadline(t)
+}
+
+func (mc *memoryConn) SetReadDeadline(t time.Time) error {
+	mc.mtx.Lock()
+	defer mc.mtx.Unlock()
+	if mc.closed {
+		return io.ErrClosedPipe
+	}
+	return mc.pipe.SetReadDeadline(t)
+}
+
+// SetWriteDeadline has no effect since writes never block
+func (mc *memoryConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,140 @@
+package p2p
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestMemoryTransport_Listen(t *testing.T) {
+	mt := NewMemoryTransport()
+
+	l, err := mt.Listen("10.0.0.1:8108")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	if l.Addr().String() != "10.0.0.1:8108" {
+		t.Errorf("unexpected listen address %s", l.Addr())
+	}
+
+	if _, err := mt.Listen("10.0.0.1:8108"); err == nil {
+		t.Errorf("able to listen on the same address twice")
+	}
+
+	if _, err := mt.Listen("10.0.0.2:8108"); err != nil {
+		t.Errorf("unable to listen on a different ip with the same port: %v", err)
+	}
+
+	all, err := mt.Listen(":9000")
+	if err != nil {
+		t.Fatalf("unable to listen on all addresses: %v", err)
+	}
+	if _, err := mt.Dial("10.0.0.3", "10.0.0.9:9000", time.Second); err != nil {
+		t.Errorf("unable to dial a listener on all addresses: %v", err)
+	}
+	all.Close()
+
+	l.Close()
+	if _, err := mt.Dial("10.0.0.3", "10.0.0.1:8108", time.Second); err == nil {
+		t.Errorf("able to dial a closed listener")
+	}
+	if _, err := mt.Listen("10.0.0.1:8108"); err != nil {
+		t.Errorf("unable to listen on a released address: %v", err)
+	}
+}
+
+func TestMemoryTransport_Dial(t *testing.T) {
+	mt := NewMemoryTransport()
+
+	l, err := mt.Listen("10.0.0.1:8108")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer l.Close()
+
+	if _, err := mt.Dial("10.0.0.2", "10.0.0.5:8108", time.Second); err == nil {
+		t.Errorf("able to dial an address nobody listens on")
+	}
+
+	client, err := mt.Dial("10.0.0.2", "10.0.0.1:8108", time.Second)
+	if err != nil {
+		t.Fatalf("unable to dial: %v", err)
+	}
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatalf("unable to accept: %v", err)
+	}
+
+	if client.RemoteAddr().String() != "10.0.0.1:8108" {
+		t.Errorf("client has wrong remote address %s", client.RemoteAddr())
+	}
+	if server.RemoteAddr().String() != client.LocalAddr().String() {
+		t.Errorf("server remote address %s does not match client local address %s", server.RemoteAddr(), client.LocalAddr())
+	}
+
+	// both sides writing before reading must not block
+	done := make(chan bool)
+	go func() {
+		client.Write([]byte("hello server"))
+		server.Write([]byte("hello client"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("writes blocked")
+	}
+
+	buf := make([]byte, 12)
+	if _, err := io.ReadFull(server, buf); err != nil || !bytes.Equal(buf, []byte("hello server")) {
+		t.Errorf("server read %q, %v", buf, err)
+	}
+	if _, err := io.ReadFull(client, buf); err != nil || !bytes.Equal(buf, []byte("hello client")) {
+		t.Errorf("client read %q, %v", buf, err)
+	}
+
+	// data written right before closing still arrives
+	client.Write([]byte("goodbye"))
+	client.Close()
+
+	buf = make([]byte, 7)
+	if _, err := io.ReadFull(server, buf); err != nil || !bytes.Equal(buf, []byte("goodbye")) {
+		t.Errorf("server read %q, %v", buf, err)
+	}
+	if _, err := server.Read(buf); err != io.EOF {
+		t.Errorf("expected EOF after remote closed, got %v", err)
+	}
+	if _, err := client.Write(buf); err == nil {
+		t.Errorf("able to write to closed connection")
+	}
+}
+
+func TestMemoryConn_CloseUnblocksRead(t *testing.T) {
+	mt := NewMemoryTransport()
+	l, _ := mt.Listen("10.0.0.1:8108")
+	defer l.Close()
+
+	client, err := mt.Dial("10.0.0.2", "10.0.0.1:8108", time.Second)
+	if err != nil {
+		t.Fatalf("unable to dial: %v", err)
+	}
+
+	read := make(chan error)
+	go func() {
+		_, err := client.Read(make([]byte, 1))
+		read <- err
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+	client.Close()
+
+	select {
+	case err := <-read:
+		if err != io.ErrClosedPipe {
+			t.Errorf("unexpected read error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("read was not unblocked by close")
+	}
+}
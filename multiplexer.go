@@ -0,0 +1,265 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Multiplexer shares one listening address between several networks with
+// different NetworkIDs. It reads the handshake of every incoming connection and
+// hands the connection to the network with the matching id. Connections for
+// networks that are not part of the multiplexer are rejected with ReasonNetwork.
+// Connections from addresses that every network refuses (banned, over the per
+// ip limit, or refused by the connection gater) are closed before their
+// handshake is read.
+//
+// Each network uses the Transport returned by Multiplexer.Transport with its
+// own NetworkID and keeps its own peers, bans, and CAT state. All networks have
+// to use the same BindIP and ListenPort.
+type Multiplexer struct {
+	// Timeout is the time a new connection has to send its handshake
+	Timeout time.Duration
+	// RetryAfter is how long nodes of unknown networks are asked to wait
+	// before dialing again
+	RetryAfter time.Duration
+
+	transport Transport
+	logger    *log.Entry
+
+	mtx       sync.Mutex
+	address   string
+	listener  Listener
+	listeners map[NetworkID]*muxListener
+}
+
+// NewMultiplexer creates a multiplexer on top of the given transport
+func NewMultiplexer(transport Transport) *Multiplexer {
+	m := new(Multiplexer)
+	m.Timeout = time.Second * 5
+	m.RetryAfter = time.Minute * 10
+	m.transport = transport
+	m.logger = packageLogger.WithField("subpackage", "Multiplexer")
+	m.listeners = make(map[NetworkID]*muxListener)
+	return m
+}
+
+// Transport returns the transport a network with the given id has to use
+func (m *Multiplexer) Transport(network NetworkID) Transport {
+	return &muxTransport{mux: m, network: network}
+}
+
+var _ Transport = (*muxTransport)(nil)
+
+// muxTransport dials with the underlying transport and listens via the multiplexer
+type muxTransport struct {
+	mux     *Multiplexer
+	network NetworkID
+
+	// screen is set by the network to check the address of new connections
+	screen func(addr net.Addr) error
+}
+
+func (mt *muxTransport) Dial(local, remote string, timeout time.Duration) (net.Conn, error) {
+	return mt.mux.transport.Dial(local, remote, timeout)
+}
+
+func (mt *muxTransport) Listen(address string) (Listener, error) {
+	return mt.mux.listen(mt.network, address, mt.screen)
+}
+
+// listen registers a network and starts the shared listener if necessary
+func (m *Multiplexer) listen(network NetworkID, address string, screen func(net.Addr) error) (Listener, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if _, ok := m.listeners[network]; ok {
+		return nil, fmt.Errorf("network %x is already listening", uint32(network))
+	}
+
+	if m.listener == nil {
+		l, err := m.transport.Listen(address)
+		if err != nil {
+			return nil, err
+		}
+		m.listener = l
+		m.address = address
+		go m.accept(l)
+	} else if address != m.address {
+		return nil, fmt.Errorf("multiplexer is listening on %s, not %s", m.address, address)
+	}
+
+	ml := &muxListener{mux: m, network: network, screen: screen, conns: make(chan net.Conn), closed: make(chan bool)}
+	m.listeners[network] = ml
+	return ml, nil
+}
+
+// remove unregisters a network and stops the shared listener after the last one
+func (m *Multiplexer) remove(ml *muxListener) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.listeners[ml.network] != ml {
+		return
+	}
+	delete(m.listeners, ml.network)
+	if len(m.listeners) == 0 && m.listener != nil {
+		m.listener.Close()
+		m.listener = nil
+		m.address = ""
+	}
+}
+
+func (m *Multiplexer) accept(l Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			m.mtx.Lock()
+			stopped := m.listener != l
+			m.mtx.Unlock()
+			if stopped {
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			m.logger.Debugf("shared listener stopped: %v", err)
+			return
+		}
+		go m.route(conn)
+	}
+}
+
+// admits checks if at least one network accepts connections from the address
+func (m *Multiplexer) admits(addr net.Addr) bool {
+	m.mtx.Lock()
+	listeners := make([]*muxListener, 0, len(m.listeners))
+	for _, ml := range m.listeners {
+		listeners = append(listeners, ml)
+	}
+	m.mtx.Unlock()
+
+	for _, ml := range listeners {
+		if ml.screen == nil || ml.screen(addr) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// route reads the handshake and passes the connection to the right network.
+// The bytes read from the connection are replayed so the network receives
+// the handshake as well
+func (m *Multiplexer) route(conn net.Conn) {
+	if !m.admits(conn.RemoteAddr()) {
+		m.logger.Debugf("refusing %s: no network accepts the address", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	conn.SetReadDeadline(time.Now().Add(m.Timeout))
+
+	var hs Handshake
+	if err := gob.NewDecoder(io.TeeReader(conn, buf)).Decode(&hs); err != nil {
+		m.logger.Debugf("unable to read handshake from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	m.mtx.Lock()
+	ml, ok := m.listeners[hs.Header.Network]
+	m.mtx.Unlock()
+	if !ok {
+		m.logger.Debugf("rejecting %s: wrong network id %x", conn.RemoteAddr(), uint32(hs.Header.Network))
+		m.reject(conn, hs)
+		return
+	}
+
+	conn.SetReadDeadline(time.Time{})
+	rc := &replayConn{Conn: conn, r: io.MultiReader(buf, conn)}
+	select {
+	case ml.conns <- rc:
+	case <-ml.closed:
+		conn.Close()
+	}
+}
+
+// reject tells the remote node that none of the networks match its handshake
+// and closes the connection
+func (m *Multiplexer) reject(conn net.Conn, hs Handshake) {
+	defer conn.Close()
+
+	m.mtx.Lock()
+	_, port, err := net.SplitHostPort(m.address)
+	m.mtx.Unlock()
+	if err != nil {
+		return
+	}
+
+	// the reply has to pass the remote node's handshake validation
+	conf := DefaultP2PConfiguration()
+	conf.Network = hs.Header.Network
+	conf.ProtocolVersion = hs.Header.Version
+	conf.ListenPort = port
+	reply := newHandshake(&conf, []byte("[]"))
+	reply.Header.Type = TypeRejectAlternative
+	reply.Reason = ReasonNetwork
+	reply.RetryAfter = m.RetryAfter
+
+	conn.SetWriteDeadline(time.Now().Add(m.Timeout))
+	if err := gob.NewEncoder(conn).Encode(reply); err != nil {
+		m.logger.Debugf("unable to reject %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// replayConn returns previously read data before reading from the connection
+type replayConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (rc *replayConn) Read(p []byte) (int, error) {
+	return rc.r.Read(p)
+}
+
+// muxListener receives the connections of one network
+type muxListener struct {
+	mux     *Multiplexer
+	network NetworkID
+	screen  func(net.Addr) error
+	conns   chan net.Conn
+	closed  chan bool
+	once    sync.Once
+}
+
+func (ml *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ml.conns:
+		return conn, nil
+	case <-ml.closed:
+		return nil, fmt.Errorf("listener closed")
+	}
+}
+
+func (ml *muxListener) Close() error {
+	ml.once.Do(func() {
+		close(ml.closed)
+		ml.mux.remove(ml)
+	})
+	return nil
+}
+
+func (ml *muxListener) Addr() net.Addr {
+	ml.mux.mtx.Lock()
+	defer ml.mux.mtx.Unlock()
+	if ml.mux.listener == nil {
+		return nil
+	}
+	return ml.mux.listener.Addr()
+}
@@ -0,0 +1,215 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultiplexer_listen(t *testing.T) {
+	mux := NewMultiplexer(NewMemoryTransport())
+
+	a, err := mux.Transport(LocalNet).Listen("10.0.0.1:8108")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mux.Transport(LocalNet).Listen("10.0.0.1:8108"); err == nil {
+		t.Errorf("listened twice with the same network")
+	}
+	if _, err := mux.Transport(TestNet).Listen("10.0.0.1:8109"); err == nil {
+		t.Errorf("listened on a different address")
+	}
+	b, err := mux.Transport(TestNet).Listen("10.0.0.1:8108")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.Close()
+	if mux.listener == nil {
+		t.Errorf("shared listener closed while a network is still listening")
+	}
+	b.Close()
+	if mux.listener != nil {
+		t.Errorf("shared listener still open after the last network closed")
+	}
+	if _, err := b.Accept(); err == nil {
+		t.Errorf("accepted on a closed listener")
+	}
+}
+
+func TestMultiplexer_replayConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		client.Write([]byte(" world"))
+		client.Close()
+	}()
+
+	rc := &replayConn{Conn: server, r: io.MultiReader(bytes.NewReader([]byte("hello")), server)}
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte("hello world")) {
+		t.Errorf("read %q, want %q", data, "hello world")
+	}
+}
+
+func TestMultiplexer_Networks(t *testing.T) {
+	mt := NewMemoryTransport()
+	mux := NewMultiplexer(mt)
+
+	newNode := func(id NetworkID, ip, special string, shared bool) *Network {
+		conf := testMemoryConfig(mt, ip, special)
+		conf.Network = id
+		if shared {
+			conf.Transport = mux.Transport(id)
+		}
+		n, err := NewNetwork(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	local := newNode(LocalNet, "10.0.0.1", "", true)
+	test := newNode(TestNet, "10.0.0.1", "", true)
+	localPeer := newNode(LocalNet, "10.0.0.2", "10.0.0.1:8108", false)
+	testPeer := newNode(TestNet, "10.0.0.3", "10.0.0.1:8108", false)
+	mainPeer := newNode(MainNet, "10.0.0.4", "10.0.0.1:8108", false)
+
+	for _, n := range []*Network{local, test, localPeer, testPeer, mainPeer} {
+		n.Run()
+		defer n.Stop()
+	}
+
+	if !waitFor(time.Second*5, func() bool {
+		return local.Total() == 1 && test.Total() == 1 && localPeer.Total() == 1 && testPeer.Total() == 1
+	}) {
+		t.Fatalf("networks did not connect: local = %d, test = %d", local.Total(), test.Total())
+	}
+
+	for hash := range local.GetPeerMetrics() {
+		if hash[:8] != "10.0.0.2" {
+			t.Errorf("local network connected to %s", hash)
+		}
+	}
+	for hash := range test.GetPeerMetrics() {
+		if hash[:8] != "10.0.0.3" {
+			t.Errorf("test network connected to %s", hash)
+		}
+	}
+
+	mux.mtx.Lock()
+	for id, ml := range mux.listeners {
+		if ml.screen == nil {
+			t.Errorf("network %x does not screen connections", uint32(id))
+		}
+	}
+	mux.mtx.Unlock()
+
+	time.Sleep(time.Millisecond * 100)
+	if mainPeer.Total() != 0 {
+		t.Errorf("peer with an unknown network id connected")
+	}
+}
+
+func TestMultiplexer_gater(t *testing.T) {
+	mt := NewMemoryTransport()
+	mux := NewMultiplexer(mt)
+
+	gater := new(testGater)
+	conf := testMemoryConfig(mt, "10.0.0.1", "")
+	conf.Transport = mux.Transport(conf.Network)
+	conf.ConnectionGater = gater
+	hub, err := NewNetwork(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := NewNetwork(testMemoryConfig(mt, "10.0.0.2", "10.0.0.1:8108"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.Run()
+	defer hub.Stop()
+	peer.Run()
+	defer peer.Stop()
+
+	if !waitFor(time.Second*5, func() bool { return hub.Total() == 1 }) {
+		t.Fatal("peer did not connect")
+	}
+
+	gater.mtx.Lock()
+	defer gater.mtx.Unlock()
+	if gater.accepted != 1 {
+		t.Errorf("gater was called %d times for one connection", gater.accepted)
+	}
+}
+
+func TestMultiplexer_reject(t *testing.T) {
+	mux := NewMultiplexer(NewMemoryTransport())
+	mux.address = "10.0.0.1:8108"
+
+	conf := DefaultP2PConfiguration()
+	conf.Network = MainNet
+	hs := newHandshake(&conf, []byte("nonce"))
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go mux.reject(server, *hs)
+
+	var reply Handshake
+	if err := gob.NewDecoder(client).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if err := reply.Valid(&conf); err != nil {
+		t.Errorf("reject is not a valid handshake: %v", err)
+	}
+	if reply.Header.Type != TypeRejectAlternative || reply.Reason != ReasonNetwork || reply.RetryAfter != mux.RetryAfter {
+		t.Errorf("reply = %s, %s, %s", reply.Header.Type, reply.Reason, reply.RetryAfter)
+	}
+}
+
+func TestMultiplexer_admits(t *testing.T) {
+	mux := NewMultiplexer(NewMemoryTransport())
+	refuse := func(ip string) func(net.Addr) error {
+		return func(addr net.Addr) error {
+			if strings.HasPrefix(addr.String(), ip+":") {
+				return fmt.Errorf("refused")
+			}
+			return nil
+		}
+	}
+
+	a, err := mux.listen(LocalNet, "10.0.0.1:8108", refuse("10.0.0.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := mux.listen(TestNet, "10.0.0.1:8108", refuse("10.0.0.3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := func(s string) net.Addr {
+		tcp, _ := net.ResolveTCPAddr("tcp", s)
+		return tcp
+	}
+	if !mux.admits(addr("10.0.0.2:1234")) || !mux.admits(addr("10.0.0.3:1234")) {
+		t.Errorf("refused an address that one network accepts")
+	}
+
+	b.Close()
+	if mux.admits(addr("10.0.0.2:1234")) {
+		t.Errorf("admitted an address that every network refuses")
+	}
+	if !mux.admits(addr("10.0.0.3:1234")) {
+		t.Errorf("refused an address after the refusing network left")
+	}
+}
@@ -1,7 +1,11 @@
 package p2p
 
 import (
+	"context"
 	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -19,15 +23,29 @@ type Network struct {
 	conf       *Configuration
 	controller *controller
 
-	prom *Prometheus
+	prom  *Prometheus
+	admin *adminServer
+
+	parcelTypes *parcelRegistry
+
+	uploadLimit   *TokenBucket
+	downloadLimit *TokenBucket
 
 	metricsHook func(pm map[string]PeerMetrics)
+	events      *eventHub
+
+	statusMtx       sync.RWMutex
+	statusProvider  StatusProvider
+	statusValidator StatusValidator
 
 	rng        *rand.Rand
+	clock      Clock
 	instanceID uint64
 	logger     *log.Entry
 
 	globalCloser chan interface{}
+	stopper      sync.Once
+	running      bool
 	fatalError   chan error
 }
 
@@ -43,15 +61,35 @@ func NewNetwork(conf Configuration) (*Network, error) {
 
 	n := new(Network)
 	n.fatalError = make(chan error)
+	n.globalCloser = make(chan interface{})
 
 	n.logger = packageLogger.WithField("subpackage", "Network").WithField("node", conf.NodeName)
 
 	n.conf = &myconf
 	if n.conf.EnablePrometheus {
 		n.prom = new(Prometheus)
-		n.prom.Setup()
+		if err := n.prom.Setup(n.conf.PrometheusRegisterer, n.conf.PrometheusLabels); err != nil {
+			return nil, err
+		}
+	}
+	n.clock = n.conf.Clock
+	n.parcelTypes = newParcelRegistry()
+	n.events = newEventHub(n.conf.ChannelCapacity, func() {
+		if n.prom != nil {
+			n.prom.EventDrops.Inc()
+		}
+	})
+	if n.conf.UploadLimit > 0 {
+		n.uploadLimit = NewTokenBucket(n.clock, n.conf.UploadLimit)
 	}
-	n.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	if n.conf.DownloadLimit > 0 {
+		n.downloadLimit = NewTokenBucket(n.clock, n.conf.DownloadLimit)
+	}
+	seed := n.conf.RandomSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	n.rng = rand.New(newLockedSource(seed)) // shared by all goroutines
 	// generate random instanceid for loopback detection
 	n.instanceID = n.rng.Uint64()
 
@@ -66,6 +104,7 @@ func NewNetwork(conf Configuration) (*Network, error) {
 	}
 	n.ToNetwork = newParcelChannel(conf.ChannelCapacity)
 	n.FromNetwork = newParcelChannel(conf.ChannelCapacity)
+	n.admin = newAdminServer(n)
 	return n, nil
 }
 
@@ -99,20 +138,71 @@ func (n *Network) SetMetricsHook(f func(pm map[string]PeerMetrics)) {
 	n.metricsHook = f
 }
 
+// SetStatusProvider sets the function that creates the status sent to peers in
+// the handshake. The status is limited to 1 KiB
+func (n *Network) SetStatusProvider(provider StatusProvider) {
+	n.statusMtx.Lock()
+	defer n.statusMtx.Unlock()
+	n.statusProvider = provider
+}
+
+// SetStatusValidator sets the function that decides if a peer is accepted based
+// on the status it sent in the handshake
+func (n *Network) SetStatusValidator(validator StatusValidator) {
+	n.statusMtx.Lock()
+	defer n.statusMtx.Unlock()
+	n.statusValidator = validator
+}
+
 // Run starts the network.
 // Listens to incoming connections on the specified port
 // and connects to other peers
 func (n *Network) Run() {
-	n.logger.Infof("Starting a P2P Network with configuration %+v", n.conf)
+	conf := redactConfig(*n.conf)
+	n.logger.Infof("Starting a P2P Network with configuration %+v", &conf)
 
+	n.running = true
 	n.controller.Start() // this will get peer manager ready to handle incoming connections
-	//DebugServer(n)
+	if n.prom != nil {
+		n.prom.Networks.Inc()
+	}
+
+	if err := n.admin.Start(); err != nil {
+		n.logger.WithError(err).Error("unable to start the admin api")
+	}
+}
+
+// AdminHandler returns the handler of the admin api, which can be used to
+// serve the api on an application's own http server. The bearer token from
+// the configuration is still required.
+func (n *Network) AdminHandler() http.Handler {
+	return n.admin
 }
 
+// Stop shuts down the network, closing the listener and disconnecting all peers.
+// A stopped network cannot be restarted
 func (n *Network) Stop() {
-	// TODO implement
-	// close stop channel
-	// stop all peers
+	n.stopper.Do(func() {
+		n.logger.Infof("Stopping the P2P Network")
+		close(n.globalCloser)
+		if n.prom != nil {
+			if n.running {
+				n.prom.Networks.Dec()
+			}
+			n.prom.Unregister()
+		}
+		n.admin.Stop()
+		n.controller.Stop()
+		n.events.close()
+	})
+}
+
+// Events returns a new channel that receives peer lifecycle events. Events are
+// dropped if the channel's buffer is full, so a slow reader never blocks the
+// network. The channel is closed when the network stops or when the returned
+// cancel func is called, which should be done once events are no longer read
+func (n *Network) Events() (<-chan Event, func()) {
+	return n.events.subscribe()
 }
 
 // Ban removes a peer as well as any other peer from that address
@@ -139,6 +229,54 @@ func (n *Network) SetSpecial(raw string) {
 	go n.controller.setSpecial(raw)
 }
 
+// Request sends a payload to the peer with the given hash and waits for the
+// response created by the remote node's request handler. Fails if the context
+// is done or there is no response within the RequestTimeout
+func (n *Network) Request(ctx context.Context, hash string, payload []byte) ([]byte, error) {
+	return n.controller.request(ctx, hash, payload)
+}
+
+// HandleRequests sets the function that answers requests from other nodes.
+// Requests are handled concurrently. If no handler is set, the requests fail on
+// the remote node
+func (n *Network) HandleRequests(handler RequestHandler) {
+	n.controller.setRequestHandler(handler)
+}
+
+// Subscribe returns the channel that receives messages published to a topic.
+// Connected peers are told about the subscription so they only send topics
+// this node is interested in
+func (n *Network) Subscribe(topic string) (ParcelChannel, error) {
+	return n.controller.subscribe(topic)
+}
+
+// Unsubscribe stops receiving messages of a topic
+func (n *Network) Unsubscribe(topic string) {
+	n.controller.unsubscribe(topic)
+}
+
+// Publish sends a payload to the special peers and up to Fanout other peers
+// that are subscribed to the topic
+func (n *Network) Publish(topic string, payload []byte) error {
+	return n.controller.publish(topic, payload)
+}
+
+// RegisterParcelType adds a custom parcel type with an id between TypeExtensionMin
+// and TypeExtensionMax. Parcels of that type received from peers are passed to
+// the handler. Parcels of a custom type are sent by setting the Type of a parcel
+// sent to ToNetwork. Registered types are announced in the handshake and parcels
+// are only sent to peers that registered the type, so types should be
+// registered before calling Run
+func (n *Network) RegisterParcelType(id ParcelType, name string, handler ParcelHandler) error {
+	return n.parcelTypes.register(id, name, handler)
+}
+
+// ExternalAddress returns the ip address other nodes can reach this node at,
+// either from the configuration or discovered from peers. Empty if unknown
+func (n *Network) ExternalAddress() string {
+	return n.controller.externalIP()
+}
+
 // Total returns the number of active connections
 func (n *Network) Total() int {
 	return n.controller.peers.Total()
@@ -146,5 +284,5 @@ func (n *Network) Total() int {
 
 // Rounds returns the total number of CAT rounds that have occurred
 func (n *Network) Rounds() int {
-	return n.controller.rounds
+	return int(atomic.LoadUint64(&n.controller.rounds))
 }
@@ -2,6 +2,8 @@ package p2p
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 // NetworkID represents the P2P network we are participating in (eg: test, nmain, etc.)
@@ -24,6 +26,32 @@ func NewNetworkID(name string) NetworkID {
 	return NetworkID(StringToUint32(name))
 }
 
+// ParseNetworkID converts user input to a network id. The input can be the name
+// of a predefined network (mainnet, testnet, localnet), a hex id ("0x..."),
+// or the name of a custom network
+func ParseNetworkID(s string) (NetworkID, error) {
+	switch strings.ToLower(s) {
+	case "mainnet", "main":
+		return MainNet, nil
+	case "testnet", "test":
+		return TestNet, nil
+	case "localnet", "local":
+		return LocalNet, nil
+	case "":
+		return 0, fmt.Errorf("no network specified")
+	}
+
+	if strings.HasPrefix(s, "0x") {
+		id, err := strconv.ParseUint(s[2:], 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid network id %s: %v", s, err)
+		}
+		return NetworkID(id), nil
+	}
+
+	return NewNetworkID(s), nil
+}
+
 func (n *NetworkID) String() string {
 	switch *n {
 	case MainNet:
@@ -0,0 +1,32 @@
+package p2p
+
+import "testing"
+
+func TestParseNetworkID(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    NetworkID
+		wantErr bool
+	}{
+		{"mainnet", "mainnet", MainNet, false},
+		{"testnet uppercase", "TESTNET", TestNet, false},
+		{"local", "local", LocalNet, false},
+		{"hex", "0xfeedbeef", MainNet, false},
+		{"bad hex", "0xfoo", 0, true},
+		{"custom", "myNetwork", NewNetworkID("myNetwork"), false},
+		{"empty", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseNetworkID(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseNetworkID() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseNetworkID() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package p2p
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testMemoryConfig creates a configuration for a node on an in-memory transport
+func testMemoryConfig(mt *MemoryTransport, ip string, special string) Configuration {
+	conf := DefaultP2PConfiguration()
+	conf.Network = LocalNet
+	conf.NodeName = fmt.Sprintf("Node-%s", ip)
+	conf.Transport = mt
+	conf.BindIP = ip
+	conf.Special = special
+	conf.EnablePrometheus = false
+	conf.DialBackInterval = time.Millisecond * 10
+	conf.RedialInterval = time.Millisecond * 100 // retry if the other listener wasn't up yet
+	return conf
+}
+
+// waitFor polls the condition until it's true or the timeout elapses
+func waitFor(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return condition()
+}
+
+func TestNetwork_MemoryTransport(t *testing.T) {
+	mt := NewMemoryTransport()
+
+	a, err := NewNetwork(testMemoryConfig(mt, "10.0.0.1", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewNetwork(testMemoryConfig(mt, "10.0.0.2", "10.0.0.1:8108"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.Run()
+	b.Run()
+	defer a.Stop()
+	defer b.Stop()
+
+	if !waitFor(time.Second*5, func() bool { return a.Total() == 1 && b.Total() == 1 }) {
+		t.Fatalf("nodes did not connect: a = %d, b = %d", a.Total(), b.Total())
+	}
+
+	b.ToNetwork.Send(NewParcel(Broadcast, []byte("hello")))
+
+	select {
+	case p := <-a.FromNetwork:
+		if !bytes.Equal(p.Payload, []byte("hello")) {
+			t.Errorf("received unexpected payload %q", p.Payload)
+		}
+		if p.Address != fmt.Sprintf("10.0.0.2:8108 %08x", b.conf.NodeID) {
+			t.Errorf("received parcel from unexpected address %s", p.Address)
+		}
+	case <-time.After(time.Second * 5):
+		t.Error("parcel did not arrive")
+	}
+}
+
+func TestNetwork_Stop(t *testing.T) {
+	mt := NewMemoryTransport()
+
+	a, err := NewNetwork(testMemoryConfig(mt, "10.0.0.1", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewNetwork(testMemoryConfig(mt, "10.0.0.2", "10.0.0.1:8108"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.Run()
+	b.Run()
+
+	if !waitFor(time.Second*5, func() bool { return a.Total() == 1 && b.Total() == 1 }) {
+		t.Fatalf("nodes did not connect: a = %d, b = %d", a.Total(), b.Total())
+	}
+
+	defer b.Stop()
+
+	a.Stop()
+	a.Stop() // no effect
+
+	if !waitFor(time.Second*5, func() bool { return b.Total() == 0 }) {
+		t.Errorf("peer did not disconnect after stopping the network")
+	}
+
+	if _, err := mt.Dial("10.0.0.3", "10.0.0.1:8108", time.Second); err == nil {
+		t.Errorf("listener still accepting connections after stopping the network")
+	}
+}
+
+func TestNetwork_Capabilities(t *testing.T) {
+	mt := NewMemoryTransport()
+
+	newNode := func(ip, special string, modify func(*Configuration)) *Network {
+		conf := testMemoryConfig(mt, ip, special)
+		modify(&conf)
+		n, err := NewNetwork(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.Run()
+		return n
+	}
+
+	a := newNode("10.0.0.1", "", func(*Configuration) {})
+	defer a.Stop()
+	b := newNode("10.0.0.2", "10.0.0.1:8108", func(c *Configuration) {
+		c.UserAgent = "relay"
+		c.SoftwareVersion = "1.2.3"
+		c.Capabilities = CapApplication
+	})
+	defer b.Stop()
+	c := newNode("10.0.0.3", "10.0.0.1:8108", func(c *Configuration) { c.Capabilities = 0 })
+	defer c.Stop()
+	d := newNode("10.0.0.4", "10.0.0.1:8108", func(c *Configuration) { c.RequiredCapabilities = CapApplication })
+	defer d.Stop()
+
+	if !waitFor(time.Second*5, func() bool { return b.Total() == 1 && c.Total() == 1 && a.Total() == 2 }) {
+		t.Fatalf("nodes did not connect: b = %d, c = %d", b.Total(), c.Total())
+	}
+	if d.Total() != 0 {
+		t.Errorf("node connected to a peer without the required capabilities")
+	}
+
+	for hash, pm := range a.GetPeerMetrics() {
+		if hash[:8] != "10.0.0.2" {
+			continue
+		}
+		if pm.UserAgent != "relay" || pm.SoftwareVersion != "1.2.3" {
+			t.Errorf("unexpected user agent %q and version %q", pm.UserAgent, pm.SoftwareVersion)
+		}
+		if !pm.Capabilities.Has(CapApplication|CapTopics) || pm.Capabilities.Has(CapFullNode) {
+			t.Errorf("unexpected capabilities %s", pm.Capabilities)
+		}
+	}
+
+	for _, target := range []string{FullBroadcast, RandomPeer} {
+		parcel := NewParcel(target, []byte(target))
+		parcel.Require = CapApplication
+		a.ToNetwork.Send(parcel)
+
+		select {
+		case p := <-b.FromNetwork:
+			if string(p.Payload) != target {
+				t.Errorf("received unexpected payload %q", p.Payload)
+			}
+		case <-time.After(time.Second * 5):
+			t.Errorf("%s parcel did not arrive", target)
+		}
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	select {
+	case p := <-c.FromNetwork:
+		t.Errorf("peer without capability received %q", p.Payload)
+	default:
+	}
+}
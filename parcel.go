@@ -28,6 +28,11 @@ type Parcel struct {
 	Type    ParcelType // 2 bytes - network level commands (eg: ping/pong)
 	Address string     // ? bytes - "" or nil for broadcast, otherwise the destination peer's hash.
 	Payload []byte
+	Topic   string // the topic of messages from subscriptions, empty otherwise
+
+	// Require limits the peers an outgoing parcel is sent to to those with
+	// all of the given capabilities
+	Require Capability
 }
 
 // IsApplicationMessage checks if the message is intended for the application
@@ -12,19 +12,20 @@ func TestParcelChannel_Send(t *testing.T) {
 	parcel := new(Parcel)
 	parcel.Payload = []byte("test")
 
-	finished := false
+	finished := make(chan bool)
 	go func() {
-		time.Sleep(time.Millisecond * 10)
-		if !finished {
-			t.Error("Channels caused a deadlock while writing")
+		for i := 0; i < 10; i++ {
+			smolChannel.Send(parcel)
+			bigChannel.Send(parcel)
 		}
+		close(finished)
 	}()
 
-	for i := 0; i < 10; i++ {
-		smolChannel.Send(parcel)
-		bigChannel.Send(parcel)
+	select {
+	case <-finished:
+	case <-time.After(time.Millisecond * 10):
+		t.Fatal("Channels caused a deadlock while writing")
 	}
-	finished = true
 
 	if len(smolChannel) != 1 {
 		t.Errorf("Small channel has unexpected length: %d", len(smolChannel))
@@ -0,0 +1,101 @@
+package p2p
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ParcelHandler processes a parcel of a custom type received from the peer with
+// the given hash. Handlers are called one at a time in the order parcels arrive,
+// separate from the routing of other parcels
+type ParcelHandler func(peer string, payload []byte)
+
+type customParcelType struct {
+	name    string
+	handler ParcelHandler
+}
+
+// parcelRegistry holds the custom parcel types of a network
+type parcelRegistry struct {
+	mtx   sync.RWMutex
+	types map[ParcelType]customParcelType
+	names map[string]bool
+}
+
+func newParcelRegistry() *parcelRegistry {
+	r := new(parcelRegistry)
+	r.types = make(map[ParcelType]customParcelType)
+	r.names = make(map[string]bool)
+	for _, name := range typeStrings {
+		r.names[name] = true
+	}
+	return r
+}
+
+func (r *parcelRegistry) register(id ParcelType, name string, handler ParcelHandler) error {
+	if id < TypeExtensionMin || id > TypeExtensionMax {
+		return fmt.Errorf("parcel type %d is outside of the extension range %d-%d", id, TypeExtensionMin, TypeExtensionMax)
+	}
+	if name == "" {
+		return fmt.Errorf("parcel type %d has no name", id)
+	}
+	if handler == nil {
+		return fmt.Errorf("parcel type %s has no handler", name)
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if existing, ok := r.types[id]; ok {
+		return fmt.Errorf("parcel type %d is already registered as %s", id, existing.name)
+	}
+	if r.names[name] {
+		return fmt.Errorf("parcel type name %s is already in use", name)
+	}
+	r.types[id] = customParcelType{name: name, handler: handler}
+	r.names[name] = true
+	return nil
+}
+
+func (r *parcelRegistry) get(id ParcelType) (customParcelType, bool) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	ct, ok := r.types[id]
+	return ct, ok
+}
+
+// ids returns the registered custom types
+func (r *parcelRegistry) ids() []ParcelType {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	ids := make([]ParcelType, 0, len(r.types))
+	for id := range r.types {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// valid checks a parcel that arrived from the network. Custom types are only
+// valid if they are registered
+func (r *parcelRegistry) valid(parcel *Parcel) error {
+	if parcel != nil && parcel.Type >= TypeExtensionMin {
+		if _, ok := r.get(parcel.Type); !ok {
+			return fmt.Errorf("unregistered parcel type %d", parcel.Type)
+		}
+		if len(parcel.Payload) == 0 {
+			return fmt.Errorf("zero-length payload")
+		}
+		return nil
+	}
+	return parcel.Valid()
+}
+
+// name returns the name of a built-in or registered parcel type
+func (r *parcelRegistry) name(id ParcelType) string {
+	if ct, ok := r.get(id); ok {
+		return ct.name
+	}
+	if s := id.String(); s != "" {
+		return s
+	}
+	return fmt.Sprintf("Unknown-%d", id)
+}
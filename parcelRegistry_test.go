@@ -0,0 +1,149 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_parcelRegistry_register(t *testing.T) {
+	r := newParcelRegistry()
+	handler := func(string, []byte) {}
+
+	tests := []struct {
+		name    string
+		id      ParcelType
+		tname   string
+		handler ParcelHandler
+		wantErr bool
+	}{
+		{"valid", TypeExtensionMin, "Status", handler, false},
+		{"highest id", TypeExtensionMax, "Sync", handler, false},
+		{"below range", TypeExtensionMin - 1, "Below", handler, true},
+		{"built-in id", TypePing, "MyPing", handler, true},
+		{"duplicate id", TypeExtensionMin, "Other", handler, true},
+		{"duplicate name", TypeExtensionMin + 1, "Status", handler, true},
+		{"built-in name", TypeExtensionMin + 2, "Ping", handler, true},
+		{"no name", TypeExtensionMin + 3, "", handler, true},
+		{"no handler", TypeExtensionMin + 4, "NoHandler", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := r.register(tt.id, tt.tname, tt.handler); (err != nil) != tt.wantErr {
+				t.Errorf("register() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := r.valid(newParcel(TypeExtensionMin, []byte("x"))); err != nil {
+		t.Errorf("registered type is invalid: %v", err)
+	}
+	if err := r.valid(newParcel(TypeExtensionMin, nil)); err == nil {
+		t.Errorf("registered type without payload is valid")
+	}
+	if err := r.valid(newParcel(TypeExtensionMin+5, []byte("x"))); err == nil {
+		t.Errorf("unregistered type is valid")
+	}
+	if err := r.valid(newParcel(TypeMessage, []byte("x"))); err != nil {
+		t.Errorf("built-in type is invalid: %v", err)
+	}
+
+	names := map[ParcelType]string{TypeExtensionMin: "Status", TypePing: "Ping", TypeExtensionMin + 5: "Unknown-32773"}
+	for id, want := range names {
+		if got := r.name(id); got != want {
+			t.Errorf("name(%d) = %s, want %s", id, got, want)
+		}
+	}
+}
+
+func TestNetwork_RegisterParcelType(t *testing.T) {
+	mt := NewMemoryTransport()
+	reg := prometheus.NewRegistry()
+
+	confA := testMemoryConfig(mt, "10.0.0.1", "")
+	confA.EnablePrometheus = true
+	confA.PrometheusRegisterer = reg
+	a, err := NewNetwork(confA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewNetwork(testMemoryConfig(mt, "10.0.0.2", "10.0.0.1:8108"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const typeStatus = TypeExtensionMin + 1
+	received := make(chan string, 1)
+	if err := a.RegisterParcelType(typeStatus, "Status", func(peer string, payload []byte) {
+		received <- string(payload)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	const typeSlow = TypeExtensionMin + 2
+	block := make(chan bool)
+	defer close(block)
+	if err := a.RegisterParcelType(typeSlow, "Slow", func(peer string, payload []byte) {
+		<-block
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Run()
+	b.Run()
+	defer a.Stop()
+	defer b.Stop()
+
+	if !waitFor(time.Second*5, func() bool { return a.Total() == 1 && b.Total() == 1 }) {
+		t.Fatalf("nodes did not connect")
+	}
+
+	parcel := NewParcel(Broadcast, []byte("height 1234"))
+	parcel.Type = typeStatus
+	b.ToNetwork.Send(parcel)
+
+	select {
+	case payload := <-received:
+		if payload != "height 1234" {
+			t.Errorf("handler received %q", payload)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("handler was not called")
+	}
+
+	// a slow handler does not hold up other parcels
+	slow := NewParcel(Broadcast, []byte("slow"))
+	slow.Type = typeSlow
+	b.ToNetwork.Send(slow)
+	b.ToNetwork.Send(NewParcel(Broadcast, []byte("message")))
+	select {
+	case p := <-a.FromNetwork:
+		if string(p.Payload) != "message" {
+			t.Errorf("received %q", p.Payload)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("message was held up by the custom handler")
+	}
+
+	// b has not registered the type, so a does not send it to b
+	parcel = NewParcel(Broadcast, []byte("height 1"))
+	parcel.Type = typeStatus
+	a.ToNetwork.Send(parcel)
+	a.ToNetwork.Send(NewParcel(Broadcast, []byte("after")))
+	select {
+	case p := <-b.FromNetwork:
+		if string(p.Payload) != "after" {
+			t.Errorf("received %q", p.Payload)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("message after the custom type did not arrive")
+	}
+	if a.Total() != 1 || b.Total() != 1 {
+		t.Errorf("nodes disconnected: a = %d, b = %d", a.Total(), b.Total())
+	}
+
+	labels := map[string]string{"type": "Status", "direction": "received"}
+	if got := counterValue(t, reg, "factomd_p2p_parcels_by_type", labels); got != 1 {
+		t.Errorf("parcels by type %v = %f, want 1", labels, got)
+	}
+}
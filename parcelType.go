@@ -18,12 +18,29 @@ const ( // iota is reset to 0
 	TypeAlert
 	// TypeMessage carries an application message in the payload
 	TypeMessage
-	// TypeMessagePart is a partial message. deprecated in p2p 2.0
+	// TypeMessagePart is a chunk of a large application message
 	TypeMessagePart
 	// TypeHandshake is the first parcel sent after making a connection
 	TypeHandshake
 	// TypeRejectAlternative is sent instead of a handshake if the server refuses connection
 	TypeRejectAlternative
+	// TypeRequest carries an application request with a correlation id
+	TypeRequest
+	// TypeResponse carries the reply to a TypeRequest with the same correlation id
+	TypeResponse
+	// TypeTopicMessage carries an application message published to a topic
+	TypeTopicMessage
+	// TypeSubscriptions carries the full list of topics a node is subscribed to
+	TypeSubscriptions
+	// TypeDisconnect is sent before closing a connection with the reason
+	TypeDisconnect
+)
+
+// Parcel types between TypeExtensionMin and TypeExtensionMax are reserved for
+// custom types added via Network.RegisterParcelType
+const (
+	TypeExtensionMin ParcelType = 0x8000
+	TypeExtensionMax ParcelType = 0xFFFF
 )
 
 var typeStrings = map[ParcelType]string{
@@ -37,6 +54,11 @@ var typeStrings = map[ParcelType]string{
 	TypeMessagePart:       "MessagePart",
 	TypeHandshake:         "Handshake",
 	TypeRejectAlternative: "Rejection-Alternative",
+	TypeRequest:           "Request",
+	TypeResponse:          "Response",
+	TypeTopicMessage:      "Topic-Message",
+	TypeSubscriptions:     "Subscriptions",
+	TypeDisconnect:        "Disconnect",
 }
 
 func (t ParcelType) String() string {
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -19,22 +20,58 @@ type Peer struct {
 	net     *Network
 	conn    net.Conn
 	metrics ReadWriteCollector
+	limiter *limitedReadWriter
 	prot    Protocol
 
-	// current state, read only "constants" after the handshake
-	IsIncoming bool
-	Endpoint   Endpoint
-	NodeID     uint32 // a nonce to distinguish multiple nodes behind one endpoint
-	Hash       string // This is more of a connection ID than hash right now.
+	// chunking is true if large messages can be split into chunks
+	chunking   bool
+	chunkID    uint64
+	reassembly *reassembler
+
+	// the deadlines of a single read and write, shorter for chunking peers
+	readDeadline  time.Duration
+	writeDeadline time.Duration
 
-	stopper sync.Once
-	stop    chan bool
+	// current state, read only "constants" after the handshake
+	IsIncoming   bool
+	Endpoint     Endpoint
+	NodeID       uint32     // a nonce to distinguish multiple nodes behind one endpoint
+	Capabilities Capability // the optional features supported by the remote node
+	UserAgent    string     // the software the remote node runs
+	Version      string     // the version of the remote node's software
+	Status       []byte     // the application status sent in the handshake
+	observed     string     // our ip address as seen by the remote node
+	Hash         string     // This is more of a connection ID than hash right now.
+
+	// the custom parcel types registered by the remote node
+	parcelTypes map[ParcelType]bool
+
+	stopper    sync.Once
+	stop       chan bool
+	stopReason DisconnectReason // why the peer was stopped
+	stopRemote bool             // the remote node gave the reason
+	retryAfter time.Duration    // the time to wait before redialing, sent or received
+	farewell   bool             // sendLoop sends the reason before closing
 
 	lastPeerRequest  time.Time
+	requests         int32 // requests from this peer being handled
 	peerShareAsk     bool
 	peerShareDeliver chan *Parcel
 	lastPeerSend     time.Time
 
+	// topics the remote node is subscribed to
+	topicMtx sync.RWMutex
+	topics   map[string]bool
+
+	// latency
+	pingMtx     sync.Mutex
+	pingNonce   uint64
+	pingPending bool
+	pingsMissed int
+	lastPing    time.Time
+	rtt         time.Duration
+	rttJitter   time.Duration
+
 	// communication channels
 	send       ParcelChannel   // parcels from Send() are added here
 	status     chan peerStatus // the controller's notification channel
@@ -52,7 +89,13 @@ type Peer struct {
 	totalBytesReceived   uint64
 	bpsDown, bpsUp       float64
 	mpsDown, mpsUp       float64
-	dropped              uint64
+	dropped              uint64 // atomic
+
+	// payload sizes before and after compression, atomic
+	uncompressedSent     uint64
+	compressedSent       uint64
+	uncompressedReceived uint64
+	compressedReceived   uint64
 
 	// logging
 	logger *log.Entry
@@ -82,11 +125,14 @@ func (p *Peer) bootstrapProtocol(hs *Handshake, conn net.Conn, decoder *gob.Deco
 	}
 
 	///fmt.Printf("@@@ %d %+v %s\n", v, hs.Header, conn.RemoteAddr())
+	p.readDeadline = p.net.conf.ReadDeadline
+	p.writeDeadline = p.net.conf.WriteDeadline
 	switch v {
 	case 9:
 		v9 := new(ProtocolV9)
 		v9.init(p, decoder, encoder)
 		p.prot = v9
+		p.chunking = true // legacy nodes understand message parts
 
 		// v9 starts with a peer request
 		hsParcel := new(Parcel)
@@ -99,8 +145,13 @@ func (p *Peer) bootstrapProtocol(hs *Handshake, conn net.Conn, decoder *gob.Deco
 			}*/
 	case 10:
 		v10 := new(ProtocolV10)
-		v10.init(p, decoder, encoder)
+		v10.init(p, decoder, encoder, p.net.conf.EnableCompression && hs.Capabilities.Has(CapDeflate))
 		p.prot = v10
+		p.chunking = hs.Capabilities.Has(CapChunking)
+		if p.chunking {
+			p.readDeadline = p.net.conf.ChunkReadDeadline
+			p.writeDeadline = p.net.conf.ChunkWriteDeadline
+		}
 	default:
 		return fmt.Errorf("unknown protocol version %d", v)
 	}
@@ -124,51 +175,76 @@ func (p *Peer) StartWithHandshake(ep Endpoint, con net.Conn, incoming bool) ([]E
 
 	nonce := []byte(fmt.Sprintf("%x", p.net.instanceID))
 
-	// upgrade connection to a metrics connection
-	p.metrics = NewMetricsReadWriter(con)
+	// upgrade connection to a bandwidth limited metrics connection
+	p.limiter = p.net.limitConnection(con, ep)
+	p.metrics = NewMetricsReadWriter(p.limiter)
 	p.conn = con
 
 	handshake := newHandshake(p.net.conf, nonce)
+	handshake.Status = p.net.localStatus()
+	handshake.ObservedAddress = ep.IP
+	handshake.ParcelTypes = p.net.parcelTypes.ids()
 	decoder := gob.NewDecoder(p.metrics) // pipe gob through the metrics writer
 	encoder := gob.NewEncoder(p.metrics)
-	con.SetWriteDeadline(timeout)
-	con.SetReadDeadline(timeout)
+	p.limiter.SetWriteDeadline(timeout)
+	p.limiter.SetReadDeadline(timeout)
 	//fmt.Printf("@@@ %+v %s\n", handshake.Header, con.RemoteAddr())
 
-	failfunc := func(err error) ([]Endpoint, error) {
+	direction := promOutgoing
+	if incoming {
+		direction = promIncoming
+	}
+	result := func(reason string) {
+		if p.net.prom != nil {
+			p.net.prom.Handshakes.WithLabelValues(direction, reason).Inc()
+		}
+	}
+
+	failfunc := func(reason string, err error) ([]Endpoint, error) {
 		tmplogger.WithError(err).Debug("Handshake failed")
+		result(reason)
+		p.net.emit(Event{Type: EventHandshakeFailed, Endpoint: ep, Error: err.Error()})
 		p.conn.Close()
 		return nil, err
 	}
 
 	err := encoder.Encode(handshake)
 	if err != nil {
-		return failfunc(fmt.Errorf("Failed to send handshake to incoming connection"))
+		return failfunc("send", fmt.Errorf("Failed to send handshake to incoming connection"))
 	}
 
 	var reply Handshake
 	err = decoder.Decode(&reply)
 	if err != nil {
-		return failfunc(fmt.Errorf("Failed to read handshake from incoming connection"))
+		return failfunc("receive", fmt.Errorf("Failed to read handshake from incoming connection"))
 	}
 
 	// check basic structure
 	if err = reply.Valid(p.net.conf); err != nil {
-		return failfunc(err)
+		return failfunc("invalid", err)
 	}
 
 	// loopback detection
 	if string(reply.Payload) == string(nonce) {
-		return failfunc(fmt.Errorf("loopback"))
+		return failfunc("loopback", fmt.Errorf("loopback"))
 	}
 
 	if err = p.bootstrapProtocol(&reply, con, decoder, encoder); err != nil {
-		return failfunc(err)
+		return failfunc("protocol", err)
 	}
 
 	if reply.Header.Type == TypeRejectAlternative {
 		con.Close()
+		if reply.Reason == ReasonDialBack {
+			tmplogger.Debug("con was a dial back")
+			result("dial_back")
+			return nil, fmt.Errorf("connection was a dial back")
+		}
 		tmplogger.Debug("con rejected with alternatives")
+		result("rejected")
+		if p.net.prom != nil {
+			p.net.prom.Rejections.WithLabelValues(promReceived).Inc()
+		}
 		var rawShare []Endpoint
 		err := json.Unmarshal(reply.Payload, &rawShare)
 		if err != nil {
@@ -181,17 +257,52 @@ func (p *Peer) StartWithHandshake(ep Endpoint, con net.Conn, incoming bool) ([]E
 				filtered = append(filtered, ep)
 			}
 		}
+		p.retryAfter = clampRetry(reply.RetryAfter)
+		if reply.Reason != "" {
+			return filtered, fmt.Errorf("connection rejected (%s)", reply.Reason)
+		}
 		return filtered, fmt.Errorf("connection rejected")
 	}
 
+	if !reply.Capabilities.Has(p.net.conf.RequiredCapabilities) {
+		return failfunc("capabilities", fmt.Errorf("peer is missing capabilities %s", p.net.conf.RequiredCapabilities&^reply.Capabilities))
+	}
+
 	// initialize channels
 	ep.Port = reply.Header.PeerPort
 	p.Endpoint = ep
 	p.NodeID = uint32(reply.Header.NodeID)
+	p.Capabilities = reply.Capabilities
+	p.UserAgent = reply.UserAgent
+	p.Version = reply.SoftwareVersion
+	p.Status = reply.Status
+	p.observed = reply.ObservedAddress
+	p.parcelTypes = make(map[ParcelType]bool)
+	for _, t := range reply.ParcelTypes {
+		p.parcelTypes[t] = true
+	}
 	p.Hash = fmt.Sprintf("%s:%s %08x", ep.IP, ep.Port, p.NodeID)
 	p.send = newParcelChannel(p.net.conf.ChannelCapacity)
 	p.IsIncoming = incoming
-	p.connected = time.Now()
+	p.connected = p.net.clock.Now()
+
+	// the validator and gater see the peer fully initialized
+	if err = p.net.validateStatus(p); err != nil {
+		p.refuse(ReasonVetoed)
+		return failfunc("status", err)
+	}
+
+	if err = p.net.gateSecured(p); err != nil {
+		p.refuse(ReasonVetoed)
+		return failfunc("gated", err)
+	}
+
+	p.reassembly = newReassembler(p.net.clock, p.net.conf.ChunkTimeout, int(p.net.conf.ChunkMemory), func(reason string) {
+		p.logger.WithField("reason", reason).Warn("discarded incomplete message")
+		if p.net.prom != nil {
+			p.net.prom.ChunkDrops.WithLabelValues(reason).Inc()
+		}
+	})
 	p.logger = p.logger.WithFields(log.Fields{
 		"hash":    p.Hash,
 		"address": p.Endpoint.IP,
@@ -199,8 +310,14 @@ func (p *Peer) StartWithHandshake(ep Endpoint, con net.Conn, incoming bool) ([]E
 		"Version": p.prot.Version(),
 	})
 
-	p.status <- peerStatus{peer: p, online: true}
+	// set before the controller can see the peer, which may stop it right away
 	p.registered = true
+	select {
+	case p.status <- peerStatus{peer: p, online: true}:
+	case <-p.net.globalCloser:
+		return failfunc("stopped", fmt.Errorf("network stopped"))
+	}
+	result("success")
 
 	go p.sendLoop()
 	go p.readLoop()
@@ -211,24 +328,46 @@ func (p *Peer) StartWithHandshake(ep Endpoint, con net.Conn, incoming bool) ([]E
 
 // Stop disconnects the peer from its active connection
 func (p *Peer) Stop() {
-	p.stopper.Do(func() {
-		p.logger.Debug("Stopping peer")
-		sc := p.send
+	p.stopWith(ReasonStopped)
+}
 
-		p.send = nil
+// stopWith stops the peer and records the reason. Only the first reason counts
+func (p *Peer) stopWith(reason DisconnectReason) {
+	p.shutdown(reason, 0, false)
+}
 
+// stopRetry stops the peer and asks the remote node to wait before redialing
+func (p *Peer) stopRetry(reason DisconnectReason, retry time.Duration) {
+	p.shutdown(reason, retry, false)
+}
+
+// shutdown stops the peer. Remote nodes that support it are sent the reason
+// of local decisions before the connection is closed
+func (p *Peer) shutdown(reason DisconnectReason, retry time.Duration, remote bool) {
+	p.stopper.Do(func() {
+		p.logger.Debugf("Stopping peer (%s)", reason)
+		p.stopReason = reason
+		p.stopRemote = remote
+		p.retryAfter = retry
+		p.farewell = !remote && p.registered && reason.notifies() && p.Capabilities.Has(CapDisconnect)
+
+		// p.send is left open since Send may be called concurrently. sendLoop
+		// exits on p.stop and parcels sent afterward are discarded with the peer
 		close(p.stop)
 
 		if p.conn != nil {
-			p.conn.Close()
-		}
-
-		if sc != nil {
-			close(sc)
+			if p.farewell { // sendLoop closes the connection
+				p.limiter.SetWriteDeadline(time.Now().Add(disconnectTimeout))
+			} else {
+				p.conn.Close()
+			}
 		}
 
 		if p.registered {
-			p.status <- peerStatus{peer: p, online: false}
+			select {
+			case p.status <- peerStatus{peer: p, online: false}:
+			case <-p.net.globalCloser:
+			}
 		}
 	})
 }
@@ -237,16 +376,21 @@ func (p *Peer) String() string {
 	return p.Hash
 }
 
+// accepts checks if the remote node can process parcels of a type. Custom
+// types have to be announced in the remote node's handshake
+func (p *Peer) accepts(t ParcelType) bool {
+	return t < TypeExtensionMin || p.parcelTypes[t]
+}
+
 func (p *Peer) Send(parcel *Parcel) {
 	_, dropped := p.send.Send(parcel)
-	p.dropped += uint64(dropped)
+	atomic.AddUint64(&p.dropped, uint64(dropped))
 }
 
 func (p *Peer) statLoop() {
-	ticker := time.NewTicker(time.Second * 5)
 	for {
 		select {
-		case <-ticker.C:
+		case <-p.net.clock.After(time.Second * 5):
 			p.metricsMtx.Lock()
 			mw, mr, bw, br := p.metrics.Collect()
 			p.bpsDown = float64(br) / 5
@@ -271,19 +415,18 @@ func (p *Peer) readLoop() {
 		p.net.prom.ReceiveRoutines.Inc()
 		defer p.net.prom.ReceiveRoutines.Dec()
 	}
-	defer p.conn.Close() // close connection on fatal error
 	for {
-		p.conn.SetReadDeadline(time.Now().Add(p.net.conf.ReadDeadline))
+		p.limiter.SetReadDeadline(time.Now().Add(p.readDeadline))
 		msg, err := p.prot.Receive()
 		if err != nil {
 			p.logger.WithError(err).Debug("connection error (readLoop)")
-			p.Stop()
+			p.stopWith(ReasonConnection)
 			return
 		}
 
-		if err := msg.Valid(); err != nil {
+		if err := p.net.parcelTypes.valid(msg); err != nil {
 			p.logger.WithError(err).Warnf("received invalid msg, disconnecting peer")
-			p.Stop()
+			p.stopWith(ReasonInvalid)
 			if p.net.prom != nil {
 				p.net.prom.Invalid.Inc()
 			}
@@ -292,15 +435,23 @@ func (p *Peer) readLoop() {
 
 		// metrics
 		p.metricsMtx.Lock()
-		p.lastReceive = time.Now()
+		p.lastReceive = p.net.clock.Now()
 		p.metricsMtx.Unlock()
 
 		// stats
 		if p.net.prom != nil {
-			p.net.prom.ParcelsReceived.Inc()
-			p.net.prom.ParcelSize.Observe(float64(len(msg.Payload)) / 1024)
-			if msg.IsApplicationMessage() {
-				p.net.prom.AppReceived.Inc()
+			p.net.prom.parcel(msg, p.net.parcelTypes.name(msg.Type), promReceived)
+		}
+
+		if msg.Type == TypeDisconnect {
+			p.processDisconnect(msg)
+			return
+		}
+
+		// ProtocolV9 reassembles legacy parts itself, only v10 chunks arrive here
+		if p.chunking && msg.Type == TypeMessagePart {
+			if msg = p.reassemble(msg); msg == nil {
+				continue
 			}
 		}
 
@@ -330,41 +481,118 @@ func (p *Peer) sendLoop() {
 	}
 
 	defer p.conn.Close() // close connection on fatal error
+
+	// chunks of large messages take turns with other parcels
+	var chunks chunkQueue
 	for {
-		select {
-		case <-p.stop:
-			return
-		case parcel := <-p.send:
-			if parcel == nil {
-				p.logger.Error("Received <nil> pointer from application")
-				continue
+		var parcel *Parcel
+		if chunks.empty() {
+			select {
+			case <-p.stop:
+				p.sendFarewell()
+				return
+			case parcel = <-p.send:
+				if parcel == nil {
+					p.logger.Error("Received <nil> pointer from application")
+					continue
+				}
 			}
-
-			p.conn.SetWriteDeadline(time.Now().Add(p.net.conf.WriteDeadline))
-			err := p.prot.Send(parcel)
-			if err != nil { // no error is recoverable
-				p.logger.WithError(err).Debug("connection error (sendLoop)")
-				p.Stop()
+		} else {
+			select {
+			case <-p.stop:
+				p.sendFarewell()
 				return
+			case parcel = <-p.send:
+			default:
 			}
+		}
 
-			// metrics
-			p.metricsMtx.Lock()
-			p.lastSend = time.Now()
-			p.metricsMtx.Unlock()
+		if parcel != nil {
+			if p.shouldChunk(parcel) {
+				p.chunkID++
+				chunks.add(splitMessage(p.chunkID, parcel.Type, parcel.Payload, int(p.net.conf.ChunkSize)))
+			} else if !p.sendParcel(parcel) {
+				return
+			}
+		}
 
-			// stats
+		if !chunks.empty() {
+			chunk := chunks.next()
+			if !p.sendParcel(chunk) {
+				return
+			}
 			if p.net.prom != nil {
-				p.net.prom.ParcelsSent.Inc()
-				p.net.prom.ParcelSize.Observe(float64(len(parcel.Payload)+32) / 1024) // TODO FIX
-				if parcel.IsApplicationMessage() {
-					p.net.prom.AppSent.Inc()
-				}
+				p.net.prom.Chunks.WithLabelValues(promSent).Inc()
 			}
 		}
 	}
 }
 
+// shouldChunk checks if a parcel is too large to be sent in one piece.
+// Legacy peers reassemble all parts as TypeMessage, so only messages are
+// split for them
+func (p *Peer) shouldChunk(parcel *Parcel) bool {
+	if !p.chunking || p.net.conf.ChunkSize == 0 || uint(len(parcel.Payload)) <= p.net.conf.ChunkSize {
+		return false
+	}
+	if p.prot.Version() == "9" {
+		return parcel.Type == TypeMessage
+	}
+	return chunkable(parcel.Type)
+}
+
+// sendParcel writes a single parcel to the connection. Returns false if the
+// connection failed and the peer was stopped
+func (p *Peer) sendParcel(parcel *Parcel) bool {
+	p.limiter.SetWriteDeadline(time.Now().Add(p.writeDeadline))
+	select {
+	case <-p.stop: // don't hold up the disconnect for long
+		p.limiter.SetWriteDeadline(time.Now().Add(disconnectTimeout))
+	default:
+	}
+	err := p.prot.Send(parcel)
+	if err != nil { // no error is recoverable
+		p.logger.WithError(err).Debug("connection error (sendLoop)")
+		p.stopWith(ReasonConnection)
+		return false
+	}
+
+	// metrics
+	p.metricsMtx.Lock()
+	p.lastSend = p.net.clock.Now()
+	p.metricsMtx.Unlock()
+
+	// stats
+	if p.net.prom != nil {
+		p.net.prom.parcel(parcel, p.net.parcelTypes.name(parcel.Type), promSent)
+	}
+	return true
+}
+
+// reassemble adds a chunk to its message and returns the message as a single
+// parcel of the original type once it is complete
+func (p *Peer) reassemble(chunk *Parcel) *Parcel {
+	if p.net.prom != nil {
+		p.net.prom.Chunks.WithLabelValues(promReceived).Inc()
+	}
+	id, index, total, typ, data, err := parseChunk(chunk.Payload)
+	if err == nil && !chunkable(typ) {
+		err = fmt.Errorf("chunk of message %d has type %s which can't be chunked", id, typ)
+	}
+	if err == nil {
+		var payload []byte
+		// the type is part of the key so all parts have to agree on it
+		payload, err = p.reassembly.add(fmt.Sprintf("%d/%d", id, typ), index, total, data)
+		if payload != nil {
+			return newParcel(typ, payload)
+		}
+	}
+	if err != nil {
+		p.logger.WithError(err).Warn("unable to reassemble message")
+	}
+	return nil
+}
+
 // GetMetrics returns live metrics for this connection
 func (p *Peer) GetMetrics() PeerMetrics {
 	p.metricsMtx.RLock()
@@ -373,6 +601,11 @@ func (p *Peer) GetMetrics() PeerMetrics {
 	if p.net.controller.isSpecial(p.Endpoint) {
 		pt = "special_config"
 	}
+	rtt, jitter := p.RTT()
+	var throttledUp, throttledDown time.Duration
+	if p.limiter != nil {
+		throttledUp, throttledDown = p.limiter.Throttled()
+	}
 	return PeerMetrics{
 		Hash:             p.Hash,
 		PeerAddress:      p.Endpoint.IP,
@@ -391,7 +624,52 @@ func (p *Peer) GetMetrics() PeerMetrics {
 		BPSUp:            p.bpsUp,
 		ConnectionState:  fmt.Sprintf("v%s", p.prot.Version()),
 		Capacity:         p.Capacity(),
-		Dropped:          p.dropped,
+		Dropped:          atomic.LoadUint64(&p.dropped),
+		RTT:              rtt,
+		RTTJitter:        jitter,
+		ThrottledUp:      throttledUp,
+		ThrottledDown:    throttledDown,
+		UserAgent:        p.UserAgent,
+		SoftwareVersion:  p.Version,
+		Capabilities:     p.Capabilities,
+		Status:           p.Status,
+
+		UncompressedBytesSent:     atomic.LoadUint64(&p.uncompressedSent),
+		CompressedBytesSent:       atomic.LoadUint64(&p.compressedSent),
+		UncompressedBytesReceived: atomic.LoadUint64(&p.uncompressedReceived),
+		CompressedBytesReceived:   atomic.LoadUint64(&p.compressedReceived),
+	}
+}
+
+func (p *Peer) setTopics(topics []string) {
+	m := make(map[string]bool, len(topics))
+	for _, t := range topics {
+		m[t] = true
+	}
+	p.topicMtx.Lock()
+	p.topics = m
+	p.topicMtx.Unlock()
+}
+
+// Subscribed checks if the remote node is subscribed to a topic
+func (p *Peer) Subscribed(topic string) bool {
+	p.topicMtx.RLock()
+	defer p.topicMtx.RUnlock()
+	return p.topics[topic]
+}
+
+// payloadStats records the size of a payload before and after compression
+func (p *Peer) payloadStats(direction string, uncompressed, compressed int) {
+	if direction == promSent {
+		atomic.AddUint64(&p.uncompressedSent, uint64(uncompressed))
+		atomic.AddUint64(&p.compressedSent, uint64(compressed))
+	} else {
+		atomic.AddUint64(&p.uncompressedReceived, uint64(uncompressed))
+		atomic.AddUint64(&p.compressedReceived, uint64(compressed))
+	}
+	if p.net.prom != nil {
+		p.net.prom.Compression.WithLabelValues(direction, "uncompressed").Add(float64(uncompressed))
+		p.net.prom.Compression.WithLabelValues(direction, "compressed").Add(float64(compressed))
 	}
 }
 
@@ -0,0 +1,101 @@
+package p2p
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// pingPayloadLength is the size of a ping payload: an 8 byte nonce followed by
+// the 8 byte timestamp of the sender in unix nanoseconds.
+// Older nodes send "Ping" and "Pong" as payload, which are not used for latency
+const pingPayloadLength = 16
+
+func makePingPayload(nonce uint64, t time.Time) []byte {
+	payload := make([]byte, pingPayloadLength)
+	binary.BigEndian.PutUint64(payload, nonce)
+	binary.BigEndian.PutUint64(payload[8:], uint64(t.UnixNano()))
+	return payload
+}
+
+func parsePingPayload(payload []byte) (uint64, time.Time, bool) {
+	if len(payload) != pingPayloadLength {
+		return 0, time.Time{}, false
+	}
+	nonce := binary.BigEndian.Uint64(payload)
+	ts := int64(binary.BigEndian.Uint64(payload[8:]))
+	return nonce, time.Unix(0, ts), true
+}
+
+// idle checks if no parcel was sent to or received from the peer within d
+func (p *Peer) idle(d time.Duration) bool {
+	p.metricsMtx.RLock()
+	defer p.metricsMtx.RUnlock()
+	return p.net.clock.Since(p.lastSend) > d && p.net.clock.Since(p.lastReceive) > d
+}
+
+// ping sends a new ping to the peer. Returns false if the peer has not answered
+// the last PingMissLimit pings
+func (p *Peer) ping() bool {
+	p.pingMtx.Lock()
+	if p.pingPending {
+		p.pingsMissed++
+	}
+	missed := p.pingsMissed
+	p.pingNonce++
+	p.pingPending = true
+	p.lastPing = p.net.clock.Now()
+	payload := makePingPayload(p.pingNonce, p.lastPing)
+	p.pingMtx.Unlock()
+
+	if limit := p.net.conf.PingMissLimit; limit > 0 && uint(missed) >= limit {
+		return false
+	}
+
+	p.Send(newParcel(TypePing, payload))
+	return true
+}
+
+// pong processes the reply to a ping. Any pong means the peer is alive but only
+// the reply to the most recent ping is used to measure the round trip time
+func (p *Peer) pong(payload []byte) {
+	p.pingMtx.Lock()
+	defer p.pingMtx.Unlock()
+
+	p.pingsMissed = 0
+	nonce, _, ok := parsePingPayload(payload)
+	if !ok || !p.pingPending || nonce != p.pingNonce {
+		return
+	}
+	p.pingPending = false
+
+	// the timestamp in the payload is informational, the remote could alter it
+	sample := p.net.clock.Since(p.lastPing)
+	if sample < 0 {
+		return
+	}
+
+	// smoothed round trip time and variance as used by TCP (RFC 6298)
+	if p.rtt == 0 {
+		p.rtt = sample
+		p.rttJitter = sample / 2
+	} else {
+		diff := p.rtt - sample
+		if diff < 0 {
+			diff = -diff
+		}
+		p.rttJitter += (diff - p.rttJitter) / 4
+		p.rtt += (sample - p.rtt) / 8
+	}
+
+	if p.net.prom != nil {
+		p.net.prom.PingRTT.Observe(sample.Seconds())
+	}
+}
+
+// RTT returns the smoothed round trip time and its jitter. Zero if no pong has been
+// received yet
+func (p *Peer) RTT() (time.Duration, time.Duration) {
+	p.pingMtx.Lock()
+	defer p.pingMtx.Unlock()
+	return p.rtt, p.rttJitter
+}
@@ -0,0 +1,116 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+)
+
+func testPingPeer(limit uint) (*Peer, *VirtualClock) {
+	clock := NewVirtualClock(time.Unix(1000, 0))
+	conf := DefaultP2PConfiguration()
+	conf.PingMissLimit = limit
+	n := &Network{conf: &conf, clock: clock}
+	p := &Peer{net: n, send: newParcelChannel(10)}
+	return p, clock
+}
+
+func Test_pingPayload(t *testing.T) {
+	now := time.Unix(1234, 5678)
+	nonce, ts, ok := parsePingPayload(makePingPayload(42, now))
+	if !ok || nonce != 42 || !ts.Equal(now) {
+		t.Errorf("parsePingPayload() = %d, %s, %v", nonce, ts, ok)
+	}
+	if _, _, ok := parsePingPayload([]byte("Pong")); ok {
+		t.Errorf("parsePingPayload() accepted legacy payload")
+	}
+}
+
+func TestPeer_pingRTT(t *testing.T) {
+	p, clock := testPingPeer(0)
+
+	samples := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 100 * time.Millisecond}
+	wantRTT := []time.Duration{100 * time.Millisecond, 112500 * time.Microsecond, 110937500 * time.Nanosecond}
+	wantJitter := []time.Duration{50 * time.Millisecond, 62500 * time.Microsecond, 50 * time.Millisecond}
+
+	for i, s := range samples {
+		if !p.ping() {
+			t.Fatal("ping() returned false without limit")
+		}
+		ping := <-p.send
+		clock.Advance(s)
+		p.pong(ping.Payload)
+
+		rtt, jitter := p.RTT()
+		if rtt != wantRTT[i] || jitter != wantJitter[i] {
+			t.Errorf("sample %d: RTT() = %s, %s, want %s, %s", i, rtt, jitter, wantRTT[i], wantJitter[i])
+		}
+	}
+
+	// pongs that don't answer the latest ping are ignored
+	p.ping()
+	old := <-p.send
+	p.ping()
+	<-p.send
+	clock.Advance(time.Second * 10)
+	p.pong(old.Payload)
+	p.pong([]byte("Pong"))
+	if rtt, _ := p.RTT(); rtt != wantRTT[2] {
+		t.Errorf("stale pong changed the rtt to %s", rtt)
+	}
+}
+
+func TestPeer_pingMissLimit(t *testing.T) {
+	p, _ := testPingPeer(2)
+
+	for i := 0; i < 2; i++ {
+		if !p.ping() {
+			t.Fatalf("ping %d returned false before reaching the limit", i)
+		}
+	}
+	if p.ping() {
+		t.Errorf("ping() returned true after missing 2 pongs")
+	}
+
+	// a legacy pong still counts as an answer
+	p.pong([]byte("Pong"))
+	if !p.ping() {
+		t.Errorf("ping() returned false after receiving a pong")
+	}
+}
+
+func Test_controller_runPing(t *testing.T) {
+	clock := NewVirtualClock(time.Unix(1000, 0))
+	conf := testMemoryConfig(NewMemoryTransport(), "10.0.0.1", "")
+	conf.Clock = clock
+	n, err := NewNetwork(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := n.controller
+
+	idle := &Peer{net: n, Hash: "idle", send: newParcelChannel(10)}
+	received := &Peer{net: n, Hash: "received", send: newParcelChannel(10)}
+	sent := &Peer{net: n, Hash: "sent", send: newParcelChannel(10)}
+	for _, p := range []*Peer{idle, received, sent} {
+		c.peers.Add(p)
+	}
+
+	clock.Advance(conf.PingInterval * 2)
+	received.lastReceive = clock.Now()
+	sent.lastSend = clock.Now()
+	c.runPing()
+
+	if len(idle.send) != 1 {
+		t.Errorf("idle peer was not pinged")
+	}
+	if len(received.send) != 0 || len(sent.send) != 0 {
+		t.Errorf("peers with recent traffic were pinged: received = %d, sent = %d", len(received.send), len(sent.send))
+	}
+
+	// once the traffic stops, the peers are pinged again
+	clock.Advance(conf.PingInterval + time.Second)
+	c.runPing()
+	if len(received.send) != 1 || len(sent.send) != 1 {
+		t.Errorf("quiet peers were not pinged: received = %d, sent = %d", len(received.send), len(sent.send))
+	}
+}
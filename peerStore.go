@@ -2,6 +2,7 @@ package p2p
 
 import (
 	"fmt"
+	"net"
 	"sync"
 )
 
@@ -84,6 +85,19 @@ func (ps *PeerStore) Total() int {
 	return len(ps.peers)
 }
 
+// Unique is the amount of distinct ip addresses connected
+func (ps *PeerStore) Unique() int {
+	ps.mtx.RLock()
+	defer ps.mtx.RUnlock()
+	unique := 0
+	for addr := range ps.connected {
+		if net.ParseIP(addr) != nil { // the map also contains ip:port
+			unique++
+		}
+	}
+	return unique
+}
+
 // Outgoing is the amount of outgoing peers connected
 func (ps *PeerStore) Outgoing() int {
 	ps.mtx.RLock()
@@ -131,8 +145,16 @@ func (ps *PeerStore) Count(addr string) int {
 // ordered
 func (ps *PeerStore) Slice() []*Peer {
 	ps.mtx.RLock()
-	defer ps.mtx.RUnlock()
+	if ps.curSlice != nil {
+		r := append(ps.curSlice[:0:0], ps.curSlice...)
+		ps.mtx.RUnlock()
+		return r
+	}
+	ps.mtx.RUnlock()
 
+	// building the cache modifies the store
+	ps.mtx.Lock()
+	defer ps.mtx.Unlock()
 	if ps.curSlice != nil {
 		return append(ps.curSlice[:0:0], ps.curSlice...)
 	}
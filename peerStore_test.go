@@ -137,6 +137,19 @@ func TestPeerStore_Incoming(t *testing.T) {
 	}
 }
 
+func TestPeerStore_Unique(t *testing.T) {
+	ps, peers := testAll()
+
+	if ps.Unique() != unique {
+		t.Errorf("Unique reported the wrong count. is %d should be %d", ps.Unique(), unique)
+	}
+
+	ps.Remove(peers[4])
+	if ps.Unique() != unique-1 {
+		t.Errorf("Unique reported the wrong count after removal. is %d should be %d", ps.Unique(), unique-1)
+	}
+}
+
 func TestPeerStore_Get(t *testing.T) {
 	ps, peers := testAll()
 
@@ -25,6 +25,20 @@ type PeerMetrics struct {
 	BPSUp            float64
 	Capacity         float64
 	Dropped          uint64
+	RTT              time.Duration // smoothed round trip time of pings
+	RTTJitter        time.Duration // variation of the round trip time
+	ThrottledUp      time.Duration // total time spent waiting for upload limits
+	ThrottledDown    time.Duration // total time spent waiting for download limits
+	UserAgent        string        // the software the peer runs, empty for old nodes
+	SoftwareVersion  string        // the version of the peer's software
+	Capabilities     Capability    // the optional features the peer supports
+	Status           []byte        // the application status the peer sent in the handshake
+
+	// payload bytes of v10 connections before and after compression
+	UncompressedBytesSent     uint64
+	CompressedBytesSent       uint64
+	UncompressedBytesReceived uint64
+	CompressedBytesReceived   uint64
 }
 
 // peerStatus is an indicator for peer manager whether the associated peer is going online or offline
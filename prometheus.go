@@ -1,23 +1,21 @@
 package p2p
 
 import (
-	"sync"
+	"fmt"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-var once sync.Once
-
 // Prometheus holds all of the prometheus recording instruments
 type Prometheus struct {
 	Networks    prometheus.Gauge
-	Connections prometheus.Gauge // done
+	Connections prometheus.Gauge
 	Unique      prometheus.Gauge
-	Connecting  prometheus.Gauge // done
-	Incoming    prometheus.Gauge // done
-	Outgoing    prometheus.Gauge // done
+	Connecting  prometheus.Gauge
+	Incoming    prometheus.Gauge
+	Outgoing    prometheus.Gauge
 
-	KnownPeers prometheus.Gauge // done
+	KnownPeers prometheus.Gauge
 
 	SendRoutines    prometheus.Gauge
 	ReceiveRoutines prometheus.Gauge
@@ -30,39 +28,168 @@ type Prometheus struct {
 	AppDuplicate    prometheus.Counter
 
 	ParcelSize prometheus.Histogram
+
+	Parcels     *prometheus.CounterVec // type, direction
+	ParcelBytes *prometheus.CounterVec // type, direction
+
+	Handshakes *prometheus.CounterVec // direction, result
+	Dials      *prometheus.CounterVec // result
+	Rejections *prometheus.CounterVec // direction
+	DialBacks  *prometheus.CounterVec // result
+
+	Disconnects *prometheus.CounterVec // reason, direction
+
+	CATRounds prometheus.Counter
+	CATDrops  prometheus.Counter
+
+	PeerShares         *prometheus.CounterVec // direction
+	PeerShareEndpoints *prometheus.CounterVec // direction
+
+	PingRTT prometheus.Histogram
+
+	Throttle    *prometheus.CounterVec // direction
+	Compression *prometheus.CounterVec // direction, stage
+
+	Chunks     *prometheus.CounterVec // direction
+	ChunkDrops *prometheus.CounterVec // reason
+
+	EventDrops prometheus.Counter
+
+	reg        prometheus.Registerer
+	registered []prometheus.Collector
 }
 
-// Setup registers all of the instruments with prometheus once
-func (p *Prometheus) Setup() {
-	once.Do(func() {
-		ng := func(name, help string) prometheus.Gauge {
-			g := prometheus.NewGauge(prometheus.GaugeOpts{
-				Name: name,
-				Help: help,
-			})
-			prometheus.MustRegister(g)
-			return g
+// Setup creates all of the instruments and registers them with the registerer.
+// The labels are added to every instrument, which allows multiple instances to
+// share one registerer.
+//
+// Instances sharing a registerer need distinct labels. If an identical
+// instrument is already registered, Setup fails and nothing stays registered
+func (p *Prometheus) Setup(reg prometheus.Registerer, labels prometheus.Labels) error {
+	var err error
+	p.reg = reg
+	register := func(c prometheus.Collector) prometheus.Collector {
+		if regErr := reg.Register(c); regErr != nil {
+			if err == nil {
+				if _, ok := regErr.(prometheus.AlreadyRegisteredError); ok {
+					err = fmt.Errorf("metrics are already registered, use distinct PrometheusLabels or a separate PrometheusRegisterer: %v", regErr)
+				} else {
+					err = fmt.Errorf("unable to register metrics: %v", regErr)
+				}
+			}
+		} else {
+			p.registered = append(p.registered, c)
 		}
+		return c
+	}
 
-		p.Connections = ng("factomd_p2p_peers_online", "Number of established connections")
-		p.Unique = ng("factomd_p2p_peers_unique", "Number of unique ip addresses connected")
-		p.Connecting = ng("factomd_p2p_peers_connecting", "Number of connections currently dialing or awaiting handshake")
-		p.Incoming = ng("factomd_p2p_peers_incoming", "Number of peers that have dialed to this node")
-		p.Outgoing = ng("factomd_p2p_peers_outgoing", "Number of peers that this node has dialed to")
-		p.KnownPeers = ng("factomd_p2p_peers_known", "Number of peers known to the system")
-		p.SendRoutines = ng("factomd_p2p_tech_sendroutines", "Number of active send routines")
-		p.ReceiveRoutines = ng("factomd_p2p_tech_receiveroutines", "Number of active receive routines")
-		p.ParcelsSent = ng("factomd_p2p_parcels_sent", "Total number of parcels sent out")
-		p.ParcelsReceived = ng("factomd_p2p_parcels_received", "Total number of parcels received")
-		p.Invalid = ng("factomd_p2p_parcels_invalid", "Total number of invalid parcels received")
-		p.AppSent = ng("factomd_p2p_messages_sent", "Total number of application messages sent")
-		p.AppReceived = ng("factomd_p2p_messages_received", "Total number of application messages received")
-		p.AppDuplicate = ng("factomd_p2p_messages_duplicate", "Total number of duplicate messages filtered out")
-		p.ParcelSize = prometheus.NewHistogram(prometheus.HistogramOpts{
-			Name:    "factomd_p2p_parcels_size",
-			Help:    "Number of parcels encountered for specific sizes (in KiBi)",
-			Buckets: prometheus.ExponentialBuckets(1, 2, 16),
+	ng := func(name, help string) prometheus.Gauge {
+		g := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        name,
+			Help:        help,
+			ConstLabels: labels,
+		})
+		return register(g).(prometheus.Gauge)
+	}
+	nc := func(name, help string) prometheus.Counter {
+		c := prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        name,
+			Help:        help,
+			ConstLabels: labels,
 		})
-		prometheus.MustRegister(p.ParcelSize)
-	})
+		return register(c).(prometheus.Counter)
+	}
+	ncv := func(name, help string, labelNames ...string) *prometheus.CounterVec {
+		cv := prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        name,
+			Help:        help,
+			ConstLabels: labels,
+		}, labelNames)
+		return register(cv).(*prometheus.CounterVec)
+	}
+
+	p.Networks = ng("factomd_p2p_networks", "Number of running p2p networks")
+	p.Connections = ng("factomd_p2p_peers_online", "Number of established connections")
+	p.Unique = ng("factomd_p2p_peers_unique", "Number of unique ip addresses connected")
+	p.Connecting = ng("factomd_p2p_peers_connecting", "Number of connections currently dialing or awaiting handshake")
+	p.Incoming = ng("factomd_p2p_peers_incoming", "Number of peers that have dialed to this node")
+	p.Outgoing = ng("factomd_p2p_peers_outgoing", "Number of peers that this node has dialed to")
+	p.KnownPeers = ng("factomd_p2p_peers_known", "Number of peers known to the system")
+	p.SendRoutines = ng("factomd_p2p_tech_sendroutines", "Number of active send routines")
+	p.ReceiveRoutines = ng("factomd_p2p_tech_receiveroutines", "Number of active receive routines")
+	p.ParcelsSent = nc("factomd_p2p_parcels_sent", "Total number of parcels sent out")
+	p.ParcelsReceived = nc("factomd_p2p_parcels_received", "Total number of parcels received")
+	p.Invalid = nc("factomd_p2p_parcels_invalid", "Total number of invalid parcels received")
+	p.AppSent = nc("factomd_p2p_messages_sent", "Total number of application messages sent")
+	p.AppReceived = nc("factomd_p2p_messages_received", "Total number of application messages received")
+	p.AppDuplicate = nc("factomd_p2p_messages_duplicate", "Total number of duplicate messages filtered out")
+	p.ParcelSize = register(prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:        "factomd_p2p_parcels_size",
+		Help:        "Number of parcels encountered for specific payload sizes (in KiBi)",
+		Buckets:     prometheus.ExponentialBuckets(1, 2, 16),
+		ConstLabels: labels,
+	})).(prometheus.Histogram)
+
+	p.Parcels = ncv("factomd_p2p_parcels_by_type", "Total number of parcels by parcel type and direction", "type", "direction")
+	p.ParcelBytes = ncv("factomd_p2p_parcels_payload_bytes", "Total payload bytes of parcels by parcel type and direction", "type", "direction")
+	p.Handshakes = ncv("factomd_p2p_handshakes", "Total number of handshakes by direction and result", "direction", "result")
+	p.Dials = ncv("factomd_p2p_dials", "Total number of dial attempts by result", "result")
+	p.Disconnects = ncv("factomd_p2p_disconnects", "Total number of disconnected peers by reason, decided locally (sent) or by the remote node (received)", "reason", "direction")
+	p.DialBacks = ncv("factomd_p2p_dial_backs", "Total number of incoming peers dialed back to verify their endpoint, by result", "result")
+	p.Rejections = ncv("factomd_p2p_rejections", "Total number of connections rejected with alternatives, sent or received", "direction")
+	p.CATRounds = nc("factomd_p2p_cat_rounds", "Total number of CAT rounds")
+	p.CATDrops = nc("factomd_p2p_cat_drops", "Total number of peers dropped in CAT rounds")
+	p.PeerShares = ncv("factomd_p2p_peershares", "Total number of peer shares sent and received", "direction")
+	p.PingRTT = register(prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:        "factomd_p2p_ping_rtt_seconds",
+		Help:        "Round trip time of pings",
+		Buckets:     prometheus.ExponentialBuckets(0.001, 2, 15),
+		ConstLabels: labels,
+	})).(prometheus.Histogram)
+	p.PeerShareEndpoints = ncv("factomd_p2p_peershares_endpoints", "Total number of endpoints in peer shares sent and received", "direction")
+	p.Compression = ncv("factomd_p2p_compression_payload_bytes", "Total payload bytes of v10 connections before (uncompressed) and after (compressed) compression", "direction", "stage")
+	p.Chunks = ncv("factomd_p2p_chunks", "Total number of message chunks sent and received", "direction")
+	p.ChunkDrops = ncv("factomd_p2p_chunk_drops", "Total number of incomplete chunked messages discarded by reason", "reason")
+	p.EventDrops = nc("factomd_p2p_event_drops", "Total number of events not delivered to subscribers that fell behind")
+	p.Throttle = ncv("factomd_p2p_throttle_seconds", "Total time connections waited for bandwidth limits, sending or receiving", "direction")
+
+	if err != nil {
+		p.Unregister()
+	}
+	return err
+}
+
+// Unregister removes all instruments registered by Setup from the registerer
+func (p *Prometheus) Unregister() {
+	for _, c := range p.registered {
+		p.reg.Unregister(c)
+	}
+	p.registered = nil
+}
+
+// direction labels
+const (
+	promSent     = "sent"
+	promReceived = "received"
+	promIncoming = "incoming"
+	promOutgoing = "outgoing"
+)
+
+// parcel records the traffic of a single parcel with the name of its type
+func (p *Prometheus) parcel(parcel *Parcel, name string, direction string) {
+	size := float64(len(parcel.Payload))
+	if direction == promSent {
+		p.ParcelsSent.Inc()
+		if parcel.IsApplicationMessage() {
+			p.AppSent.Inc()
+		}
+	} else {
+		p.ParcelsReceived.Inc()
+		if parcel.IsApplicationMessage() {
+			p.AppReceived.Inc()
+		}
+	}
+	p.ParcelSize.Observe(size / 1024)
+	p.Parcels.WithLabelValues(name, direction).Inc()
+	p.ParcelBytes.WithLabelValues(name, direction).Add(size)
 }
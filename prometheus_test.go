@@ -0,0 +1,212 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gaugeValues returns the values of a metric for each value of the "node" label
+func gaugeValues(t *testing.T, reg *prometheus.Registry, name string) map[string]float64 {
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			node := ""
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "node" {
+					node = l.GetValue()
+				}
+			}
+			values[node] = m.GetGauge().GetValue()
+		}
+	}
+	return values
+}
+
+func TestPrometheus_Setup(t *testing.T) {
+	shared := prometheus.NewRegistry()
+	a, b := new(Prometheus), new(Prometheus)
+	if err := a.Setup(shared, prometheus.Labels{"node": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Setup(shared, prometheus.Labels{"node": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	a.Connections.Set(3)
+	b.Connections.Set(5)
+
+	got := gaugeValues(t, shared, "factomd_p2p_peers_online")
+	if len(got) != 2 || got["a"] != 3 || got["b"] != 5 {
+		t.Errorf("labelled instances not independent: %v", got)
+	}
+
+	// identical instruments are refused instead of being shared
+	c := new(Prometheus)
+	if err := c.Setup(shared, prometheus.Labels{"node": "a"}); err == nil {
+		t.Errorf("registered instruments with identical labels twice")
+	}
+	a.Connections.Set(4)
+	if got := gaugeValues(t, shared, "factomd_p2p_peers_online"); got["a"] != 4 {
+		t.Errorf("failed setup changed the existing instance: %v", got)
+	}
+
+	// unregistered instruments can be registered again
+	a.Unregister()
+	if err := c.Setup(shared, prometheus.Labels{"node": "a"}); err != nil {
+		t.Errorf("unable to register after unregistering: %v", err)
+	}
+
+	// different label names can't be registered alongside each other
+	d := new(Prometheus)
+	if err := d.Setup(shared, prometheus.Labels{"instance": "d"}); err == nil {
+		t.Errorf("registered instruments with inconsistent labels")
+	}
+}
+
+func TestNetwork_PrometheusConflict(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	conf := testMemoryConfig(NewMemoryTransport(), "10.0.0.1", "")
+	conf.EnablePrometheus = true
+	conf.PrometheusRegisterer = reg
+
+	a, err := NewNetwork(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewNetwork(conf); err == nil {
+		t.Errorf("second network with identical metrics was created")
+	}
+
+	a.Stop()
+	b, err := NewNetwork(conf)
+	if err != nil {
+		t.Fatalf("unable to create network after the first one stopped: %v", err)
+	}
+	b.Stop()
+}
+
+func TestNetwork_PrometheusRegisterer(t *testing.T) {
+	mt := NewMemoryTransport()
+	regA, regB := prometheus.NewRegistry(), prometheus.NewRegistry()
+
+	confA := testMemoryConfig(mt, "10.0.0.1", "")
+	confA.EnablePrometheus = true
+	confA.PrometheusRegisterer = regA
+	confB := testMemoryConfig(mt, "10.0.0.2", "")
+	confB.EnablePrometheus = true
+	confB.PrometheusRegisterer = regB
+
+	a, err := NewNetwork(confA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewNetwork(confB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.prom.KnownPeers.Set(7)
+	b.prom.KnownPeers.Set(1)
+	if got := gaugeValues(t, regA, "factomd_p2p_peers_known"); got[""] != 7 {
+		t.Errorf("registry a = %v, want 7", got)
+	}
+	if got := gaugeValues(t, regB, "factomd_p2p_peers_known"); got[""] != 1 {
+		t.Errorf("registry b = %v, want 1", got)
+	}
+}
+
+// counterValue returns the value of a counter with the given labels
+func counterValue(t *testing.T, reg *prometheus.Registry, name string, labels map[string]string) float64 {
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if v, ok := labels[l.GetName()]; ok && v != l.GetValue() {
+					continue metrics
+				}
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestNetwork_PrometheusCounters(t *testing.T) {
+	mt := NewMemoryTransport()
+	regA, regB := prometheus.NewRegistry(), prometheus.NewRegistry()
+
+	confA := testMemoryConfig(mt, "10.0.0.1", "")
+	confA.EnablePrometheus = true
+	confA.PrometheusRegisterer = regA
+	confB := testMemoryConfig(mt, "10.0.0.2", "10.0.0.1:8108")
+	confB.EnablePrometheus = true
+	confB.PrometheusRegisterer = regB
+
+	a, err := NewNetwork(confA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewNetwork(confB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Run()
+	b.Run()
+	defer a.Stop()
+	defer b.Stop()
+
+	if !waitFor(time.Second*5, func() bool { return a.Total() == 1 && b.Total() == 1 }) {
+		t.Fatalf("nodes did not connect")
+	}
+
+	b.ToNetwork.Send(NewParcel(Broadcast, []byte("hello")))
+	select {
+	case <-a.FromNetwork:
+	case <-time.After(time.Second * 5):
+		t.Fatal("parcel did not arrive")
+	}
+
+	tests := []struct {
+		reg    *prometheus.Registry
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{regB, "factomd_p2p_dials", map[string]string{"result": "success"}, 1},
+		{regB, "factomd_p2p_handshakes", map[string]string{"direction": "outgoing", "result": "success"}, 1},
+		{regA, "factomd_p2p_handshakes", map[string]string{"direction": "incoming", "result": "success"}, 1},
+		{regB, "factomd_p2p_parcels_by_type", map[string]string{"type": "Message", "direction": "sent"}, 1},
+		{regA, "factomd_p2p_parcels_by_type", map[string]string{"type": "Message", "direction": "received"}, 1},
+		{regA, "factomd_p2p_parcels_payload_bytes", map[string]string{"type": "Message", "direction": "received"}, 5},
+		{regA, "factomd_p2p_messages_received", nil, 1},
+	}
+	for _, tt := range tests {
+		// counters of the sender are updated after the write completes
+		waitFor(time.Second, func() bool { return counterValue(t, tt.reg, tt.name, tt.labels) == tt.want })
+		if got := counterValue(t, tt.reg, tt.name, tt.labels); got != tt.want {
+			t.Errorf("%s%v = %f, want %f", tt.name, tt.labels, got, tt.want)
+		}
+	}
+
+	if got := gaugeValues(t, regA, "factomd_p2p_networks"); got[""] != 1 {
+		t.Errorf("networks = %v, want 1", got)
+	}
+	if got := gaugeValues(t, regA, "factomd_p2p_peers_unique"); got[""] != 1 {
+		t.Errorf("unique peers = %v, want 1", got)
+	}
+}
@@ -16,28 +16,48 @@ type ProtocolV10 struct {
 	decoder *gob.Decoder
 	encoder *gob.Encoder
 	peer    *Peer
+
+	// compress is true if both sides negotiated DEFLATE compression
+	compress bool
 }
 
 // V10Msg is the barebone message
 type V10Msg struct {
 	Type    ParcelType
-	Crc32   uint32
+	Crc32   uint32 // of the uncompressed payload
 	Payload []byte
+	// Compressed is only set if the connection negotiated compression
+	Compressed bool
 }
 
-func (v10 *ProtocolV10) init(peer *Peer, decoder *gob.Decoder, encoder *gob.Encoder) {
+func (v10 *ProtocolV10) init(peer *Peer, decoder *gob.Decoder, encoder *gob.Encoder, compress bool) {
 	v10.peer = peer
 	v10.net = peer.net
 	v10.decoder = decoder
 	v10.encoder = encoder
+	v10.compress = compress
 }
 
-// Send encodes a Parcel as V10Msg, calculates the crc and encodes it as gob
+// Send encodes a Parcel as V10Msg, calculates the crc and encodes it as gob.
+// Payloads above the threshold are compressed if the peer supports it
 func (v10 *ProtocolV10) Send(p *Parcel) error {
 	var msg V10Msg
 	msg.Type = p.Type
 	msg.Crc32 = crc32.Checksum(p.Payload, crcTable)
 	msg.Payload = p.Payload
+
+	if v10.compress && uint(len(p.Payload)) >= v10.net.conf.CompressionThreshold {
+		compressed, err := deflate(p.Payload)
+		if err != nil {
+			return err
+		}
+		if len(compressed) < len(p.Payload) {
+			msg.Payload = compressed
+			msg.Compressed = true
+		}
+	}
+
+	v10.peer.payloadStats(promSent, len(p.Payload), len(msg.Payload))
 	return v10.encoder.Encode(msg)
 }
 
@@ -58,12 +78,23 @@ func (v10 *ProtocolV10) Receive() (*Parcel, error) {
 		return nil, fmt.Errorf("nul payload")
 	}
 
-	csum := crc32.Checksum(msg.Payload, crcTable)
+	payload := msg.Payload
+	if msg.Compressed {
+		if !v10.compress {
+			return nil, fmt.Errorf("received compressed payload without negotiating compression")
+		}
+		if payload, err = inflate(msg.Payload, maxDecompressedSize); err != nil {
+			return nil, fmt.Errorf("unable to decompress payload: %v", err)
+		}
+	}
+	v10.peer.payloadStats(promReceived, len(payload), len(msg.Payload))
+
+	csum := crc32.Checksum(payload, crcTable)
 	if csum != msg.Crc32 {
 		return nil, fmt.Errorf("invalid checksum")
 	}
 
-	p := newParcel(msg.Type, msg.Payload)
+	p := newParcel(msg.Type, payload)
 	return p, nil
 }
 
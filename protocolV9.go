@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"hash/crc32"
+	"math"
 	"time"
 )
 
@@ -27,7 +28,8 @@ func (v9 *ProtocolV9) init(peer *Peer, decoder *gob.Decoder, encoder *gob.Encode
 	v9.encoder = encoder
 }
 
-// Send a parcel over the connection
+// Send a parcel over the connection. Chunks of a split message are sent as
+// legacy message parts
 func (v9 *ProtocolV9) Send(p *Parcel) error {
 	var msg V9Msg
 	msg.Header.Network = v9.net.conf.Network
@@ -42,29 +44,75 @@ func (v9 *ProtocolV9) Send(p *Parcel) error {
 	msg.Header.AppType = "Network"
 
 	msg.Payload = p.Payload
-	msg.Header.Crc32 = crc32.Checksum(p.Payload, crcTable)
-	msg.Header.Length = uint32(len(p.Payload))
+
+	if p.Type == TypeMessagePart {
+		id, index, total, _, data, err := parseChunk(p.Payload)
+		if err != nil {
+			return err
+		}
+		if total > math.MaxUint16 {
+			return fmt.Errorf("message has too many parts for protocol 9: %d", total)
+		}
+		// parts of a message are identified by their AppHash
+		msg.Header.AppHash = fmt.Sprintf("%016x%016x", v9.net.instanceID, id)
+		msg.Header.PartNo = uint16(index)
+		msg.Header.PartsTotal = uint16(total)
+		msg.Payload = data
+	}
+
+	msg.Header.Crc32 = crc32.Checksum(msg.Payload, crcTable)
+	msg.Header.Length = uint32(len(msg.Payload))
 
 	return v9.encoder.Encode(&msg)
 }
 
 // Receive a parcel from the network. Blocking.
+// Message parts are buffered until the whole message has arrived, which is
+// returned as a single TypeMessage parcel
 func (v9 *ProtocolV9) Receive() (*Parcel, error) {
-	var msg V9Msg
-	err := v9.decoder.Decode(&msg)
-	if err != nil {
-		return nil, err
+	for {
+		var msg V9Msg
+		err := v9.decoder.Decode(&msg)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = msg.Valid(); err != nil {
+			return nil, err
+		}
+
+		p := new(Parcel)
+		p.Address = msg.Header.TargetPeer
+		p.Payload = msg.Payload
+		p.Type = msg.Header.Type
+
+		if p.Type != TypeMessagePart {
+			return p, nil
+		}
+
+		if p.Payload = v9.reassemble(msg); p.Payload != nil {
+			p.Type = TypeMessage
+			return p, nil
+		}
+
+		// waiting for more parts
+		if v9.peer.limiter != nil {
+			v9.peer.limiter.SetReadDeadline(time.Now().Add(v9.net.conf.ReadDeadline))
+		}
 	}
+}
 
-	if err = msg.Valid(); err != nil {
-		return nil, err
+// reassemble adds a message part to the peer's buffer. Returns the whole payload
+// once all parts have arrived
+func (v9 *ProtocolV9) reassemble(msg V9Msg) []byte {
+	if v9.net.prom != nil {
+		v9.net.prom.Chunks.WithLabelValues(promReceived).Inc()
 	}
-
-	p := new(Parcel)
-	p.Address = msg.Header.TargetPeer
-	p.Payload = msg.Payload
-	p.Type = msg.Header.Type
-	return p, nil
+	payload, err := v9.peer.reassembly.add(msg.Header.AppHash, int(msg.Header.PartNo), int(msg.Header.PartsTotal), msg.Payload)
+	if err != nil {
+		v9.peer.logger.WithError(err).Warnf("unable to reassemble message %s", msg.Header.AppHash)
+	}
+	return payload
 }
 
 // Version of the protocol
@@ -0,0 +1,104 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/gob"
+	"hash/crc32"
+	"testing"
+	"time"
+)
+
+func testV9(buf *bytes.Buffer) *ProtocolV9 {
+	conf := DefaultP2PConfiguration()
+	n := &Network{conf: &conf, clock: NewVirtualClock(time.Unix(1000, 0)), instanceID: 0xabc}
+	p := &Peer{net: n, logger: peerLogger}
+	p.reassembly = newReassembler(n.clock, conf.ChunkTimeout, int(conf.ChunkMemory), nil)
+	v9 := new(ProtocolV9)
+	v9.init(p, gob.NewDecoder(buf), gob.NewEncoder(buf))
+	return v9
+}
+
+func legacyPart(hash string, no, total uint16, payload []byte) V9Msg {
+	var msg V9Msg
+	msg.Header.Version = 9
+	msg.Header.Type = TypeMessagePart
+	msg.Header.AppHash = hash
+	msg.Header.PartNo = no
+	msg.Header.PartsTotal = total
+	msg.Header.Length = uint32(len(payload))
+	msg.Header.Crc32 = crc32.Checksum(payload, crcTable)
+	msg.Payload = payload
+	return msg
+}
+
+func TestProtocolV9_sendParts(t *testing.T) {
+	buf := new(bytes.Buffer)
+	v9 := testV9(buf)
+
+	payload := bytes.Repeat([]byte("0123456789"), 25)
+	for _, chunk := range splitMessage(5, TypeMessage, payload, 100) {
+		if err := v9.Send(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dec := gob.NewDecoder(bytes.NewReader(buf.Bytes()))
+	var joined []byte
+	for i := 0; i < 3; i++ {
+		var msg V9Msg
+		if err := dec.Decode(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := msg.Valid(); err != nil {
+			t.Errorf("part %d is invalid: %v", i, err)
+		}
+		h := msg.Header
+		if h.Type != TypeMessagePart || h.PartNo != uint16(i) || h.PartsTotal != 3 || h.AppHash != "0000000000000abc0000000000000005" {
+			t.Errorf("part %d has unexpected header %+v", i, h)
+		}
+		joined = append(joined, msg.Payload...)
+	}
+	if !bytes.Equal(joined, payload) {
+		t.Errorf("parts do not add up to the payload")
+	}
+}
+
+func TestProtocolV9_receiveParts(t *testing.T) {
+	buf := new(bytes.Buffer)
+	v9 := testV9(buf)
+	enc := gob.NewEncoder(buf)
+
+	ping := legacyPart("", 0, 0, []byte("Ping"))
+	ping.Header.Type = TypePing
+
+	msgs := []V9Msg{
+		legacyPart("a", 1, 2, []byte("world")),
+		legacyPart("b", 0, 2, []byte("foo")),
+		ping,
+		legacyPart("a", 0, 2, []byte("hello ")),
+		legacyPart("b", 1, 2, []byte("bar")),
+	}
+	for _, m := range msgs {
+		if err := enc.Encode(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []struct {
+		typ     ParcelType
+		payload string
+	}{
+		{TypePing, "Ping"},
+		{TypeMessage, "hello world"},
+		{TypeMessage, "foobar"},
+	}
+	for _, w := range want {
+		p, err := v9.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Type != w.typ || string(p.Payload) != w.payload {
+			t.Errorf("Receive() = %s %q, want %s %q", p.Type, p.Payload, w.typ, w.payload)
+		}
+	}
+}
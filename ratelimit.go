@@ -0,0 +1,174 @@
+package p2p
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TokenBucket limits throughput to a rate of bytes per second. Up to one second's
+// worth of unused bandwidth can be accumulated for bursts.
+type TokenBucket struct {
+	mtx    sync.Mutex
+	clock  Clock
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a full token bucket that refills at rate bytes per second
+func NewTokenBucket(clock Clock, rate uint64) *TokenBucket {
+	tb := new(TokenBucket)
+	tb.clock = clock
+	tb.rate = float64(rate)
+	tb.tokens = tb.rate
+	tb.last = clock.Now()
+	return tb
+}
+
+// Reserve takes n bytes from the bucket and returns how long the caller has to
+// wait before they are available. Reservations larger than the bucket are allowed
+// and put the bucket into debt.
+func (tb *TokenBucket) Reserve(n int) time.Duration {
+	tb.mtx.Lock()
+	defer tb.mtx.Unlock()
+
+	now := tb.clock.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.rate {
+		tb.tokens = tb.rate
+	}
+	tb.last = now
+
+	tb.tokens -= float64(n)
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// limitedReadWriter throttles reads and writes to the limits of one or more
+// token buckets and keeps track of the time spent waiting.
+// Writes wait before the data is sent, reads wait after the data has arrived.
+//
+// Deadlines of the connection are set through the limiter, which postpones them
+// by the time spent waiting so that throttling does not time out the connection.
+type limitedReadWriter struct {
+	rw    io.ReadWriter
+	conn  net.Conn // optional, for deadlines
+	clock Clock
+	prom  *Prometheus
+	up    []*TokenBucket
+	down  []*TokenBucket
+
+	throttledUp   int64 // atomic, nanoseconds
+	throttledDown int64 // atomic, nanoseconds
+
+	deadlineMtx   sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// limitConnection wraps the connection to an endpoint in the network's global
+// bandwidth limits and new per-peer limits
+func (n *Network) limitConnection(conn net.Conn, ep Endpoint) *limitedReadWriter {
+	l := new(limitedReadWriter)
+	l.rw = conn
+	l.conn = conn
+	l.clock = n.clock
+	l.prom = n.prom
+
+	if n.conf.SpecialUnlimited && n.controller.isSpecialIP(ep.IP) {
+		return l
+	}
+
+	if n.uploadLimit != nil {
+		l.up = append(l.up, n.uploadLimit)
+	}
+	if n.conf.PeerUploadLimit > 0 {
+		l.up = append(l.up, NewTokenBucket(n.clock, n.conf.PeerUploadLimit))
+	}
+	if n.downloadLimit != nil {
+		l.down = append(l.down, n.downloadLimit)
+	}
+	if n.conf.PeerDownloadLimit > 0 {
+		l.down = append(l.down, NewTokenBucket(n.clock, n.conf.PeerDownloadLimit))
+	}
+	return l
+}
+
+// wait reserves n bytes in all buckets and sleeps for the longest delay
+func (l *limitedReadWriter) wait(buckets []*TokenBucket, n int, total *int64, direction string) time.Duration {
+	var delay time.Duration
+	for _, b := range buckets {
+		if d := b.Reserve(n); d > delay {
+			delay = d
+		}
+	}
+	if delay > 0 {
+		l.clock.Sleep(delay)
+		atomic.AddInt64(total, int64(delay))
+		if l.prom != nil {
+			l.prom.Throttle.WithLabelValues(direction).Add(delay.Seconds())
+		}
+	}
+	return delay
+}
+
+func (l *limitedReadWriter) Write(p []byte) (int, error) {
+	if delay := l.wait(l.up, len(p), &l.throttledUp, promSent); delay > 0 {
+		l.postpone(&l.writeDeadline, delay, false)
+	}
+	return l.rw.Write(p)
+}
+
+func (l *limitedReadWriter) Read(p []byte) (int, error) {
+	n, err := l.rw.Read(p)
+	if n > 0 {
+		if delay := l.wait(l.down, n, &l.throttledDown, promReceived); delay > 0 {
+			l.postpone(&l.readDeadline, delay, true)
+		}
+	}
+	return n, err
+}
+
+// SetReadDeadline sets the read deadline of the connection
+func (l *limitedReadWriter) SetReadDeadline(t time.Time) error {
+	l.deadlineMtx.Lock()
+	defer l.deadlineMtx.Unlock()
+	l.readDeadline = t
+	return l.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the connection
+func (l *limitedReadWriter) SetWriteDeadline(t time.Time) error {
+	l.deadlineMtx.Lock()
+	defer l.deadlineMtx.Unlock()
+	l.writeDeadline = t
+	return l.conn.SetWriteDeadline(t)
+}
+
+// postpone moves a deadline back by the time spent throttled
+func (l *limitedReadWriter) postpone(deadline *time.Time, delay time.Duration, read bool) {
+	if l.conn == nil {
+		return
+	}
+	l.deadlineMtx.Lock()
+	defer l.deadlineMtx.Unlock()
+	if deadline.IsZero() {
+		return
+	}
+	*deadline = deadline.Add(delay)
+	if read {
+		l.conn.SetReadDeadline(*deadline)
+	} else {
+		l.conn.SetWriteDeadline(*deadline)
+	}
+}
+
+// Throttled returns the total time spent waiting for writes and reads
+func (l *limitedReadWriter) Throttled() (time.Duration, time.Duration) {
+	return time.Duration(atomic.LoadInt64(&l.throttledUp)), time.Duration(atomic.LoadInt64(&l.throttledDown))
+}
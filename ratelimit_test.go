@@ -0,0 +1,129 @@
+package p2p
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// advancingClock is a virtual clock that advances time instead of blocking on Sleep
+type advancingClock struct {
+	*VirtualClock
+}
+
+func (ac advancingClock) Sleep(d time.Duration) { ac.Advance(d) }
+
+func TestTokenBucket_Reserve(t *testing.T) {
+	vc := NewVirtualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	tb := NewTokenBucket(vc, 1000)
+
+	tests := []struct {
+		advance time.Duration
+		n       int
+		want    time.Duration
+	}{
+		{0, 1000, 0},                     // full burst
+		{0, 500, time.Millisecond * 500}, // debt of 500
+		{time.Millisecond * 500, 0, 0},   // debt paid off
+		{time.Millisecond * 250, 500, time.Millisecond * 250},
+		{time.Hour, 3000, time.Second * 2}, // bucket caps at one second
+	}
+	for i, tt := range tests {
+		vc.Advance(tt.advance)
+		if got := tb.Reserve(tt.n); got != tt.want {
+			t.Errorf("#%d Reserve(%d) = %s, want %s", i, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestLimitedReadWriter(t *testing.T) {
+	clock := advancingClock{NewVirtualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))}
+	global := NewTokenBucket(clock, 1000)
+	peer := NewTokenBucket(clock, 500)
+
+	buf := new(bytes.Buffer)
+	l := &limitedReadWriter{rw: buf, clock: clock, up: []*TokenBucket{global, peer}, down: []*TokenBucket{global}}
+
+	start := clock.Now()
+	for i := 0; i < 4; i++ {
+		if _, err := l.Write(make([]byte, 500)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 500 bytes burst from the peer bucket, then 1500 bytes at 500 B/s
+	if elapsed := clock.Since(start); elapsed != time.Second*3 {
+		t.Errorf("writing took %s, want 3s", elapsed)
+	}
+
+	// the global bucket refilled while waiting for the peer bucket
+	read := make([]byte, 2000)
+	if n, err := l.Read(read); err != nil || n != 2000 {
+		t.Fatalf("read returned %d, %v", n, err)
+	}
+
+	up, down := l.Throttled()
+	if up != time.Second*3 {
+		t.Errorf("throttled up = %s, want 3s", up)
+	}
+	if down != time.Second {
+		t.Errorf("throttled down = %s, want 1s", down)
+	}
+}
+
+// tcpPair returns both ends of a local tcp connection
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := <-accepted
+	if remote == nil {
+		t.Fatal("unable to accept connection")
+	}
+	return conn, remote
+}
+
+func TestLimitedReadWriter_Deadline(t *testing.T) {
+	a, b := tcpPair(t)
+	defer a.Close()
+	defer b.Close()
+
+	go io.Copy(ioutil.Discard, b)
+
+	// writing 2250 bytes at 1000 B/s takes longer than the deadline
+	up := &limitedReadWriter{rw: a, conn: a, clock: SystemClock{}, up: []*TokenBucket{NewTokenBucket(SystemClock{}, 1000)}}
+	up.SetWriteDeadline(time.Now().Add(time.Millisecond * 300))
+	for i := 0; i < 3; i++ {
+		if _, err := up.Write(make([]byte, 750)); err != nil {
+			t.Fatalf("write #%d: %v", i, err)
+		}
+	}
+
+	if _, err := b.Write(make([]byte, 2250)); err != nil {
+		t.Fatal(err)
+	}
+
+	down := &limitedReadWriter{rw: a, conn: a, clock: SystemClock{}, down: []*TokenBucket{NewTokenBucket(SystemClock{}, 1000)}}
+	down.SetReadDeadline(time.Now().Add(time.Millisecond * 300))
+	buf := make([]byte, 750)
+	for i := 0; i < 3; i++ {
+		if _, err := io.ReadFull(down, buf); err != nil {
+			t.Fatalf("read #%d: %v", i, err)
+		}
+	}
+}
@@ -2,12 +2,15 @@ package p2p
 
 import (
 	"net"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
 type seed struct {
+	mtx       sync.Mutex
+	clock     Clock
 	url       string
 	cache     []Endpoint
 	cacheTTL  time.Duration
@@ -16,17 +19,21 @@ type seed struct {
 	logger *log.Entry
 }
 
-func newSeed(url string, cacheTTL time.Duration) *seed {
+func newSeed(url string, cacheTTL time.Duration, clock Clock) *seed {
 	s := new(seed)
+	s.clock = clock
 	s.url = url
 	s.logger = packageLogger.WithFields(log.Fields{"subpackage": "Seed", "url": url})
 	s.cacheTTL = cacheTTL
 	return s
 }
 
+// retrieve returns a copy of the seed's endpoints, downloading them if the cache expired
 func (s *seed) retrieve() []Endpoint {
-	if s.cache != nil && time.Since(s.cacheTime) <= s.cacheTTL {
-		return s.cache
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.cache != nil && s.clock.Since(s.cacheTime) <= s.cacheTTL {
+		return append([]Endpoint(nil), s.cache...)
 	}
 
 	eps := make([]Endpoint, 0)
@@ -47,12 +54,19 @@ func (s *seed) retrieve() []Endpoint {
 		s.logger.WithError(err).Errorf("unable to retrieve data from seed")
 	}
 
-	s.cacheTime = time.Now()
+	s.cacheTime = s.clock.Now()
 	s.cache = eps
-	return eps
+	return append([]Endpoint(nil), eps...)
 }
 
 func (s *seed) size() int {
-	s.retrieve()
-	return len(s.cache)
+	return len(s.retrieve())
+}
+
+// cached returns the endpoints in the cache and the time they were retrieved
+// without contacting the seed
+func (s *seed) cached() ([]Endpoint, time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]Endpoint(nil), s.cache...), s.cacheTime
 }
@@ -0,0 +1,201 @@
+package p2p
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+)
+
+// PeerSelector decides which peers receive a parcel that is not addressed to a
+// specific peer. It is used to pick the Fanout peers of a Broadcast and the single
+// peer of a RandomPeer parcel. Special peers always receive broadcasts and are
+// not passed to the selector in that case.
+//
+// Select returns up to count peers. The slice of peers may be reordered.
+type PeerSelector interface {
+	Select(rng *rand.Rand, peers []*Peer, count uint) []*Peer
+}
+
+var _ PeerSelector = UniformSelector{}
+var _ PeerSelector = LatencySelector{}
+var _ PeerSelector = CongestionSelector{}
+var _ PeerSelector = SubnetSelector{}
+
+// ParsePeerSelector returns the selector with the given name: uniform, latency,
+// congestion, or subnet
+func ParsePeerSelector(name string) (PeerSelector, error) {
+	switch strings.ToLower(name) {
+	case "", "uniform":
+		return UniformSelector{}, nil
+	case "latency":
+		return LatencySelector{}, nil
+	case "congestion":
+		return CongestionSelector{}, nil
+	case "subnet":
+		return SubnetSelector{}, nil
+	}
+	return nil, fmt.Errorf("unknown peer selector %q", name)
+}
+
+// UniformSelector picks peers uniformly at random
+type UniformSelector struct{}
+
+// Select shuffles the peers and returns the first count
+func (UniformSelector) Select(rng *rand.Rand, peers []*Peer, count uint) []*Peer {
+	if uint(len(peers)) <= count {
+		return peers
+	}
+	rng.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+	return peers[:count]
+}
+
+// LatencySelector picks peers at random, weighted by the inverse of their round
+// trip time. Peers without a measurement are weighted like an average peer
+type LatencySelector struct{}
+
+// Select performs a weighted random selection without replacement
+func (LatencySelector) Select(rng *rand.Rand, peers []*Peer, count uint) []*Peer {
+	if uint(len(peers)) <= count {
+		return peers
+	}
+
+	weights := make([]float64, len(peers))
+	sum, known := 0.0, 0
+	for i, p := range peers {
+		if rtt, _ := p.RTT(); rtt > 0 {
+			weights[i] = 1 / rtt.Seconds()
+			sum += weights[i]
+			known++
+		}
+	}
+	if known == 0 {
+		return UniformSelector{}.Select(rng, peers, count)
+	}
+	for i := range weights {
+		if weights[i] == 0 {
+			weights[i] = sum / float64(known)
+		}
+	}
+
+	return weightedSelect(rng, peers, weights, count)
+}
+
+// weightedSelect picks count peers using the algorithm of Efraimidis and Spirakis:
+// each peer gets the key u^(1/w) for a random u and the peers with the highest
+// keys are selected
+func weightedSelect(rng *rand.Rand, peers []*Peer, weights []float64, count uint) []*Peer {
+	type keyed struct {
+		peer *Peer
+		key  float64
+	}
+	keys := make([]keyed, len(peers))
+	for i, p := range peers {
+		keys[i] = keyed{peer: p, key: math.Pow(rng.Float64(), 1/weights[i])}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].key > keys[j].key })
+
+	selection := make([]*Peer, count)
+	for i := range selection {
+		selection[i] = keys[i].peer
+	}
+	return selection
+}
+
+// CongestionSelector skips peers whose send channel is filled above the threshold
+// and picks from the remaining peers with the fallback selector. If there are not
+// enough uncongested peers, the least congested ones are added.
+type CongestionSelector struct {
+	// Threshold is the send channel capacity [0.0,1.0] above which a peer is
+	// considered congested. Defaults to 0.5
+	Threshold float64
+	// Fallback picks among the uncongested peers. Defaults to UniformSelector
+	Fallback PeerSelector
+}
+
+// Select picks uncongested peers
+func (cs CongestionSelector) Select(rng *rand.Rand, peers []*Peer, count uint) []*Peer {
+	threshold := cs.Threshold
+	if threshold <= 0 {
+		threshold = 0.5
+	}
+	fallback := cs.Fallback
+	if fallback == nil {
+		fallback = UniformSelector{}
+	}
+
+	var free, congested []*Peer
+	for _, p := range peers {
+		if p.Capacity() > threshold {
+			congested = append(congested, p)
+		} else {
+			free = append(free, p)
+		}
+	}
+
+	selection := fallback.Select(rng, free, count)
+	if uint(len(selection)) < count && len(congested) > 0 {
+		sort.Slice(congested, func(i, j int) bool { return congested[i].Capacity() < congested[j].Capacity() })
+		missing := int(count) - len(selection)
+		if missing > len(congested) {
+			missing = len(congested)
+		}
+		selection = append(selection, congested[:missing]...)
+	}
+	return selection
+}
+
+// SubnetSelector spreads the selection over as many subnets as possible, picking
+// at most one peer per subnet before picking a second one from any subnet.
+// Subnets are /16 for IPv4 and /32 for IPv6.
+type SubnetSelector struct{}
+
+// Select picks peers round robin from randomly ordered subnets
+func (SubnetSelector) Select(rng *rand.Rand, peers []*Peer, count uint) []*Peer {
+	if uint(len(peers)) <= count {
+		return peers
+	}
+
+	rng.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+
+	var order []string
+	groups := make(map[string][]*Peer)
+	for _, p := range peers {
+		s := subnet(p.Endpoint.IP)
+		if _, ok := groups[s]; !ok {
+			order = append(order, s)
+		}
+		groups[s] = append(groups[s], p)
+	}
+
+	selection := make([]*Peer, 0, count)
+	for round := 0; uint(len(selection)) < count; round++ {
+		for _, s := range order {
+			if round < len(groups[s]) {
+				selection = append(selection, groups[s][round])
+				if uint(len(selection)) >= count {
+					break
+				}
+			}
+		}
+	}
+	return selection
+}
+
+// subnet returns the /16 of an IPv4 or the /32 of an IPv6 address
+func subnet(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return addr
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(16, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(32, 128)).String()
+}
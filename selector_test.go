@@ -0,0 +1,141 @@
+package p2p
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func testSelectorPeers(ips ...string) []*Peer {
+	var peers []*Peer
+	for i, ip := range ips {
+		p := testPeer(ip, "8108", uint32(i), false)
+		p.send = newParcelChannel(10)
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+func TestParsePeerSelector(t *testing.T) {
+	for _, name := range []string{"", "uniform", "Latency", "congestion", "subnet"} {
+		if _, err := ParsePeerSelector(name); err != nil {
+			t.Errorf("ParsePeerSelector(%q) = %v", name, err)
+		}
+	}
+	if _, err := ParsePeerSelector("fastest"); err == nil {
+		t.Errorf("ParsePeerSelector() accepted unknown name")
+	}
+}
+
+func TestPeerSelectors_Count(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	selectors := []PeerSelector{UniformSelector{}, LatencySelector{}, CongestionSelector{}, SubnetSelector{}}
+	for _, s := range selectors {
+		for _, n := range []int{0, 1, 5, 20} {
+			var ips []string
+			for i := 0; i < n; i++ {
+				ips = append(ips, fmt.Sprintf("10.%d.0.1", i))
+			}
+			peers := testSelectorPeers(ips...)
+			sel := s.Select(rng, peers, 8)
+
+			want := n
+			if want > 8 {
+				want = 8
+			}
+			if len(sel) != want {
+				t.Errorf("%T with %d peers selected %d, want %d", s, n, len(sel), want)
+			}
+			seen := make(map[*Peer]bool)
+			for _, p := range sel {
+				if seen[p] {
+					t.Errorf("%T selected %s twice", s, p)
+				}
+				seen[p] = true
+			}
+		}
+	}
+}
+
+func TestLatencySelector(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	peers := testSelectorPeers("10.0.0.1", "10.0.0.2")
+	peers[0].rtt = time.Millisecond
+	peers[1].rtt = time.Millisecond * 100
+	fast := peers[0]
+
+	hits := 0
+	for i := 0; i < 1000; i++ {
+		if (LatencySelector{}).Select(rng, peers, 1)[0] == fast {
+			hits++
+		}
+	}
+	// weights are 1000:10, so the fast peer should be picked ~99% of the time
+	if hits < 950 {
+		t.Errorf("fast peer was selected %d of 1000 times", hits)
+	}
+}
+
+func TestCongestionSelector(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	peers := testSelectorPeers("10.0.0.1", "10.0.0.2", "10.0.0.3")
+	for i := 0; i < 9; i++ {
+		peers[0].send <- newParcel(TypeMessage, []byte("x"))
+	}
+	for i := 0; i < 6; i++ {
+		peers[1].send <- newParcel(TypeMessage, []byte("x"))
+	}
+	free, busy, full := peers[2], peers[1], peers[0]
+
+	for i := 0; i < 10; i++ {
+		sel := CongestionSelector{}.Select(rng, append([]*Peer(nil), peers...), 1)
+		if sel[0] != free {
+			t.Fatalf("selected congested peer %s", sel[0])
+		}
+	}
+
+	// not enough free peers, fill with the least congested
+	sel := CongestionSelector{}.Select(rng, append([]*Peer(nil), peers...), 2)
+	if len(sel) != 2 || sel[0] != free || sel[1] != busy {
+		t.Errorf("unexpected selection %v", sel)
+	}
+	for _, p := range sel {
+		if p == full {
+			t.Errorf("selected the most congested peer")
+		}
+	}
+}
+
+func TestSubnetSelector(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	peers := testSelectorPeers("10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.1.1", "10.1.0.1", "10.2.0.1", "2001:db8::1")
+
+	for i := 0; i < 10; i++ {
+		sel := SubnetSelector{}.Select(rng, append([]*Peer(nil), peers...), 4)
+		subnets := make(map[string]bool)
+		for _, p := range sel {
+			subnets[subnet(p.Endpoint.IP)] = true
+		}
+		if len(subnets) != 4 {
+			t.Fatalf("selection %v only covers %d subnets", sel, len(subnets))
+		}
+	}
+}
+
+func Test_subnet(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"10.0.5.1", "10.0.0.0"},
+		{"192.168.1.1", "192.168.0.0"},
+		{"2001:db8:1:2::1", "2001:db8::"},
+		{"not an ip", "not an ip"},
+	}
+	for _, tt := range tests {
+		if got := subnet(tt.ip); got != tt.want {
+			t.Errorf("subnet(%s) = %s, want %s", tt.ip, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package simulator
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	p2p "github.com/whosoup/factom-p2p"
+)
+
+// Config defines the parameters of a simulation
+type Config struct {
+	// Nodes is the number of nodes in the simulation
+	Nodes int
+	// Seeds is the number of nodes listed in the seed file, starting with the first node
+	Seeds int
+	// Step is the amount of virtual time that passes with every step of the simulation
+	Step time.Duration
+	// Settle is the amount of real time that nodes have to react to each step
+	Settle time.Duration
+	// RandomSeed is the seed of the first node. Every subsequent node increments it by one
+	RandomSeed int64
+	// Template is the configuration that every node is based on. Network, NodeName, BindIP,
+	// SeedURL, Transport, Clock, and RandomSeed are overwritten for each node.
+	// If prometheus is enabled, every node's metrics get a "node" label with its NodeName
+	Template p2p.Configuration
+}
+
+// DefaultConfig returns a simulation of 100 nodes using the default p2p configuration
+func DefaultConfig() Config {
+	c := Config{}
+	c.Nodes = 100
+	c.Seeds = 10
+	c.Step = time.Second
+	c.Settle = time.Millisecond * 5
+	c.RandomSeed = 1
+	c.Template = p2p.DefaultP2PConfiguration()
+	c.Template.EnablePrometheus = false
+	c.Template.PersistFile = ""
+	return c
+}
+
+// Node is a single instance of the simulation
+type Node struct {
+	IP       string
+	Endpoint p2p.Endpoint
+	Network  *p2p.Network
+
+	// TimeToTarget is the virtual time it took the node to reach the target amount of
+	// connections. Only valid if ReachedTarget is true
+	TimeToTarget  time.Duration
+	ReachedTarget bool
+}
+
+// Simulation runs a number of p2p nodes connected via a MemoryTransport and
+// driven by a VirtualClock. Every step advances the clock and gives the nodes
+// time to react.
+//
+// The random number generators of all nodes are seeded, though the scheduling of
+// goroutines can still cause variation between runs
+type Simulation struct {
+	conf      Config
+	clock     *p2p.VirtualClock
+	transport *p2p.MemoryTransport
+	seed      *httptest.Server
+	elapsed   time.Duration
+
+	Nodes []*Node
+	index map[string]int // ip -> node index
+}
+
+// nodeIP assigns a distinct address to each node
+func nodeIP(i int) string {
+	return fmt.Sprintf("10.%d.%d.%d", i/62500, (i/250)%250, i%250+1)
+}
+
+// New creates a new simulation but does not start it
+func New(conf Config) (*Simulation, error) {
+	if conf.Nodes < 1 {
+		return nil, fmt.Errorf("simulation needs at least one node")
+	}
+	if conf.Seeds < 1 || conf.Seeds > conf.Nodes {
+		return nil, fmt.Errorf("number of seeds must be between 1 and %d", conf.Nodes)
+	}
+	if conf.Step <= 0 {
+		return nil, fmt.Errorf("step must be positive")
+	}
+
+	s := new(Simulation)
+	s.conf = conf
+	s.clock = p2p.NewVirtualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	s.transport = p2p.NewMemoryTransport()
+	s.index = make(map[string]int)
+
+	var seeds []string
+	for i := 0; i < conf.Nodes; i++ {
+		ip := nodeIP(i)
+		ep, err := p2p.NewEndpoint(ip, conf.Template.ListenPort)
+		if err != nil {
+			return nil, err
+		}
+		s.Nodes = append(s.Nodes, &Node{IP: ip, Endpoint: ep})
+		s.index[ip] = i
+		if i < conf.Seeds {
+			seeds = append(seeds, ep.String())
+		}
+	}
+
+	seedFile := strings.Join(seeds, "\n")
+	s.seed = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(seedFile))
+	}))
+
+	for i, node := range s.Nodes {
+		nc := conf.Template
+		nc.Network = p2p.LocalNet
+		nc.NodeID = 0
+		nc.NodeName = fmt.Sprintf("SimNode%d", i)
+		nc.BindIP = node.IP
+		nc.SeedURL = s.seed.URL
+		nc.Transport = s.transport
+		nc.Clock = s.clock
+		nc.RandomSeed = conf.RandomSeed + int64(i)
+		if nc.EnablePrometheus {
+			nc.PrometheusLabels = prometheus.Labels{"node": nc.NodeName}
+			for k, v := range conf.Template.PrometheusLabels {
+				nc.PrometheusLabels[k] = v
+			}
+		}
+
+		n, err := p2p.NewNetwork(nc)
+		if err != nil {
+			s.seed.Close()
+			return nil, fmt.Errorf("unable to create node %d: %v", i, err)
+		}
+		node.Network = n
+	}
+
+	return s, nil
+}
+
+// Start runs all nodes
+func (s *Simulation) Start() {
+	for _, n := range s.Nodes {
+		n.Network.Run()
+	}
+}
+
+// Stop shuts down all nodes and the seed server
+func (s *Simulation) Stop() {
+	for _, n := range s.Nodes {
+		n.Network.Stop()
+	}
+	s.seed.Close()
+}
+
+// Run advances the simulation by the given amount of virtual time
+func (s *Simulation) Run(d time.Duration) {
+	for end := s.elapsed + d; s.elapsed < end; {
+		s.step()
+	}
+}
+
+// RunUntil advances the simulation until either the condition is met or the
+// maximum amount of virtual time has passed. Returns true if the condition was met
+func (s *Simulation) RunUntil(max time.Duration, condition func(*Simulation) bool) bool {
+	for end := s.elapsed + max; s.elapsed < end; {
+		if condition(s) {
+			return true
+		}
+		s.step()
+	}
+	return condition(s)
+}
+
+func (s *Simulation) step() {
+	s.clock.Advance(s.conf.Step)
+	s.elapsed += s.conf.Step
+	time.Sleep(s.conf.Settle)
+
+	for _, n := range s.Nodes {
+		if !n.ReachedTarget && uint(n.Network.Total()) >= s.conf.Template.Target {
+			n.ReachedTarget = true
+			n.TimeToTarget = s.elapsed
+		}
+	}
+}
+
+// Elapsed is the amount of virtual time since the start of the simulation
+func (s *Simulation) Elapsed() time.Duration {
+	return s.elapsed
+}
+
+// Clock returns the virtual clock driving all nodes
+func (s *Simulation) Clock() *p2p.VirtualClock {
+	return s.clock
+}
@@ -0,0 +1,99 @@
+package simulator
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func testConfig(nodes int) Config {
+	conf := DefaultConfig()
+	conf.Nodes = nodes
+	conf.Seeds = 3
+	conf.Settle = time.Millisecond * 2
+	conf.Template.Target = 6
+	conf.Template.Max = 8
+	conf.Template.Incoming = 8
+	conf.Template.Drop = 5
+	conf.Template.MinReseed = 3
+	conf.Template.RoundTime = time.Minute * 2
+	conf.Template.PeerReseedInterval = time.Minute * 5 // lets separate components find each other
+	return conf
+}
+
+func TestSimulation(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+	defer log.SetLevel(log.InfoLevel)
+
+	sim, err := New(testConfig(40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.Start()
+	defer sim.Stop()
+
+	reached := sim.RunUntil(time.Minute*30, func(s *Simulation) bool {
+		for _, n := range s.Nodes {
+			if !n.ReachedTarget {
+				return false
+			}
+		}
+		return s.Report().Connected
+	})
+
+	r := sim.Report()
+	t.Logf("\n%s", r)
+
+	if !reached {
+		t.Errorf("only %d of %d nodes reached the target", r.ReachedTarget, r.Nodes)
+	}
+	if !r.Connected {
+		t.Errorf("network is not connected: %d components", r.Components)
+	}
+
+	// the node's loop may fall behind the clock by a few steps, so keep
+	// advancing until the round is processed
+	before := sim.Nodes[0].Network.Rounds()
+	if !sim.RunUntil(sim.conf.Template.RoundTime*2, func(s *Simulation) bool {
+		return s.Nodes[0].Network.Rounds() > before
+	}) {
+		t.Errorf("no cat round happened within twice the round time")
+	}
+}
+
+func TestNew(t *testing.T) {
+	bad := testConfig(5)
+	bad.Seeds = 6
+	if _, err := New(bad); err == nil {
+		t.Errorf("able to create simulation with more seeds than nodes")
+	}
+
+	bad = testConfig(0)
+	if _, err := New(bad); err == nil {
+		t.Errorf("able to create simulation without nodes")
+	}
+}
+
+func Test_nodeIP(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		ip := nodeIP(i)
+		if seen[ip] {
+			t.Fatalf("duplicate ip %s for node %d", ip, i)
+		}
+		seen[ip] = true
+	}
+}
+
+func Test_bfs(t *testing.T) {
+	// 0 - 1 - 2   3
+	graph := [][]int{{1}, {0, 2}, {1}, {}}
+	dist := bfs(graph, 0)
+	want := []int{0, 1, 2, -1}
+	for i := range want {
+		if dist[i] != want[i] {
+			t.Errorf("distance to %d = %d, want %d", i, dist[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package simulator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Report holds the topology metrics of the simulated network at a specific point in time
+type Report struct {
+	Elapsed time.Duration // virtual time since the start of the simulation
+	Nodes   int
+	Edges   int // number of distinct node pairs that are connected
+
+	Components       int  // number of connected components
+	LargestComponent int  // number of nodes in the largest component
+	Connected        bool // true if every node can reach every other node
+	Diameter         int  // longest shortest path inside the largest component
+
+	MinDegree     int
+	MaxDegree     int
+	AverageDegree float64
+	Degrees       map[int]int // degree -> number of nodes with that degree
+
+	ReachedTarget    int           // number of nodes that have reached the target at least once
+	MeanTimeToTarget time.Duration // average of nodes that reached the target
+	MaxTimeToTarget  time.Duration // slowest node that reached the target
+}
+
+// Graph returns the adjacency list of the current connections. Nodes are identified
+// by their index and connections to addresses outside of the simulation are ignored
+func (s *Simulation) Graph() [][]int {
+	adj := make([]map[int]bool, len(s.Nodes))
+	for i := range adj {
+		adj[i] = make(map[int]bool)
+	}
+
+	for i, n := range s.Nodes {
+		for _, m := range n.Network.GetPeerMetrics() {
+			j, ok := s.index[m.PeerAddress]
+			if !ok || j == i {
+				continue
+			}
+			adj[i][j] = true
+			adj[j][i] = true
+		}
+	}
+
+	graph := make([][]int, len(s.Nodes))
+	for i, set := range adj {
+		for j := range set {
+			graph[i] = append(graph[i], j)
+		}
+		sort.Ints(graph[i])
+	}
+	return graph
+}
+
+// bfs returns the distance of every node from the origin, -1 if unreachable
+func bfs(graph [][]int, origin int) []int {
+	dist := make([]int, len(graph))
+	for i := range dist {
+		dist[i] = -1
+	}
+	dist[origin] = 0
+	queue := []int{origin}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, next := range graph[cur] {
+			if dist[next] < 0 {
+				dist[next] = dist[cur] + 1
+				queue = append(queue, next)
+			}
+		}
+	}
+	return dist
+}
+
+// Report calculates the topology metrics of the current state of the simulation
+func (s *Simulation) Report() Report {
+	graph := s.Graph()
+
+	r := Report{}
+	r.Elapsed = s.elapsed
+	r.Nodes = len(graph)
+	r.Degrees = make(map[int]int)
+	r.MinDegree = -1
+
+	for _, neighbors := range graph {
+		d := len(neighbors)
+		r.Edges += d
+		r.Degrees[d]++
+		if r.MinDegree < 0 || d < r.MinDegree {
+			r.MinDegree = d
+		}
+		if d > r.MaxDegree {
+			r.MaxDegree = d
+		}
+	}
+	r.Edges /= 2
+	if r.Nodes > 0 {
+		r.AverageDegree = float64(r.Edges*2) / float64(r.Nodes)
+	}
+
+	// components
+	component := make([]int, len(graph))
+	for i := range component {
+		component[i] = -1
+	}
+	var largest []int
+	for i := range graph {
+		if component[i] >= 0 {
+			continue
+		}
+		var members []int
+		for j, d := range bfs(graph, i) {
+			if d >= 0 {
+				component[j] = r.Components
+				members = append(members, j)
+			}
+		}
+		if len(members) > len(largest) {
+			largest = members
+		}
+		r.Components++
+	}
+	r.LargestComponent = len(largest)
+	r.Connected = r.Components == 1
+
+	for _, i := range largest {
+		for _, d := range bfs(graph, i) {
+			if d > r.Diameter {
+				r.Diameter = d
+			}
+		}
+	}
+
+	var total time.Duration
+	for _, n := range s.Nodes {
+		if n.ReachedTarget {
+			r.ReachedTarget++
+			total += n.TimeToTarget
+			if n.TimeToTarget > r.MaxTimeToTarget {
+				r.MaxTimeToTarget = n.TimeToTarget
+			}
+		}
+	}
+	if r.ReachedTarget > 0 {
+		r.MeanTimeToTarget = total / time.Duration(r.ReachedTarget)
+	}
+
+	return r
+}
+
+func (r Report) String() string {
+	var degrees []int
+	for d := range r.Degrees {
+		degrees = append(degrees, d)
+	}
+	sort.Ints(degrees)
+	var dist []string
+	for _, d := range degrees {
+		dist = append(dist, fmt.Sprintf("%d:%d", d, r.Degrees[d]))
+	}
+
+	out := fmt.Sprintf("Elapsed: %s\n", r.Elapsed)
+	out += fmt.Sprintf("Nodes: %d, Edges: %d\n", r.Nodes, r.Edges)
+	out += fmt.Sprintf("Components: %d (largest %d), Connected: %v, Diameter: %d\n", r.Components, r.LargestComponent, r.Connected, r.Diameter)
+	out += fmt.Sprintf("Degree: min %d, max %d, avg %.2f\n", r.MinDegree, r.MaxDegree, r.AverageDegree)
+	out += fmt.Sprintf("Degree Distribution: %s\n", strings.Join(dist, " "))
+	out += fmt.Sprintf("Reached Target: %d/%d (mean %s, max %s)\n", r.ReachedTarget, r.Nodes, r.MeanTimeToTarget, r.MaxTimeToTarget)
+	return out
+}
@@ -0,0 +1,47 @@
+package p2p
+
+import "fmt"
+
+// maxStatusLength is the largest status payload accepted in a handshake
+const maxStatusLength = 1024
+
+// StatusProvider returns the application's current status, such as the chain
+// height, which is sent to peers in the handshake. It is called for every
+// handshake and should return quickly
+type StatusProvider func() []byte
+
+// StatusValidator checks the status a peer sent in its handshake. Returning an
+// error rejects the connection before the peer is added to the network.
+// The status is empty for peers without a status provider and old nodes
+type StatusValidator func(peer *Peer, status []byte) error
+
+// localStatus returns the status to include in our handshake
+func (n *Network) localStatus() []byte {
+	n.statusMtx.RLock()
+	provider := n.statusProvider
+	n.statusMtx.RUnlock()
+	if provider == nil {
+		return nil
+	}
+
+	status := provider()
+	if len(status) > maxStatusLength {
+		n.logger.Warnf("status of %d bytes exceeds the limit of %d bytes and was not sent", len(status), maxStatusLength)
+		return nil
+	}
+	return status
+}
+
+// validateStatus runs the application's validator on a peer's status
+func (n *Network) validateStatus(peer *Peer) error {
+	n.statusMtx.RLock()
+	validator := n.statusValidator
+	n.statusMtx.RUnlock()
+	if validator == nil {
+		return nil
+	}
+	if err := validator(peer, peer.Status); err != nil {
+		return fmt.Errorf("status rejected: %v", err)
+	}
+	return nil
+}
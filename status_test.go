@@ -0,0 +1,75 @@
+package p2p
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNetwork_Status(t *testing.T) {
+	mt := NewMemoryTransport()
+
+	newNode := func(ip, special, status string) *Network {
+		n, err := NewNetwork(testMemoryConfig(mt, ip, special))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != "" {
+			n.SetStatusProvider(func() []byte { return []byte(status) })
+		}
+		return n
+	}
+
+	a := newNode("10.0.0.1", "", "height 100")
+	b := newNode("10.0.0.2", "10.0.0.1:8108", "height 50")
+	c := newNode("10.0.0.3", "10.0.0.1:8108", "")
+
+	validated := make(chan string, 10)
+	a.SetStatusValidator(func(peer *Peer, status []byte) error {
+		validated <- peer.Endpoint.IP
+		if !peer.IsIncoming || peer.connected.IsZero() {
+			t.Errorf("validator saw peer %s before it was initialized", peer)
+		}
+		if string(status) == "height 50" {
+			return fmt.Errorf("incompatible height")
+		}
+		return nil
+	})
+
+	eventsB, cancelB := b.Events()
+	defer cancelB()
+	for _, n := range []*Network{a, b, c} {
+		n.Run()
+		defer n.Stop()
+	}
+
+	if !waitFor(time.Second*5, func() bool { return c.Total() == 1 && len(validated) >= 2 }) {
+		t.Fatalf("nodes did not connect")
+	}
+	if e := nextEvent(t, eventsB, EventPeerDisconnected); e.Reason != ReasonVetoed || !e.Remote {
+		t.Errorf("rejected node received disconnect %+v", e)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	for hash := range a.GetPeerMetrics() {
+		if hash[:8] != "10.0.0.3" {
+			t.Errorf("peer %s connected despite being rejected", hash)
+		}
+	}
+	for _, pm := range c.GetPeerMetrics() {
+		if !bytes.Equal(pm.Status, []byte("height 100")) {
+			t.Errorf("peer has status %q, want %q", pm.Status, "height 100")
+		}
+	}
+	for _, p := range a.controller.peers.Slice() {
+		if len(p.Status) != 0 {
+			t.Errorf("peer without provider has status %q", p.Status)
+		}
+	}
+
+	a.SetStatusProvider(func() []byte { return make([]byte, maxStatusLength+1) })
+	if status := a.localStatus(); status != nil {
+		t.Errorf("status exceeding the limit was not dropped")
+	}
+}
@@ -0,0 +1,51 @@
+package p2p
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Transport is the interface the controller uses to establish connections.
+// It is responsible for both dialing out and listening for incoming connections.
+//
+// The default is TCPTransport. MemoryTransport connects multiple instances
+// within the same process without the use of sockets.
+type Transport interface {
+	// Dial connects to the remote address in the format "ip:port". If local is not blank,
+	// the connection originates from that ip address
+	Dial(local, remote string, timeout time.Duration) (net.Conn, error)
+	// Listen starts listening on the address in the format "ip:port"
+	Listen(address string) (Listener, error)
+}
+
+// Listener is a generic listener for stream-oriented connections.
+// It is compatible with net.Listener
+type Listener interface {
+	Accept() (net.Conn, error)
+	Close() error
+	Addr() net.Addr
+}
+
+var _ Transport = (*TCPTransport)(nil)
+
+// TCPTransport is the default transport using regular tcp sockets
+type TCPTransport struct{}
+
+// Dial a remote address via tcp
+func (TCPTransport) Dial(local, remote string, timeout time.Duration) (net.Conn, error) {
+	laddr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:0", local))
+	if err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{
+		LocalAddr: laddr,
+		Timeout:   timeout,
+	}
+	return dialer.Dial("tcp", remote)
+}
+
+// Listen for tcp connections on the given address
+func (TCPTransport) Listen(address string) (Listener, error) {
+	return net.Listen("tcp", address)
+}
@@ -4,8 +4,10 @@ import (
 	"bufio"
 	"crypto/sha256"
 	"encoding/binary"
+	"math/rand"
 	"net"
 	"net/http"
+	"sync"
 )
 
 // IP2Location converts an ip address to a uint32
@@ -65,3 +67,31 @@ func WebScanner(url string, f func(line string)) error {
 	resp.Body.Close()
 	return nil
 }
+
+// lockedSource makes a rand.Source safe for concurrent use
+type lockedSource struct {
+	mtx sync.Mutex
+	src rand.Source64
+}
+
+func newLockedSource(seed int64) *lockedSource {
+	return &lockedSource{src: rand.NewSource(seed).(rand.Source64)}
+}
+
+func (ls *lockedSource) Int63() int64 {
+	ls.mtx.Lock()
+	defer ls.mtx.Unlock()
+	return ls.src.Int63()
+}
+
+func (ls *lockedSource) Uint64() uint64 {
+	ls.mtx.Lock()
+	defer ls.mtx.Unlock()
+	return ls.src.Uint64()
+}
+
+func (ls *lockedSource) Seed(seed int64) {
+	ls.mtx.Lock()
+	defer ls.mtx.Unlock()
+	ls.src.Seed(seed)
+}